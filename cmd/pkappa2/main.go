@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"container/ring"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -19,6 +20,7 @@ import (
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -42,6 +44,12 @@ const (
 
 	// Send pings to client with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
+
+	// Maximum size of a message from the client, it contains at most one query.
+	maxMessageSize = 64 << 10
+
+	// Maximum number of live query subscriptions of a client.
+	maxSubscriptionsPerClient = 10
 )
 
 var (
@@ -61,6 +69,33 @@ var (
 	startupCpuprofile = flag.String("startup_cpuprofile", "", "write cpu profile to file")
 )
 
+type (
+	// hostSetsInfo contains the names of the host sets containing the client and server host of a stream
+	hostSetsInfo struct {
+		Client, Server []string
+	}
+	// subscriptionRequest is sent by websocket clients to subscribe to the
+	// results of a query on newly imported streams or to unsubscribe again
+	subscriptionRequest struct {
+		Type         string
+		Subscription string
+		Query        string
+	}
+	// subscriptionMessage is sent to websocket clients for new matches of a
+	// subscribed query or if evaluating the query failed
+	subscriptionMessage struct {
+		Type         string
+		Subscription string
+		Results      []subscriptionResult `json:",omitempty"`
+		Error        string               `json:",omitempty"`
+	}
+	subscriptionResult struct {
+		Stream   *index.Stream
+		Tags     []string
+		HostSets hostSetsInfo
+	}
+)
+
 func main() {
 	// parse environment variables and if given, set as default values for flags
 	for _, env := range os.Environ() {
@@ -254,7 +289,8 @@ func main() {
 			return
 		}
 
-		var config manager.Config
+		// fields missing in the request keep their current value
+		config := mgr.Config()
 		if err = json.Unmarshal([]byte(body), &config); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -560,19 +596,56 @@ func main() {
 			http.Error(w, fmt.Sprintf("AllTags() failed: %v", err), http.StatusInternalServerError)
 			return
 		}
+		hostSets := hostSetsInfo{}
+		hostSets.Client, hostSets.Server, err = streamContext.HostSets()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("HostSets() failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		// the matches of the data conditions of a query in the returned data
+		matches := []manager.DataMatch(nil)
+		if q := r.URL.Query()["query"]; len(q) == 1 {
+			qq, err := query.Parse(q[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid query %q: %v", q[0], err), http.StatusBadRequest)
+				return
+			}
+			matcher, err := v.DataMatcher(qq)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("DataMatcher() failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			matches, err = streamContext.DataMatches(matcher, []string{converter})
+			if err != nil {
+				http.Error(w, fmt.Sprintf("DataMatches() failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		pcaps, err := streamContext.Stream().PcapFilenames()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("PcapFilenames() failed: %v", err), http.StatusInternalServerError)
+			return
+		}
 		// TODO: Send correct ClientBytes and ServerBytes when sending converter output.
 		response := struct {
 			Stream          *index.Stream
 			Data            []index.Data
 			Tags            []string
+			HostSets        hostSetsInfo
 			Converters      []string
 			ActiveConverter string
+			Pcaps           []string
+			// Matches is only set if requested using the query parameter
+			Matches []manager.DataMatch `json:",omitempty"`
 		}{
 			Stream:          streamContext.Stream(),
 			Data:            data,
 			Tags:            tags,
+			HostSets:        hostSets,
 			Converters:      converters,
 			ActiveConverter: converter,
+			Pcaps:           pcaps,
+			Matches:         matches,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -587,6 +660,11 @@ func main() {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		// the client started a new search, the view of its previous one
+		// does not have to be kept anymore
+		if s := r.URL.Query()["releaseCursor"]; len(s) == 1 {
+			mgr.ReleaseCursor(s[0])
+		}
 		qq, err := query.Parse(string(body))
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
@@ -610,13 +688,62 @@ func main() {
 			}
 			page = uint(n)
 		}
+		facets := false
+		if s := r.URL.Query()["facets"]; len(s) == 1 {
+			b, err := strconv.ParseBool(s[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid facets %q: %v", s[0], err), http.StatusBadRequest)
+				return
+			}
+			facets = b
+		}
+		snippets := false
+		if s := r.URL.Query()["snippets"]; len(s) == 1 {
+			b, err := strconv.ParseBool(s[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid snippets %q: %v", s[0], err), http.StatusBadRequest)
+				return
+			}
+			snippets = b
+		}
+		alternatives := false
+		if s := r.URL.Query()["alternatives"]; len(s) == 1 {
+			b, err := strconv.ParseBool(s[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid alternatives %q: %v", s[0], err), http.StatusBadRequest)
+				return
+			}
+			alternatives = b
+		}
+		// the cursor pins the view, reference time and sample seed for paging and re-sorting
+		cursor, newCursor := "", false
+		if s := r.URL.Query()["cursor"]; len(s) == 1 {
+			cursor = s[0]
+		} else {
+			cursor, err = mgr.NewCursor(qq.ReferenceTime)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("NewCursor failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			newCursor = true
+		}
 
+		type searchResult struct {
+			Stream   *index.Stream
+			Tags     []string
+			HostSets hostSetsInfo
+			// Matches is only set if requested using the snippets parameter
+			Matches []manager.DataMatch `json:",omitempty"`
+			// SubQueries contains the streams of each sub query the stream
+			// was matched with
+			SubQueries map[string][]*index.Stream `json:",omitempty"`
+			// GroupAlternatives is only set if requested using the
+			// alternatives parameter
+			GroupAlternatives []*index.Stream `json:",omitempty"`
+		}
 		response := struct {
-			Debug   []string
-			Results []struct {
-				Stream *index.Stream
-				Tags   []string
-			}
+			Debug       []string
+			Results     []searchResult
 			Elapsed     int64
 			Offset      uint
 			MoreResults bool
@@ -624,32 +751,104 @@ func main() {
 				Client []string
 				Server []string
 			}
+			// Facets is only set if requested using the facets parameter
+			Facets *manager.SearchFacets
+			Cursor string
 		}{
-			Debug: qq.Debug,
-			Results: []struct {
-				Stream *index.Stream
-				Tags   []string
-			}{},
+			Debug:   qq.Debug,
+			Results: []searchResult{},
 		}
 		start := time.Now()
-		v := mgr.GetView()
-		defer v.Release()
-		hasMore, offset, dataRegexes, err := v.SearchStreams(r.Context(), qq, func(c manager.StreamContext) error {
-			tags, err := c.AllTags()
+		hasMore, offset, dataRegexes := false, uint(0), (*index.DataRegexes)(nil)
+		err = mgr.WithCursor(cursor, func(v *manager.View, referenceTime time.Time, sampleSeed uint64) error {
+			// relative times of the query refer to now, move them to the pinned time
+			qq.Conditions = qq.Conditions.Clone()
+			qq.Conditions.UpdateReferenceTime(qq.ReferenceTime, referenceTime)
+			qq.ReferenceTime = referenceTime
+			if qq.Sampling != nil && qq.Sampling.Seed == nil {
+				qq.Sampling.Seed = &sampleSeed
+			}
+			matcher := (*manager.DataMatcher)(nil)
+			if snippets {
+				var err error
+				matcher, err = v.DataMatcher(qq)
+				if err != nil {
+					return fmt.Errorf("DataMatcher failed: %w", err)
+				}
+			}
+			options := []manager.StreamsOption{manager.Limit(100, page), manager.PrefetchAllTags(), manager.SubQueryStreams()}
+			if alternatives {
+				options = append(options, manager.GroupAlternatives())
+			}
+			var err error
+			hasMore, offset, dataRegexes, err = v.SearchStreams(r.Context(), qq, func(c manager.StreamContext) error {
+				tags, err := c.AllTags()
+				if err != nil {
+					return err
+				}
+				hostSets := hostSetsInfo{}
+				hostSets.Client, hostSets.Server, err = c.HostSets()
+				if err != nil {
+					return err
+				}
+				matches := []manager.DataMatch(nil)
+				if matcher != nil {
+					converters, err := c.AllConverters()
+					if err != nil {
+						return err
+					}
+					matches, err = c.DataMatches(matcher, append([]string{""}, converters...))
+					if err != nil {
+						return err
+					}
+				}
+				subQueries := map[string][]*index.Stream(nil)
+				for name, streams := range c.SubQueryStreams() {
+					if subQueries == nil {
+						subQueries = map[string][]*index.Stream{}
+					}
+					for _, s := range streams {
+						subQueries[name] = append(subQueries[name], s.Stream())
+					}
+				}
+				groupAlternatives := []*index.Stream(nil)
+				for _, s := range c.GroupAlternatives() {
+					groupAlternatives = append(groupAlternatives, s.Stream())
+				}
+				response.Results = append(response.Results, searchResult{
+					Stream:            c.Stream(),
+					Tags:              tags,
+					HostSets:          hostSets,
+					Matches:           matches,
+					SubQueries:        subQueries,
+					GroupAlternatives: groupAlternatives,
+				})
+				return nil
+			}, options...)
 			if err != nil {
-				return err
+				return fmt.Errorf("SearchStreams failed: %w", err)
+			}
+			if facets {
+				response.Facets, err = v.SearchFacets(r.Context(), qq)
+				if err != nil {
+					return fmt.Errorf("SearchFacets failed: %w", err)
+				}
 			}
-			response.Results = append(response.Results, struct {
-				Stream *index.Stream
-				Tags   []string
-			}{
-				Stream: c.Stream(),
-				Tags:   tags,
-			})
 			return nil
-		}, manager.Limit(100, page), manager.PrefetchAllTags())
+		})
+		if newCursor && (err != nil || r.Context().Err() != nil || (page == 0 && !hasMore)) {
+			// there are no other pages to keep the view for or the
+			// client gave up on the search and won't learn the cursor
+			mgr.ReleaseCursor(cursor)
+		} else {
+			response.Cursor = cursor
+		}
+		if errors.Is(err, manager.ErrCursorExpired) {
+			http.Error(w, fmt.Sprintf("Cursor %q expired", cursor), http.StatusGone)
+			return
+		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("SearchStreams failed: %v", err), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
@@ -666,11 +865,272 @@ func main() {
 			return
 		}
 	})
+	rUser.Post("/api/search.ndjson", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := uint(0)
+		if s := r.URL.Query()["page"]; len(s) == 1 {
+			n, err := strconv.ParseUint(s[0], 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid page %q: %v", s[0], err), http.StatusBadRequest)
+				return
+			}
+			page = uint(n)
+		}
+
+		// every line is a record, results are sent as soon as they are known,
+		// the last record is either of type done or error
+		type record struct {
+			Type   string
+			Result *struct {
+				Stream   *index.Stream
+				Tags     []string
+				HostSets hostSetsInfo
+			} `json:",omitempty"`
+			Progress    *index.SearchProgress `json:",omitempty"`
+			Debug       []string              `json:",omitempty"`
+			Elapsed     int64                 `json:",omitempty"`
+			Offset      uint                  `json:",omitempty"`
+			MoreResults bool                  `json:",omitempty"`
+			DataRegexes *index.DataRegexes    `json:",omitempty"`
+			Error       string                `json:",omitempty"`
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		send := func(rec record) error {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		qq, err := query.Parse(string(body))
+		if err != nil {
+			_ = send(record{
+				Type:  "error",
+				Error: err.Error(),
+			})
+			return
+		}
+		start := time.Now()
+		v := mgr.GetView()
+		defer v.Release()
+		hasMore, offset, dataRegexes, err := v.SearchStreamsIncremental(r.Context(), qq, func(c manager.StreamContext) error {
+			rec := record{
+				Type: "result",
+				Result: &struct {
+					Stream   *index.Stream
+					Tags     []string
+					HostSets hostSetsInfo
+				}{
+					Stream: c.Stream(),
+				},
+			}
+			var err error
+			rec.Result.Tags, err = c.AllTags()
+			if err != nil {
+				return err
+			}
+			rec.Result.HostSets.Client, rec.Result.HostSets.Server, err = c.HostSets()
+			if err != nil {
+				return err
+			}
+			return send(rec)
+		}, func(p index.SearchProgress) {
+			// a failing write cancels the search through the request context
+			_ = send(record{
+				Type:     "progress",
+				Progress: &p,
+			})
+		}, manager.Limit(100, page), manager.PrefetchAllTags())
+		if err != nil {
+			if r.Context().Err() == nil {
+				_ = send(record{
+					Type:  "error",
+					Error: fmt.Sprintf("SearchStreams failed: %v", err),
+				})
+			}
+			return
+		}
+		if dataRegexes == nil {
+			dataRegexes = &index.DataRegexes{}
+		}
+		_ = send(record{
+			Type:        "done",
+			Debug:       qq.Debug,
+			Elapsed:     time.Since(start).Microseconds(),
+			Offset:      offset,
+			MoreResults: hasMore,
+			DataRegexes: dataRegexes,
+		})
+	})
+	rUser.Post("/api/aggregate.json", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		qq, err := query.Parse(string(body))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			response := struct {
+				Error string
+			}{
+				Error: err.Error(),
+			}
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				http.Error(w, fmt.Sprintf("Encode failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+		bucket := time.Duration(0)
+		if s := r.URL.Query()["bucket"]; len(s) == 1 {
+			d, err := time.ParseDuration(s[0])
+			if err != nil || d < 0 {
+				http.Error(w, fmt.Sprintf("Invalid bucket %q", s[0]), http.StatusBadRequest)
+				return
+			}
+			bucket = d
+		}
+		start := time.Now()
+		v := mgr.GetView()
+		defer v.Release()
+		aggregation, err := v.AggregateStreams(r.Context(), qq, bucket)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("AggregateStreams failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		response := struct {
+			Debug       []string
+			Elapsed     int64
+			Aggregation *index.SearchAggregation
+		}{
+			Debug:       qq.Debug,
+			Elapsed:     time.Since(start).Microseconds(),
+			Aggregation: aggregation,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, fmt.Sprintf("Encode failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	})
+	rUser.Post("/api/explain.json", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		qq, err := query.Parse(string(body))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			response := struct {
+				Error string
+			}{
+				Error: err.Error(),
+			}
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				http.Error(w, fmt.Sprintf("Encode failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+		page := uint(0)
+		if s := r.URL.Query()["page"]; len(s) == 1 {
+			n, err := strconv.ParseUint(s[0], 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid page %q: %v", s[0], err), http.StatusBadRequest)
+				return
+			}
+			page = uint(n)
+		}
+		v := mgr.GetView()
+		defer v.Release()
+		explanation, err := v.ExplainSearch(r.Context(), qq, manager.Limit(100, page))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ExplainSearch failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		response := struct {
+			Debug       []string
+			Explanation *index.SearchExplanation
+		}{
+			Debug:       qq.Debug,
+			Explanation: explanation,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, fmt.Sprintf("Encode failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	})
+	rUser.Post("/api/query/complete", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q := string(body)
+		cursor := utf8.RuneCountInString(q)
+		if s := r.URL.Query()["cursor"]; len(s) == 1 {
+			n, err := strconv.Atoi(s[0])
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid cursor %q: %v", s[0], err), http.StatusBadRequest)
+				return
+			}
+			cursor = n
+		}
+		v := mgr.GetView()
+		defer v.Release()
+		completions, err := query.Complete(q, cursor, v.CompletionValues)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Complete failed: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(completions); err != nil {
+			http.Error(w, fmt.Sprintf("Encode failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+	})
 	rUser.Get("/api/graph.json", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		v := mgr.GetView()
+		defer v.Release()
+
 		var min, max time.Time
 		delta := 1 * time.Minute
-		if s := r.URL.Query()["delta"]; len(s) == 1 {
+		// truncate returns the start of the interval containing the time
+		truncate := func(t time.Time) time.Time {
+			return t.Truncate(delta)
+		}
+		// bucket returns the number of intervals between both times
+		bucket := func(t, start time.Time) uint64 {
+			return uint64(t.Sub(start) / delta)
+		}
+		if s := r.URL.Query()["delta"]; len(s) == 1 && s[0] == "tick" {
+			ticks, err := v.TickSchedule()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("TickSchedule failed: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if ticks == nil {
+				http.Error(w, "Invalid delta \"tick\": no tick schedule configured", http.StatusBadRequest)
+				return
+			}
+			delta = ticks.Length
+			truncate = func(t time.Time) time.Time {
+				return ticks.TickStart(ticks.Tick(t))
+			}
+			bucket = func(t, start time.Time) uint64 {
+				return uint64(ticks.Tick(t) - ticks.Tick(start))
+			}
+		} else if len(s) == 1 {
 			d, err := time.ParseDuration(s[0])
 			if err != nil || d <= 0 {
 				http.Error(w, fmt.Sprintf("Invalid delta %q: %v", s[0], err), http.StatusBadRequest)
@@ -684,7 +1144,7 @@ func main() {
 				http.Error(w, fmt.Sprintf("Invalid min time %q: %v", s[0], err), http.StatusBadRequest)
 				return
 			}
-			min = t.Truncate(delta)
+			min = truncate(t)
 		}
 		if s := r.URL.Query()["max"]; len(s) == 1 {
 			t, err := time.Parse("1", s[0])
@@ -692,7 +1152,7 @@ func main() {
 				http.Error(w, fmt.Sprintf("Invalid max time %q: %v", s[0], err), http.StatusBadRequest)
 				return
 			}
-			max = t.Truncate(delta)
+			max = truncate(t)
 		}
 		filter := (*query.Query)(nil)
 		if qs := r.URL.Query()["query"]; len(qs) == 1 {
@@ -770,9 +1230,6 @@ func main() {
 
 		groupingTags := r.URL.Query()["tag"]
 
-		v := mgr.GetView()
-		defer v.Release()
-
 		referenceTime, err := v.ReferenceTime()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("ReferenceTime failed: %v", err), http.StatusInternalServerError)
@@ -839,7 +1296,7 @@ func main() {
 					case AspectAnchorLast:
 						t = s.LastPacket().Local()
 					}
-					t = t.Truncate(delta)
+					t = truncate(t)
 					if skip = (!min.IsZero() && min.After(t)) || (!max.IsZero() && max.Before(t)); skip {
 						continue
 					}
@@ -921,8 +1378,8 @@ func main() {
 			tg := &tagGroups[tagGroupId]
 			data := [][]uint64(nil)
 			for d, v := range tg.counts {
-				t := referenceTime.Add(d).Sub(response.Min) / delta
-				data = append(data, append([]uint64{uint64(t)}, v...))
+				t := bucket(referenceTime.Add(d), response.Min)
+				data = append(data, append([]uint64{t}, v...))
 			}
 			sort.Slice(data, func(i, j int) bool {
 				return data[i][0] < data[j][0]
@@ -1007,6 +1464,40 @@ func main() {
 			return
 		}
 	})
+	rUser.Get("/api/hostsets", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(mgr.ListHostSets()); err != nil {
+			http.Error(w, fmt.Sprintf("Encode failed: %v", err), http.StatusInternalServerError)
+		}
+	})
+	rUser.Delete("/api/hostsets", func(w http.ResponseWriter, r *http.Request) {
+		n := r.URL.Query()["name"]
+		if len(n) != 1 || n[0] == "" {
+			http.Error(w, "`name` parameter missing", http.StatusBadRequest)
+			return
+		}
+		if err := mgr.DelHostSet(n[0]); err != nil {
+			http.Error(w, fmt.Sprintf("delete failed: %v", err), http.StatusBadRequest)
+			return
+		}
+	})
+	rUser.Put("/api/hostsets", func(w http.ResponseWriter, r *http.Request) {
+		n := r.URL.Query()["name"]
+		if len(n) != 1 || n[0] == "" {
+			http.Error(w, "`name` parameter missing or empty", http.StatusBadRequest)
+			return
+		}
+		hosts := []string(nil)
+		if err := json.NewDecoder(r.Body).Decode(&hosts); err != nil {
+			http.Error(w, fmt.Sprintf("invalid hosts: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := mgr.SetHostSet(n[0], hosts); err != nil {
+			http.Error(w, fmt.Sprintf("set failed: %v", err), http.StatusBadRequest)
+			return
+		}
+	})
 	rUser.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
 		if err != nil {
@@ -1019,10 +1510,27 @@ func main() {
 		ch, closer := mgr.Listen()
 		defer closer()
 
+		// messages of the subscriptions, they are sent by the writing loop
+		subscriptionMessages := make(chan subscriptionMessage)
+		handlerDone := make(chan struct{})
+		defer close(handlerDone)
+		sendSubscriptionMessage := func(msg subscriptionMessage) {
+			select {
+			case subscriptionMessages <- msg:
+			case <-handlerDone:
+			}
+		}
+
 		// Read from websocket to process control messages
 		clientClosed := make(chan struct{})
 		go func() {
-			c.SetReadLimit(512)
+			subscriptions := map[string]func(){}
+			defer func() {
+				for _, unsubscribe := range subscriptions {
+					unsubscribe()
+				}
+			}()
+			c.SetReadLimit(maxMessageSize)
 			if err := c.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
 				log.Printf("WebSocket SetReadDeadline failed: %v", err)
 				close(clientClosed)
@@ -1036,7 +1544,7 @@ func main() {
 				return nil
 			})
 			for {
-				_, _, err := c.ReadMessage()
+				_, msg, err := c.ReadMessage()
 				if err != nil {
 					if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 						log.Printf("WebSocket ReadMessage failed: %v", err)
@@ -1044,6 +1552,77 @@ func main() {
 					close(clientClosed)
 					return
 				}
+				req := subscriptionRequest{}
+				if err := json.Unmarshal(msg, &req); err != nil {
+					log.Printf("WebSocket Client %q sent invalid message: %v", c.RemoteAddr().String(), err)
+					continue
+				}
+				id := req.Subscription
+				if unsubscribe, ok := subscriptions[id]; ok {
+					unsubscribe()
+					delete(subscriptions, id)
+				}
+				switch req.Type {
+				case "subscribe":
+					if len(subscriptions) >= maxSubscriptionsPerClient {
+						sendSubscriptionMessage(subscriptionMessage{
+							Type:         "subscriptionError",
+							Subscription: id,
+							Error:        fmt.Sprintf("too many subscriptions, at most %d are allowed per client", maxSubscriptionsPerClient),
+						})
+						continue
+					}
+					qq, err := query.Parse(req.Query)
+					if err != nil {
+						sendSubscriptionMessage(subscriptionMessage{
+							Type:         "subscriptionError",
+							Subscription: id,
+							Error:        err.Error(),
+						})
+						continue
+					}
+					unsubscribe, err := mgr.Subscribe(qq, func(streams []manager.StreamContext, err error) {
+						msg := subscriptionMessage{
+							Type:         "subscriptionResults",
+							Subscription: id,
+							Results:      make([]subscriptionResult, 0, len(streams)),
+						}
+						for _, s := range streams {
+							if err != nil {
+								break
+							}
+							res := subscriptionResult{
+								Stream: s.Stream(),
+							}
+							res.Tags, err = s.AllTags()
+							if err != nil {
+								break
+							}
+							res.HostSets.Client, res.HostSets.Server, err = s.HostSets()
+							msg.Results = append(msg.Results, res)
+						}
+						if err != nil {
+							msg = subscriptionMessage{
+								Type:         "subscriptionError",
+								Subscription: id,
+								Error:        err.Error(),
+							}
+						}
+						sendSubscriptionMessage(msg)
+					})
+					if err != nil {
+						sendSubscriptionMessage(subscriptionMessage{
+							Type:         "subscriptionError",
+							Subscription: id,
+							Error:        err.Error(),
+						})
+						continue
+					}
+					subscriptions[id] = unsubscribe
+				case "unsubscribe":
+				default:
+					log.Printf("WebSocket Client %q sent unknown message type %q", c.RemoteAddr().String(), req.Type)
+				}
 			}
 		}()
 		// Write to websocket to send updates
@@ -1062,6 +1641,15 @@ func main() {
 					log.Printf("WebSocket WriteJSON failed: %v", err)
 					break outer
 				}
+			case msg := <-subscriptionMessages:
+				if err := c.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+					log.Printf("WebSocket SetWriteDeadline failed: %v", err)
+					break outer
+				}
+				if err := c.WriteJSON(msg); err != nil {
+					log.Printf("WebSocket WriteJSON failed: %v", err)
+					break outer
+				}
 			case <-pingTicker.C:
 				if err := c.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 					log.Printf("WebSocket SetWriteDeadline failed: %v", err)
@@ -0,0 +1,52 @@
+package index
+
+import (
+	"math"
+)
+
+// byteHistogram counts the byte values of the data of one direction of a stream.
+type byteHistogram [256]uint64
+
+func (h *byteHistogram) Write(data []byte) {
+	for _, b := range data {
+		h[b]++
+	}
+}
+
+func (h *byteHistogram) total() uint64 {
+	n := uint64(0)
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// entropy returns the Shannon entropy of the data in thousandths of a bit per byte.
+func (h *byteHistogram) entropy() uint16 {
+	n := float64(h.total())
+	if n == 0 {
+		return 0
+	}
+	e := 0.0
+	for _, c := range h {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return uint16(math.Round(e * 1000))
+}
+
+// printable returns the ratio of printable ascii bytes in the data in thousandths.
+func (h *byteHistogram) printable() uint16 {
+	n := h.total()
+	if n == 0 {
+		return 0
+	}
+	printable := h['\t'] + h['\n'] + h['\r']
+	for b := ' '; b <= '~'; b++ {
+		printable += h[b]
+	}
+	return uint16(printable * 1000 / n)
+}
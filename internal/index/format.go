@@ -13,10 +13,14 @@ const (
 	sectionImports
 	sectionImportFilenames
 	sectionStreams
+	sectionStreamSketches
 	sectionStreamsByStreamID
 	sectionStreamsByFirstPacketSource
 	sectionStreamsByFirstPacketTime
 	sectionStreamsByLastPacketTime
+	sectionStreamsByClientHost
+	sectionStreamsByServerHost
+	sectionStreamsByServerPort
 	sectionsCount int = iota
 )
 
@@ -54,16 +58,40 @@ type (
 		DataStart              uint64
 		ClientBytes            uint64
 		ServerBytes            uint64
+		ClientHash             uint64
+		ServerHash             uint64
+		ClientPrefixHash       uint64
+		ServerPrefixHash       uint64
+		ClientPackets          uint32
+		ServerPackets          uint32
+		ClientChunks           uint32
+		ServerChunks           uint32
+		ClientEntropy          uint16
+		ServerEntropy          uint16
+		ClientPrintable        uint16
+		ServerPrintable        uint16
 		PacketInfoStart        uint32
 		Flags                  uint16
 		HostGroup              uint16
 		ClientHost, ServerHost uint16
 		ClientPort, ServerPort uint16
 	}
+	// streamSketches are the similarity sketches of a stream, they are
+	// stored in their own section by stream index as only similarity
+	// filters need them
+	streamSketches struct {
+		Client similaritySketch
+		Server similaritySketch
+	}
 )
 
 const (
-	fileMagic = "pkappa2index\x00\x00\x00\x02"
+	// the magic ends with the big-endian version of the index format
+	fileMagic              = "pkappa2index\x00\x00\x00\x03"
+	fileMagicVersionOffset = len("pkappa2index")
+
+	// number of bytes of each direction covered by the prefix hashes
+	fingerprintPrefixSize = 128
 
 	flagsHostGroupIPVersion = 0b1
 	flagsHostGroupIP4       = 0b0
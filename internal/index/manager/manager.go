@@ -3,6 +3,8 @@ package manager
 import (
 	"bytes"
 	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,14 +12,17 @@ import (
 	"log"
 	"maps"
 	"math"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -39,11 +44,35 @@ import (
 const (
 	// Request timeout for webhooks
 	pcapProcessorWebhookTimeout = time.Second * 5
+	// Default interval for updating tags using relative times
+	defaultRelativeTagInterval = time.Minute
+	// Default time a search cursor is kept alive after its last use
+	defaultCursorTTL = 5 * time.Minute
+	// maxCursors limits the number of search cursors, creating another one
+	// releases the cursor that would expire first
+	maxCursors = 100
+	// maxSubscriptions limits the number of live query subscriptions, each
+	// of them is searched after every import
+	maxSubscriptions = 100
+
+	// maxDataMatches limits the number of data matches returned per stream
+	maxDataMatches = 100
+	// dataMatchContext is the number of bytes before and after a match
+	// included in its snippet
+	dataMatchContext = 32
 
 	pcapOverIPCmdFlush = pcapOverIPCmd(iota)
 	pcapOverIPCmdClose
 )
 
+var (
+	// ErrCursorExpired is returned when using an unknown or expired cursor.
+	ErrCursorExpired = errors.New("cursor expired")
+	// ErrTooManySubscriptions is returned by Subscribe when the limit of
+	// subscriptions is reached.
+	ErrTooManySubscriptions = errors.New("too many subscriptions")
+)
+
 type (
 	PcapStatistics struct {
 		PcapCount         int
@@ -62,7 +91,9 @@ type (
 		PcapStats           *PcapStatistics           `json:",omitempty"`
 		Config              *Config                   `json:",omitempty"`
 		Webhooks            *[]string                 `json:",omitempty"`
+		HostSets            *map[string][]string      `json:",omitempty"`
 		PcapOverIPEndpoints *[]PcapOverIPEndpointInfo `json:",omitempty"`
+		MergeProgress       *index.MergeProgress      `json:",omitempty"`
 	}
 
 	PcapOverIPEndpointInfo struct {
@@ -112,20 +143,29 @@ type (
 		ConverterDir string
 		WatchDir     string
 
-		jobs                chan func()
-		mergeJobRunning     bool
-		taggingJobRunning   bool
-		converterJobRunning bool
-		importJobs          []string
-
-		builder             *builder.Builder
-		indexes             []*index.Reader
-		nStreamRecords      int
-		nPacketRecords      int
-		nextStreamID        uint64
-		nUnmergeableIndexes int
-		stateFilename       string
-		allStreams          bitmask.LongBitmask
+		jobs                  chan func()
+		mergeJobRunning       bool
+		taggingJobRunning     bool
+		converterJobRunning   bool
+		relativeTagJobRunning bool
+		relativeTagTimer      *time.Timer
+		closed                bool
+		importJobs            []string
+		// done is closed when the manager is closed, so timers and
+		// workers stop submitting jobs
+		done chan struct{}
+
+		builder            *builder.Builder
+		indexes            []*index.Reader
+		nStreamRecords     int
+		nPacketRecords     int
+		nextStreamID       uint64
+		unmergeableIndexes map[*index.Reader]struct{}
+		mergeProgress      *index.MergeProgress
+		stateFilename      string
+		allStreams         bitmask.LongBitmask
+		// importedPcapNames caches importedPcaps, it is reset by imports
+		importedPcapNames []string
 
 		updatedStreamsDuringTaggingJob bitmask.LongBitmask
 		resetStreamsDuringTaggingJob   bitmask.LongBitmask
@@ -133,6 +173,7 @@ type (
 
 		streamsToConvert         map[string]*bitmask.LongBitmask
 		pcapProcessorWebhookUrls []string
+		hostSets                 query.HostSets
 		pcapOverIPEndpoints      []*pcapOverIPEndpoint
 
 		pcapOverIPPackets chan pcapOverIPPacket
@@ -147,9 +188,41 @@ type (
 
 		listeners map[chan Event]listener
 
+		cursors map[string]*cursor
+
+		subscriptions map[*subscription]struct{}
+
 		config Config
 	}
 
+	// subscription evaluates a query on the streams added or updated by imports.
+	subscription struct {
+		filter *query.Query
+		f      func([]StreamContext, error)
+		// pending contains the streams not yet evaluated, it is only
+		// accessed from the mgr goroutine
+		pending bitmask.LongBitmask
+		notify  chan struct{}
+		ctx     context.Context
+		cancel  func()
+	}
+
+	// cursor pins a view and the reference time of a search, so paging
+	// through the results is not affected by imports, merges and time.
+	cursor struct {
+		// mu serializes the use of the view, it caches tag evaluations
+		mu            sync.Mutex
+		view          View
+		referenceTime time.Time
+		// sampleSeed is used for samples without a seed, so all pages
+		// come from the same sample
+		sampleSeed uint64
+		released   bool
+		// expires and timer are only accessed from the mgr goroutine
+		expires time.Time
+		timer   *time.Timer
+	}
+
 	Statistics struct {
 		ImportJobCount      int
 		IndexCount          int
@@ -160,12 +233,43 @@ type (
 		StreamRecordCount   int
 		PacketRecordCount   int
 		MergeJobRunning     bool
+		MergeProgress       *index.MergeProgress `json:",omitempty"`
 		TaggingJobRunning   bool
 		ConverterJobRunning bool
 	}
 
 	Config struct {
 		AutoInsertLimitToQuery bool
+		Compaction             CompactionPolicy
+		// RelativeTagInterval is the interval in which tags using relative
+		// times are updated, zero selects the default of one minute.
+		RelativeTagInterval time.Duration
+		// Ticks is the tick schedule of the CTF used by tick filters,
+		// sorting and grouping.
+		Ticks *query.TickSchedule `json:",omitempty"`
+		// CursorTTL is the time a search cursor is kept alive after its
+		// last use, zero selects the default of five minutes.
+		CursorTTL time.Duration
+	}
+
+	// CompactionPolicy controls which indexes are merged in the background.
+	// Indexes are sorted into size tiers, the first tier contains indexes
+	// smaller than TierBaseSize and every following tier contains indexes
+	// up to TierFactor times larger than the previous one. As soon as
+	// MinMergeCount adjacent indexes are in the same tier, they are merged.
+	// Zero values select the defaults.
+	CompactionPolicy struct {
+		TierBaseSize  int64
+		TierFactor    int
+		MinMergeCount int
+		// MaxIndexSize stops indexes from growing beyond the given size
+		// through merges, 0 means unlimited.
+		MaxIndexSize int64
+		// MaxMergeDuration limits the time spent in a single merge job,
+		// the remaining indexes are merged in a later job.
+		MaxMergeDuration time.Duration
+		// CPULimit is the fraction of a cpu core a merge job may use.
+		CPULimit float64
 	}
 
 	indexReleaser []*index.Reader
@@ -183,6 +287,7 @@ type (
 		Pcaps                    []*pcapmetadata.PcapInfo
 		PcapProcessorWebhookUrls []string
 		PcapOverIPEndpoints      []string
+		HostSets                 map[string][]string
 		Config                   Config
 	}
 
@@ -204,17 +309,54 @@ type (
 		tagDetails    map[string]query.TagDetails
 		tagConverters map[string][]string
 		converters    map[string]index.ConverterAccess
+		hostSets      query.HostSets
+		ticks         *query.TickSchedule
+		imports       []string
 	}
 
 	StreamContext struct {
 		s *index.Stream
 		v *View
+		// details are the details of the search that returned the stream
+		details *index.ResultDetails
+	}
+
+	// DataMatch is a match of a data condition in the data of a stream.
+	DataMatch struct {
+		// Converter is the converter whose output matched, it is empty
+		// for the captured data
+		Converter string
+		Direction index.Direction
+		// Start and End are the offsets of the match in the data of
+		// the direction
+		Start, End int
+		// Snippet contains the match with some data around it, starting
+		// at SnippetStart
+		Snippet      []byte
+		SnippetStart int
+	}
+	// DataMatcher finds the matches of the data conditions of a query.
+	DataMatcher struct {
+		regexes []index.DataConditionRegex
+	}
+
+	// SearchFacets contains the number of streams in a search result per
+	// service, tag, server port and client host. Services and tags are
+	// identified by their full name, e.g. `service/foo` or `mark/bar`.
+	SearchFacets struct {
+		Services    map[string]uint
+		Tags        map[string]uint
+		ServerPorts map[uint16]uint
+		ClientHosts map[string]uint
 	}
 
 	streamsOptions struct {
 		prefetchTags       []string
 		defaultLimit, page uint
 		prefetchAllTags    bool
+		limitIDs           *bitmask.LongBitmask
+		subQueryStreams    bool
+		groupAlternatives  bool
 	}
 	StreamsOption func(*streamsOptions)
 )
@@ -234,7 +376,12 @@ func New(pcapDir, indexDir, snapshotDir, stateDir, converterDir, watchDir string
 		converters:       make(map[string]*converters.CachedConverter),
 		streamsToConvert: make(map[string]*bitmask.LongBitmask),
 		jobs:             make(chan func()),
+		done:             make(chan struct{}),
 		listeners:        make(map[chan Event]listener),
+		cursors:          make(map[string]*cursor),
+		subscriptions:    make(map[*subscription]struct{}),
+
+		unmergeableIndexes: make(map[*index.Reader]struct{}),
 
 		config: Config{AutoInsertLimitToQuery: false},
 	}
@@ -281,6 +428,14 @@ func New(pcapDir, indexDir, snapshotDir, stateDir, converterDir, watchDir string
 	}
 	for _, fn := range indexFileNames {
 		idx, err := index.NewReader(fn)
+		if errors.Is(err, index.ErrUnsupportedFormat) {
+			// skipping the index would silently drop its streams and
+			// reuse their ids, so refuse to start instead
+			for _, idx := range mgr.indexes {
+				idx.Close()
+			}
+			return nil, fmt.Errorf("index %q was written by a different version of pkappa2 (%w), remove the index and snapshot files and import the pcaps again to rebuild them", fn, err)
+		}
 		if err != nil {
 			log.Printf("Unable to load index %q: %v", fn, err)
 			continue
@@ -322,6 +477,19 @@ nextStateFile:
 		if s.Saved.Before(stateTimestamp) {
 			continue
 		}
+		newHostSets := make(query.HostSets, len(s.HostSets))
+		for n, hosts := range s.HostSets {
+			if !query.ValidHostSetName(n) {
+				log.Printf("Invalid host set %q in statefile %q: invalid name", n, fn)
+				continue nextStateFile
+			}
+			hs, err := query.NewHostSet(hosts)
+			if err != nil {
+				log.Printf("Invalid host set %q in statefile %q: %v", n, fn, err)
+				continue nextStateFile
+			}
+			newHostSets[n] = hs
+		}
 		newTags := make(map[string]*tag, len(s.Tags))
 		for _, t := range s.Tags {
 			q, err := query.Parse(t.Definition)
@@ -337,9 +505,10 @@ nextStateFile:
 			matches.Shrink()
 			nt := &tag{
 				TagDetails: query.TagDetails{
-					Matches:    matches,
-					Uncertain:  mgr.allStreams,
-					Conditions: q.Conditions,
+					Matches:       matches,
+					Uncertain:     mgr.allStreams,
+					Conditions:    q.Conditions,
+					ReferenceTime: q.ReferenceTime,
 				},
 				definition:   t.Definition,
 				features:     q.Conditions.Features(),
@@ -415,6 +584,7 @@ nextStateFile:
 			pcapOverIPEndpointsTemp[v] = struct{}{}
 		}
 		mgr.tags = newTags
+		mgr.hostSets = newHostSets
 		mgr.pcapProcessorWebhookUrls = s.PcapProcessorWebhookUrls
 		mgr.stateFilename = fn
 		mgr.config = s.Config
@@ -445,6 +615,7 @@ nextStateFile:
 		mgr.startTaggingJobIfNeeded()
 		mgr.startConverterJobIfNeeded()
 		mgr.startMergeJobIfNeeded()
+		mgr.scheduleRelativeTagJob()
 		for a := range pcapOverIPEndpoints {
 			mgr.pcapOverIPEndpoints = append(mgr.pcapOverIPEndpoints, mgr.newPcapOverIPEndpoint(ctx, a))
 		}
@@ -483,6 +654,11 @@ func (mgr *Manager) Close() {
 	}
 	c := make(chan struct{})
 	mgr.jobs <- func() {
+		mgr.closed = true
+		close(mgr.done)
+		if mgr.relativeTagTimer != nil {
+			mgr.relativeTagTimer.Stop()
+		}
 		for _, converter := range mgr.converters {
 			if err := converter.Close(); err != nil {
 				log.Printf("Failed to close converter %q: %v", converter.Name(), err)
@@ -498,6 +674,12 @@ func (mgr *Manager) Close() {
 		for _, e := range mgr.pcapOverIPEndpoints {
 			e.cancel()
 		}
+		for _, c := range mgr.cursors {
+			c.timer.Stop()
+		}
+		for s := range mgr.subscriptions {
+			s.cancel()
+		}
 		mgr.pcapOverIPCmd <- pcapOverIPCmdClose
 		close(c)
 	}
@@ -510,6 +692,7 @@ func (mgr *Manager) saveState() error {
 		Pcaps:                    mgr.builder.KnownPcaps(),
 		PcapProcessorWebhookUrls: mgr.pcapProcessorWebhookUrls,
 		PcapOverIPEndpoints:      make([]string, 0, len(mgr.pcapOverIPEndpoints)),
+		HostSets:                 mgr.hostSetHosts(),
 		Config:                   mgr.config,
 	}
 	for _, e := range mgr.pcapOverIPEndpoints {
@@ -591,7 +774,7 @@ func (mgr *Manager) inheritTagUncertainty() {
 func (mgr *Manager) invalidateTags(updatedStreams, resetStreams, addedStreams bitmask.LongBitmask) {
 	for tn, ti := range mgr.tags {
 		tin := *ti
-		if ti.features.SubQueryFeatures != 0 {
+		if ti.features.SubQueryFeatures != 0 || ti.features.RelativeImports {
 			//TODO: is a matching stream really uncertain?
 			tin.Uncertain = mgr.allStreams
 		} else if ti.features.MainFeatures&^query.FeatureFilterID == 0 {
@@ -600,7 +783,7 @@ func (mgr *Manager) invalidateTags(updatedStreams, resetStreams, addedStreams bi
 			tin.Uncertain = ti.Uncertain.Copy()
 			tin.Uncertain.Or(addedStreams)
 			tin.Uncertain.Or(resetStreams)
-			if ti.features.MainFeatures&(query.FeatureFilterData|query.FeatureFilterTimeAbsolute|query.FeatureFilterTimeRelative) != 0 {
+			if ti.features.MainFeatures&(query.FeatureFilterData|query.FeatureFilterMetadata|query.FeatureFilterTimeAbsolute|query.FeatureFilterTimeRelative) != 0 {
 				tin.Uncertain.Or(updatedStreams)
 			}
 		}
@@ -630,6 +813,7 @@ func (mgr *Manager) importPcapJob(filenames []string, nextStreamID uint64, exist
 	}
 	mgr.jobs <- func() {
 		mgr.allStreams = allStreams
+		mgr.importedPcapNames = nil
 		existingIndexesReleaser.release(mgr)
 		// add new indexes if some were created
 		if len(createdIndexes) > 0 {
@@ -643,6 +827,7 @@ func (mgr *Manager) importPcapJob(filenames []string, nextStreamID uint64, exist
 			mgr.addedStreamsDuringTaggingJob.Or(*addedStreams)
 			mgr.invalidateTags(*updatedStreams, *resetStreams, *addedStreams)
 			mgr.invalidateConverters(updatedStreams)
+			mgr.notifySubscriptions(*updatedStreams, *resetStreams, *addedStreams)
 		}
 		// remove finished job from queue
 		mgr.importJobs = mgr.importJobs[processedFiles:]
@@ -675,6 +860,43 @@ func (mgr *Manager) importPcapJob(filenames []string, nextStreamID uint64, exist
 	}
 }
 
+func (p CompactionPolicy) withDefaults() CompactionPolicy {
+	if p.TierBaseSize == 0 {
+		p.TierBaseSize = 4 << 20
+	}
+	if p.TierFactor == 0 {
+		p.TierFactor = 4
+	}
+	if p.MinMergeCount == 0 {
+		p.MinMergeCount = 4
+	}
+	return p
+}
+
+func (p CompactionPolicy) validate() error {
+	if p.TierBaseSize < 0 || p.MaxIndexSize < 0 || p.MaxMergeDuration < 0 {
+		return errors.New("compaction sizes and durations must not be negative")
+	}
+	if p.TierFactor < 0 || p.TierFactor == 1 {
+		return errors.New("compaction tier factor must be at least 2")
+	}
+	if p.MinMergeCount < 0 || p.MinMergeCount == 1 {
+		return errors.New("compaction merge count must be at least 2")
+	}
+	if p.CPULimit < 0 || p.CPULimit > 1 {
+		return errors.New("compaction cpu limit must be between 0 and 1")
+	}
+	return nil
+}
+
+func (p CompactionPolicy) tier(idx *index.Reader) int {
+	t := 0
+	for sz := p.TierBaseSize; idx.Size() >= sz && sz > 0; sz *= int64(p.TierFactor) {
+		t++
+	}
+	return t
+}
+
 func (mgr *Manager) startMergeJobIfNeeded() {
 	if mgr.mergeJobRunning || mgr.taggingJobRunning || mgr.converterJobRunning {
 		return
@@ -685,16 +907,31 @@ func (mgr *Manager) startMergeJobIfNeeded() {
 			return
 		}
 	}
-	nStreams := mgr.nStreamRecords
-	for i, idx := range mgr.indexes {
-		c := idx.StreamCount()
-		nStreams -= c
-		if i >= mgr.nUnmergeableIndexes && c < nStreams {
+	policy := mgr.config.Compaction.withDefaults()
+	mergeable := func(idx *index.Reader) bool {
+		if _, ok := mgr.unmergeableIndexes[idx]; ok {
+			return false
+		}
+		return policy.MaxIndexSize == 0 || idx.Size() < policy.MaxIndexSize
+	}
+	// find the oldest run of adjacent indexes in the same tier
+	for start := 0; start < len(mgr.indexes); {
+		if !mergeable(mgr.indexes[start]) {
+			start++
+			continue
+		}
+		tier := policy.tier(mgr.indexes[start])
+		end := start + 1
+		for end < len(mgr.indexes) && mergeable(mgr.indexes[end]) && policy.tier(mgr.indexes[end]) == tier {
+			end++
+		}
+		if end-start >= policy.MinMergeCount {
 			mgr.mergeJobRunning = true
-			indexes, indexesReleaser := mgr.getIndexesCopy(i)
-			go mgr.mergeIndexesJob(i, indexes, indexesReleaser)
+			indexes := append([]*index.Reader(nil), mgr.indexes[start:end]...)
+			go mgr.mergeIndexesJob(start, indexes, mgr.lock(indexes), policy)
 			return
 		}
+		start = end
 	}
 }
 
@@ -725,13 +962,147 @@ outer:
 		for converterName, converter := range mgr.converters {
 			converters[converterName] = converter
 		}
-		go mgr.updateTagJob(n, *t, tagDetails, converters, indexes, releaser)
+		go mgr.updateTagJob(n, *t, tagDetails, mgr.hostSets, mgr.config.Ticks, mgr.importedPcaps(), converters, indexes, releaser)
+		return
+	}
+}
+
+func hasRelativeTime(features query.FeatureSet) bool {
+	return (features.MainFeatures|features.SubQueryFeatures)&query.FeatureFilterTimeRelative != 0
+}
+
+func (c Config) cursorTTL() time.Duration {
+	if c.CursorTTL == 0 {
+		return defaultCursorTTL
+	}
+	return c.CursorTTL
+}
+
+func (c Config) relativeTagInterval() time.Duration {
+	if c.RelativeTagInterval == 0 {
+		return defaultRelativeTagInterval
+	}
+	return c.RelativeTagInterval
+}
+
+func (mgr *Manager) scheduleRelativeTagJob() {
+	if mgr.relativeTagTimer != nil {
+		mgr.relativeTagTimer.Stop()
+	}
+	if mgr.closed {
+		return
+	}
+	mgr.relativeTagTimer = time.AfterFunc(mgr.config.relativeTagInterval(), func() {
+		mgr.submitJob(mgr.startRelativeTagJobIfNeeded)
+	})
+}
+
+func (mgr *Manager) startRelativeTagJobIfNeeded() {
+	if mgr.relativeTagJobRunning {
+		return
+	}
+	tags := map[string]tag{}
+	for n, t := range mgr.tags {
+		if hasRelativeTime(t.features) {
+			tags[n] = *t
+		}
+	}
+	if len(tags) == 0 {
+		mgr.scheduleRelativeTagJob()
 		return
 	}
+	mgr.relativeTagJobRunning = true
+	indexes, releaser := mgr.getIndexesCopy(0)
+	go mgr.relativeTagJob(time.Now(), tags, mgr.hostSets, mgr.config.Ticks, mgr.importedPcaps(), indexes, releaser)
+}
+
+// relativeTagJob moves the reference time of tags using relative times and
+// marks all streams as uncertain that might have changed their state.
+func (mgr *Manager) relativeTagJob(referenceTime time.Time, tags map[string]tag, hostSets query.HostSets, ticks *query.TickSchedule, imports []string, indexes []*index.Reader, releaser indexReleaser) {
+	// a nil bitmask means, that all streams might have changed
+	changes := make(map[string]*bitmask.LongBitmask, len(tags))
+	for name, t := range tags {
+		changed, err := func() (*bitmask.LongBitmask, error) {
+			q, err := query.Parse(t.definition)
+			if err != nil {
+				return nil, err
+			}
+			if t.features.RelativeTicks && ticks != nil && ticks.Tick(t.ReferenceTime) != ticks.Tick(referenceTime) {
+				// the current tick changed
+				return nil, nil
+			}
+			q.Conditions.UpdateReferenceTime(q.ReferenceTime, referenceTime)
+			cs, ok := q.Conditions.RelativeTimeChanges(t.ReferenceTime, referenceTime)
+			if !ok {
+				return nil, nil
+			}
+			changed := &bitmask.LongBitmask{}
+			if len(cs) == 0 {
+				return changed, nil
+			}
+			streams, _, _, err := index.SearchStreams(context.Background(), indexes, cs, index.SearchOptions{
+				ReferenceTime: referenceTime,
+				Sorting:       []query.Sorting{{Key: query.SortingKeyID, Dir: query.SortingDirAscending}},
+				HostSets:      hostSets,
+				Ticks:         ticks,
+				Imports:       imports,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range streams {
+				changed.Set(uint(s.ID()))
+			}
+			return changed, nil
+		}()
+		if err != nil {
+			log.Printf("relativeTagJob failed for tag %q: %q", name, err)
+		}
+		changes[name] = changed
+	}
+	mgr.jobs <- func() {
+		for name, changed := range changes {
+			// don't touch the tag if it was modified
+			ot, ok := mgr.tags[name]
+			if !ok || ot.definition != tags[name].definition || !ot.ReferenceTime.Equal(tags[name].ReferenceTime) {
+				continue
+			}
+			t := *ot
+			if changed == nil {
+				t.Uncertain = mgr.allStreams
+			} else if !changed.IsZero() {
+				t.Uncertain = ot.Uncertain.Copy()
+				t.Uncertain.Or(*changed)
+			}
+			// the conditions are shared with views, so they are copied
+			t.Conditions = ot.Conditions.Clone()
+			t.Conditions.UpdateReferenceTime(ot.ReferenceTime, referenceTime)
+			t.ReferenceTime = referenceTime
+			mgr.tags[name] = &t
+		}
+		mgr.inheritTagUncertainty()
+		mgr.relativeTagJobRunning = false
+		mgr.startTaggingJobIfNeeded()
+		mgr.scheduleRelativeTagJob()
+		releaser.release(mgr)
+	}
 }
 
-func (mgr *Manager) mergeIndexesJob(offset int, indexes []*index.Reader, releaser indexReleaser) {
-	mergedIndexes, err := index.Merge(mgr.IndexDir, indexes)
+func (mgr *Manager) mergeIndexesJob(offset int, indexes []*index.Reader, releaser indexReleaser, policy CompactionPolicy) {
+	mergedIndexes, nMerged, err := index.MergeWithOptions(mgr.IndexDir, indexes, index.MergeOptions{
+		MaxIndexSize: policy.MaxIndexSize,
+		MaxDuration:  policy.MaxMergeDuration,
+		CPULimit:     policy.CPULimit,
+		Progress: func(progress index.MergeProgress) {
+			mgr.jobs <- func() {
+				mgr.mergeProgress = &progress
+				mgr.event(Event{
+					Type:          "mergeProgress",
+					MergeProgress: &progress,
+				})
+			}
+		},
+	})
 	if err != nil {
 		indexFilenames := []string{}
 		for _, i := range indexes {
@@ -739,29 +1110,35 @@ func (mgr *Manager) mergeIndexesJob(offset int, indexes []*index.Reader, release
 		}
 		log.Printf("mergeIndexesJob(%d, [%q]) failed: %s", offset, indexFilenames, err)
 	}
+	// only the newest nMerged indexes were merged
+	offset += len(indexes) - nMerged
 	streamsDiff, packetsDiff := 0, 0
 	for _, idx := range mergedIndexes {
 		streamsDiff += idx.StreamCount()
 		packetsDiff += idx.PacketCount()
 	}
-	for _, idx := range indexes {
+	for _, idx := range indexes[len(indexes)-nMerged:] {
 		streamsDiff -= idx.StreamCount()
 		packetsDiff -= idx.PacketCount()
 	}
 	mgr.jobs <- func() {
 		// replace old indexes if successfully created
 		if len(mergedIndexes) == 0 || err != nil {
-			mgr.nUnmergeableIndexes++
+			mgr.unmergeableIndexes[indexes[0]] = struct{}{}
 		} else {
-			rel := indexReleaser(mgr.indexes[offset : offset+len(indexes)])
+			rel := indexReleaser(mgr.indexes[offset : offset+nMerged])
 			rel.release(mgr)
 			mgr.lock(mergedIndexes)
-			mgr.indexes = append(mgr.indexes[:offset], append(mergedIndexes, mgr.indexes[offset+len(indexes):]...)...)
-			mgr.nUnmergeableIndexes += len(mergedIndexes) - 1
+			mgr.indexes = append(mgr.indexes[:offset], append(mergedIndexes, mgr.indexes[offset+nMerged:]...)...)
+			// all but the last created index are full
+			for _, idx := range mergedIndexes[:len(mergedIndexes)-1] {
+				mgr.unmergeableIndexes[idx] = struct{}{}
+			}
 			mgr.nStreamRecords += streamsDiff
 			mgr.nPacketRecords += packetsDiff
 		}
 		mgr.mergeJobRunning = false
+		mgr.mergeProgress = nil
 		mgr.startMergeJobIfNeeded()
 		releaser.release(mgr)
 		mgr.event(Event{
@@ -779,13 +1156,24 @@ func (mgr *Manager) mergeIndexesJob(offset int, indexes []*index.Reader, release
 	}
 }
 
-func (mgr *Manager) updateTagJob(name string, t tag, tagDetails map[string]query.TagDetails, converters map[string]index.ConverterAccess, indexes []*index.Reader, releaser indexReleaser) {
+func (mgr *Manager) updateTagJob(name string, t tag, tagDetails map[string]query.TagDetails, hostSets query.HostSets, ticks *query.TickSchedule, imports []string, converters map[string]index.ConverterAccess, indexes []*index.Reader, releaser indexReleaser) {
 	err := func() error {
 		q, err := query.Parse(t.definition)
 		if err != nil {
 			return err
 		}
-		streams, _, _, err := index.SearchStreams(context.Background(), indexes, &t.Uncertain, q.ReferenceTime, q.Conditions, nil, []query.Sorting{{Key: query.SortingKeyID, Dir: query.SortingDirAscending}}, 0, 0, tagDetails, converters, false)
+		// evaluate relative times against the time all other matches were evaluated against
+		q.Conditions.UpdateReferenceTime(q.ReferenceTime, t.ReferenceTime)
+		streams, _, _, err := index.SearchStreams(context.Background(), indexes, q.Conditions, index.SearchOptions{
+			LimitIDs:      &t.Uncertain,
+			ReferenceTime: t.ReferenceTime,
+			Sorting:       []query.Sorting{{Key: query.SortingKeyID, Dir: query.SortingDirAscending}},
+			TagDetails:    tagDetails,
+			HostSets:      hostSets,
+			Ticks:         ticks,
+			Imports:       imports,
+			Converters:    converters,
+		})
 		if err != nil {
 			return err
 		}
@@ -807,6 +1195,13 @@ func (mgr *Manager) updateTagJob(name string, t tag, tagDetails map[string]query
 			t.color = ot.color
 			t.converters = ot.converters
 			t.referencedBy = ot.referencedBy
+			if !ot.ReferenceTime.Equal(t.ReferenceTime) {
+				// the reference time was updated while the job was running,
+				// keep the streams uncertain that might have changed since
+				t.Uncertain = ot.Uncertain
+				t.Conditions = ot.Conditions
+				t.ReferenceTime = ot.ReferenceTime
+			}
 			for _, converter := range t.converters {
 				mgr.streamsToConvert[converter.Name()].Or(t.Matches)
 			}
@@ -854,9 +1249,51 @@ func (mgr *Manager) getIndexesCopy(start int) ([]*index.Reader, indexReleaser) {
 }
 
 func (mgr *Manager) SetConfig(config Config) error {
+	if err := config.Compaction.validate(); err != nil {
+		return err
+	}
+	if config.RelativeTagInterval < 0 {
+		return errors.New("relative tag interval must not be negative")
+	}
+	if config.CursorTTL < 0 {
+		return errors.New("cursor ttl must not be negative")
+	}
+	if config.Ticks != nil {
+		if err := config.Ticks.Validate(); err != nil {
+			return err
+		}
+	}
 	c := make(chan error)
 	mgr.jobs <- func() {
+		ticksChanged := !reflect.DeepEqual(mgr.config.Ticks, config.Ticks)
+		if ticksChanged && config.Ticks == nil {
+			for tn, t := range mgr.tags {
+				if t.features.Ticks {
+					c <- fmt.Errorf("tag %q still uses tick filters", tn)
+					close(c)
+					return
+				}
+			}
+		}
+		intervalChanged := mgr.config.RelativeTagInterval != config.RelativeTagInterval
 		mgr.config = config
+		mgr.startMergeJobIfNeeded()
+		if intervalChanged && !mgr.relativeTagJobRunning {
+			mgr.scheduleRelativeTagJob()
+		}
+		if ticksChanged {
+			// reevaluate all tags using ticks
+			for tn, t := range mgr.tags {
+				if !t.features.Ticks {
+					continue
+				}
+				nt := *t
+				nt.Uncertain = mgr.allStreams
+				mgr.tags[tn] = &nt
+			}
+			mgr.inheritTagUncertainty()
+			mgr.startTaggingJobIfNeeded()
+		}
 
 		mgr.event(Event{
 			Type:   "configUpdated",
@@ -871,7 +1308,14 @@ func (mgr *Manager) SetConfig(config Config) error {
 func (mgr *Manager) Config() Config {
 	c := make(chan Config)
 	mgr.jobs <- func() {
-		c <- mgr.config
+		config := mgr.config
+		if config.Ticks != nil {
+			// don't let the caller modify the current tick schedule
+			ticks := *config.Ticks
+			ticks.Pauses = slices.Clone(ticks.Pauses)
+			config.Ticks = &ticks
+		}
+		c <- config
 		close(c)
 	}
 	return <-c
@@ -894,6 +1338,7 @@ func (mgr *Manager) Status() Statistics {
 			StreamCount:         int(mgr.nextStreamID),
 			PacketCount:         int(mgr.builder.PacketCount()),
 			MergeJobRunning:     mgr.mergeJobRunning,
+			MergeProgress:       mgr.mergeProgress,
 			TaggingJobRunning:   mgr.taggingJobRunning,
 			ConverterJobRunning: mgr.converterJobRunning,
 		}
@@ -903,6 +1348,24 @@ func (mgr *Manager) Status() Statistics {
 	return res
 }
 
+// importedPcaps returns the names of all known pcaps in the order of their
+// import. The result is shared and must not be modified.
+func (mgr *Manager) importedPcaps() []string {
+	if mgr.importedPcapNames != nil {
+		return mgr.importedPcapNames
+	}
+	pcaps := slices.Clone(mgr.builder.KnownPcaps())
+	slices.SortStableFunc(pcaps, func(a, b *pcapmetadata.PcapInfo) int {
+		return a.ParseTime.Compare(b.ParseTime)
+	})
+	res := make([]string, 0, len(pcaps))
+	for _, p := range pcaps {
+		res = append(res, p.Filename)
+	}
+	mgr.importedPcapNames = res
+	return res
+}
+
 func (mgr *Manager) KnownPcaps() []pcapmetadata.PcapInfo {
 	c := make(chan []pcapmetadata.PcapInfo)
 	mgr.jobs <- func() {
@@ -977,15 +1440,13 @@ func (mgr *Manager) AddTag(name, color, queryString string) error {
 		return err
 	}
 	features := q.Conditions.Features()
-	if (features.MainFeatures|features.SubQueryFeatures)&query.FeatureFilterTimeRelative != 0 {
-		return errors.New("relative times not yet supported in tags")
-	}
 	if q.Grouping != nil {
 		return errors.New("grouping not allowed in tags")
 	}
 	nt := &tag{
 		TagDetails: query.TagDetails{
-			Conditions: q.Conditions,
+			Conditions:    q.Conditions,
+			ReferenceTime: q.ReferenceTime,
 		},
 		definition:   queryString,
 		features:     features,
@@ -1014,6 +1475,12 @@ func (mgr *Manager) AddTag(name, color, queryString string) error {
 					return fmt.Errorf("unknown referenced tag %q", t)
 				}
 			}
+			if err := mgr.checkHostSets(nt); err != nil {
+				return err
+			}
+			if err := mgr.checkTicks(nt); err != nil {
+				return err
+			}
 			mgr.tags[name] = nt
 			if isMark {
 				nt.Matches, _ = q.Conditions.StreamIDs(mgr.nextStreamID)
@@ -1161,15 +1628,13 @@ func (mgr *Manager) UpdateTag(name string, operation UpdateTagOperation) error {
 			return err
 		}
 		features := q.Conditions.Features()
-		if (features.MainFeatures|features.SubQueryFeatures)&query.FeatureFilterTimeRelative != 0 {
-			return errors.New("relative times not yet supported in tags")
-		}
 		if q.Grouping != nil {
 			return errors.New("grouping not allowed in tags")
 		}
 		newTag = &tag{
 			TagDetails: query.TagDetails{
-				Conditions: q.Conditions,
+				Conditions:    q.Conditions,
+				ReferenceTime: q.ReferenceTime,
 			},
 			definition: *info.query,
 			features:   features,
@@ -1192,6 +1657,14 @@ func (mgr *Manager) UpdateTag(name string, operation UpdateTagOperation) error {
 			if !ok {
 				return fmt.Errorf("unknown tag %q", name)
 			}
+			if newTag != nil {
+				if err := mgr.checkHostSets(newTag); err != nil {
+					return err
+				}
+				if err := mgr.checkTicks(newTag); err != nil {
+					return err
+				}
+			}
 			if info.color != "" {
 				tag.color = info.color
 			}
@@ -1395,6 +1868,7 @@ func (r *indexReleaser) release(mgr *Manager) {
 		mgr.usedIndexes[i]--
 		if mgr.usedIndexes[i] == 0 {
 			delete(mgr.usedIndexes, i)
+			delete(mgr.unmergeableIndexes, i)
 			i.Close()
 			os.Remove(i.Filename())
 		}
@@ -1794,7 +2268,7 @@ func (mgr *Manager) addConverter(path string) error {
 	if _, ok := mgr.converters[name]; ok {
 		return fmt.Errorf("error: converter %s already exists", name)
 	}
-	if name == "none" {
+	if name == "none" || query.IsDataModifier(name) {
 		return fmt.Errorf("error: converter %s is reserved", name)
 	}
 	// Converter names have to be plain ascii so we can use them in the query language easily.
@@ -2027,6 +2501,107 @@ func (mgr *Manager) DelPcapProcessorWebhook(url string) error {
 	return <-c
 }
 
+func (mgr *Manager) hostSetHosts() map[string][]string {
+	res := make(map[string][]string, len(mgr.hostSets))
+	for n, hs := range mgr.hostSets {
+		res[n] = hs.Hosts
+	}
+	return res
+}
+
+// checkHostSets returns an error if the tag uses an unknown host set.
+func (mgr *Manager) checkHostSets(t *tag) error {
+	for _, n := range t.features.HostSets {
+		if _, ok := mgr.hostSets[n]; !ok {
+			return fmt.Errorf("unknown host set %q", n)
+		}
+	}
+	return nil
+}
+
+// checkTicks returns an error if the tag uses tick filters without a
+// configured tick schedule.
+func (mgr *Manager) checkTicks(t *tag) error {
+	if t.features.Ticks && mgr.config.Ticks == nil {
+		return errors.New("tick filters require a tick schedule")
+	}
+	return nil
+}
+
+func (mgr *Manager) ListHostSets() map[string][]string {
+	c := make(chan map[string][]string)
+	mgr.jobs <- func() {
+		c <- mgr.hostSetHosts()
+		close(c)
+	}
+	return <-c
+}
+
+// updateHostSets replaces the host sets and reevaluates all tags using the
+// updated host set.
+func (mgr *Manager) updateHostSets(name string, hostSets query.HostSets) error {
+	mgr.hostSets = hostSets
+	for tn, t := range mgr.tags {
+		if !slices.Contains(t.features.HostSets, name) {
+			continue
+		}
+		nt := *t
+		nt.Uncertain = mgr.allStreams
+		mgr.tags[tn] = &nt
+	}
+	mgr.inheritTagUncertainty()
+	mgr.startTaggingJobIfNeeded()
+	hosts := mgr.hostSetHosts()
+	mgr.event(Event{
+		Type:     "hostSetsUpdated",
+		HostSets: &hosts,
+	})
+	return mgr.saveState()
+}
+
+func (mgr *Manager) SetHostSet(name string, hosts []string) error {
+	if !query.ValidHostSetName(name) {
+		return fmt.Errorf("invalid host set name %q", name)
+	}
+	hs, err := query.NewHostSet(hosts)
+	if err != nil {
+		return err
+	}
+	c := make(chan error)
+	mgr.jobs <- func() {
+		hostSets := maps.Clone(mgr.hostSets)
+		if hostSets == nil {
+			hostSets = query.HostSets{}
+		}
+		hostSets[name] = hs
+		c <- mgr.updateHostSets(name, hostSets)
+		close(c)
+	}
+	return <-c
+}
+
+func (mgr *Manager) DelHostSet(name string) error {
+	c := make(chan error)
+	mgr.jobs <- func() {
+		err := func() error {
+			if _, ok := mgr.hostSets[name]; !ok {
+				return fmt.Errorf("error: host set %q does not exist", name)
+			}
+			for tn, t := range mgr.tags {
+				if slices.Contains(t.features.HostSets, name) {
+					return fmt.Errorf("tag %q still references the host set to be deleted", tn)
+				}
+			}
+			hostSets := maps.Clone(mgr.hostSets)
+			delete(hostSets, name)
+			return mgr.updateHostSets(name, hostSets)
+		}()
+		c <- err
+		close(c)
+	}
+	return <-c
+}
+
 func (mgr *Manager) triggerPcapProcessedWebhooks(filenames []string) {
 	var absFilenames []string
 	for _, filename := range filenames {
@@ -2322,6 +2897,9 @@ func (v *View) fetch() error {
 	c := make(chan error)
 	v.mgr.jobs <- func() {
 		v.indexes, v.releaser = v.mgr.getIndexesCopy(0)
+		v.hostSets = v.mgr.hostSets
+		v.ticks = v.mgr.config.Ticks
+		v.imports = v.mgr.importedPcaps()
 		for tn, ti := range v.mgr.tags {
 			v.tagDetails[tn] = ti.TagDetails
 			for _, c := range ti.converters {
@@ -2345,6 +2923,207 @@ func (v *View) Release() {
 	}
 }
 
+// NewCursor pins the current view, the reference time and a random sample
+// seed, searches using the returned cursor are not affected by later changes
+// until it expires.
+func (mgr *Manager) NewCursor(referenceTime time.Time) (string, error) {
+	b := [16]byte{}
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+	c := &cursor{
+		view:          mgr.GetView(),
+		referenceTime: referenceTime,
+		sampleSeed:    rand.Uint64(),
+	}
+	if err := c.view.fetch(); err != nil {
+		c.view.Release()
+		return "", err
+	}
+	mgr.jobs <- func() {
+		if len(mgr.cursors) >= maxCursors {
+			oldestID, oldest := "", (*cursor)(nil)
+			for oid, oc := range mgr.cursors {
+				if oldest == nil || oc.expires.Before(oldest.expires) {
+					oldestID, oldest = oid, oc
+				}
+			}
+			mgr.releaseCursor(oldestID, oldest)
+		}
+		mgr.cursors[id] = c
+		c.expires = time.Now().Add(mgr.config.cursorTTL())
+		c.timer = time.AfterFunc(mgr.config.cursorTTL(), func() {
+			mgr.submitJob(func() {
+				mgr.expireCursor(id, c)
+			})
+		})
+	}
+	return id, nil
+}
+
+// submitJob runs f on the mgr goroutine unless the manager was closed.
+func (mgr *Manager) submitJob(f func()) {
+	select {
+	case mgr.jobs <- f:
+	case <-mgr.done:
+	}
+}
+
+// ReleaseCursor releases the view of the cursor before it expires.
+func (mgr *Manager) ReleaseCursor(id string) {
+	mgr.jobs <- func() {
+		if c := mgr.cursors[id]; c != nil {
+			mgr.releaseCursor(id, c)
+		}
+	}
+}
+
+// expireCursor releases the view of the cursor if it was not used within
+// the ttl, otherwise the check is rescheduled.
+func (mgr *Manager) expireCursor(id string, c *cursor) {
+	if mgr.cursors[id] != c {
+		// the cursor was already released
+		return
+	}
+	if remaining := time.Until(c.expires); remaining > 0 {
+		if !mgr.closed {
+			c.timer.Reset(remaining)
+		}
+		return
+	}
+	mgr.releaseCursor(id, c)
+}
+
+// releaseCursor removes the cursor and releases its view once it is unused.
+func (mgr *Manager) releaseCursor(id string, c *cursor) {
+	c.timer.Stop()
+	delete(mgr.cursors, id)
+	go func() {
+		// wait for the current user of the view
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.released = true
+		c.view.Release()
+	}()
+}
+
+// WithCursor calls f with the view, the reference time and the sample seed
+// pinned by the cursor and extends its lifetime. ErrCursorExpired is returned
+// for unknown and expired cursors.
+func (mgr *Manager) WithCursor(id string, f func(v *View, referenceTime time.Time, sampleSeed uint64) error) error {
+	ch := make(chan *cursor)
+	mgr.jobs <- func() {
+		c := mgr.cursors[id]
+		if c != nil {
+			c.expires = time.Now().Add(mgr.config.cursorTTL())
+		}
+		ch <- c
+		close(ch)
+	}
+	c := <-ch
+	if c == nil {
+		return ErrCursorExpired
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released {
+		return ErrCursorExpired
+	}
+	return f(&c.view, c.referenceTime, c.sampleSeed)
+}
+
+// Subscribe evaluates the query on the streams added or updated by later
+// imports and calls f with the matching streams, the limit of the query is
+// ignored. The stream contexts are only valid until f returns. f is called
+// from a separate goroutine, one call at a time. The returned function
+// cancels the subscription. ErrTooManySubscriptions is returned if there
+// are already maxSubscriptions subscriptions.
+func (mgr *Manager) Subscribe(filter *query.Query, f func([]StreamContext, error)) (func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &subscription{
+		filter: filter,
+		f:      f,
+		notify: make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	ch := make(chan bool)
+	mgr.jobs <- func() {
+		added := len(mgr.subscriptions) < maxSubscriptions
+		if added {
+			mgr.subscriptions[s] = struct{}{}
+		}
+		ch <- added
+		close(ch)
+	}
+	if !<-ch {
+		cancel()
+		return nil, ErrTooManySubscriptions
+	}
+	go mgr.subscriptionWorker(s)
+	return func() {
+		cancel()
+		mgr.jobs <- func() {
+			delete(mgr.subscriptions, s)
+		}
+	}, nil
+}
+
+// notifySubscriptions adds the changed streams to the pending streams of all
+// subscriptions and wakes up their workers.
+func (mgr *Manager) notifySubscriptions(updatedStreams, resetStreams, addedStreams bitmask.LongBitmask) {
+	for s := range mgr.subscriptions {
+		s.pending.Or(updatedStreams)
+		s.pending.Or(resetStreams)
+		s.pending.Or(addedStreams)
+		if s.pending.IsZero() {
+			continue
+		}
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (mgr *Manager) subscriptionWorker(s *subscription) {
+	for {
+		select {
+		case <-s.notify:
+		case <-s.ctx.Done():
+			return
+		}
+		ch := make(chan bitmask.LongBitmask, 1)
+		select {
+		case mgr.jobs <- func() {
+			ch <- s.pending
+			s.pending = bitmask.LongBitmask{}
+		}:
+		case <-s.ctx.Done():
+			return
+		}
+		pending := <-ch
+		// relative times refer to the time of the evaluation, the
+		// conditions are shared with the earlier evaluations
+		filter := *s.filter
+		filter.ReferenceTime = time.Now()
+		filter.Conditions = s.filter.Conditions.Clone()
+		filter.Conditions.UpdateReferenceTime(s.filter.ReferenceTime, filter.ReferenceTime)
+		filter.Limit = nil
+		v := mgr.GetView()
+		results := []StreamContext(nil)
+		_, _, _, err := v.SearchStreams(s.ctx, &filter, func(c StreamContext) error {
+			results = append(results, c)
+			return nil
+		}, LimitIDs(pending), PrefetchAllTags())
+		if s.ctx.Err() == nil && (err != nil || len(results) != 0) {
+			s.f(results, err)
+		}
+		v.Release()
+	}
+}
+
 func PrefetchTags(tags []string) StreamsOption {
 	return func(o *streamsOptions) {
 		o.prefetchTags = append(o.prefetchTags, tags...)
@@ -2357,6 +3136,29 @@ func PrefetchAllTags() StreamsOption {
 	}
 }
 
+// LimitIDs restricts the search to the given streams.
+func LimitIDs(ids bitmask.LongBitmask) StreamsOption {
+	return func(o *streamsOptions) {
+		o.limitIDs = &ids
+	}
+}
+
+// SubQueryStreams collects the streams of each sub query the results were
+// matched with, see StreamContext.SubQueryStreams.
+func SubQueryStreams() StreamsOption {
+	return func(o *streamsOptions) {
+		o.subQueryStreams = true
+	}
+}
+
+// GroupAlternatives collects the best streams of each group that are not part
+// of the result, see StreamContext.GroupAlternatives.
+func GroupAlternatives() StreamsOption {
+	return func(o *streamsOptions) {
+		o.groupAlternatives = true
+	}
+}
+
 func Limit(defaultLimit, page uint) StreamsOption {
 	return func(o *streamsOptions) {
 		o.defaultLimit = defaultLimit
@@ -2422,7 +3224,16 @@ func (v *View) prefetchTags(ctx context.Context, tags []string, bm bitmask.LongB
 					continue outer
 				}
 			}
-			matches, _, _, err := index.SearchStreams(ctx, v.indexes, &uncertain, time.Time{}, ti.Conditions, nil, []query.Sorting{{Key: query.SortingKeyID, Dir: query.SortingDirAscending}}, 0, 0, v.tagDetails, v.converters, false)
+			matches, _, _, err := index.SearchStreams(ctx, v.indexes, ti.Conditions, index.SearchOptions{
+				LimitIDs:      &uncertain,
+				ReferenceTime: ti.ReferenceTime,
+				Sorting:       []query.Sorting{{Key: query.SortingKeyID, Dir: query.SortingDirAscending}},
+				TagDetails:    v.tagDetails,
+				HostSets:      v.hostSets,
+				Ticks:         v.ticks,
+				Imports:       v.imports,
+				Converters:    v.converters,
+			})
 			if err != nil {
 				return err
 			}
@@ -2496,7 +3307,33 @@ func (v *View) SearchStreams(ctx context.Context, filter *query.Query, f func(St
 		limit = *filter.Limit
 	}
 	offset := opts.page * limit
-	res, hasMore, dataRegexes, err := index.SearchStreams(ctx, v.indexes, nil, filter.ReferenceTime, filter.Conditions, filter.Grouping, filter.Sorting, limit, offset, v.tagDetails, v.converters, true)
+	searchOptions := index.SearchOptions{
+		LimitIDs:       opts.limitIDs,
+		ReferenceTime:  filter.ReferenceTime,
+		Grouping:       filter.Grouping,
+		Sorting:        filter.Sorting,
+		Sampling:       filter.Sampling,
+		Limit:          limit,
+		Skip:           offset,
+		TagDetails:     v.tagDetails,
+		HostSets:       v.hostSets,
+		Ticks:          v.ticks,
+		Imports:        v.imports,
+		Converters:     v.converters,
+		ExtractRegexes: true,
+	}
+	var (
+		res         []*index.Stream
+		details     *index.ResultDetails
+		hasMore     bool
+		dataRegexes *index.DataRegexes
+		err         error
+	)
+	if opts.subQueryStreams || opts.groupAlternatives {
+		res, details, hasMore, dataRegexes, err = index.SearchStreamsWithDetails(ctx, v.indexes, filter.Conditions, searchOptions, opts.groupAlternatives)
+	} else {
+		res, hasMore, dataRegexes, err = index.SearchStreams(ctx, v.indexes, filter.Conditions, searchOptions)
+	}
 	if err != nil {
 		return false, 0, nil, err
 	}
@@ -2508,14 +3345,29 @@ func (v *View) SearchStreams(ctx context.Context, filter *query.Query, f func(St
 		for _, s := range res {
 			searchedStreams.Set(uint(s.StreamID))
 		}
+		if details != nil {
+			for _, alternatives := range details.GroupAlternatives {
+				for _, a := range alternatives {
+					searchedStreams.Set(uint(a.StreamID))
+				}
+			}
+			for _, sqs := range details.SubQueryStreams {
+				for _, streams := range sqs {
+					for _, sq := range streams {
+						searchedStreams.Set(uint(sq.StreamID))
+					}
+				}
+			}
+		}
 		if err := v.prefetchTags(ctx, opts.prefetchTags, searchedStreams); err != nil {
 			return false, 0, nil, err
 		}
 	}
 	for _, s := range res {
 		if err := f(StreamContext{
-			s: s,
-			v: v,
+			s:       s,
+			v:       v,
+			details: details,
 		}); err != nil {
 			return false, 0, nil, err
 		}
@@ -2523,6 +3375,231 @@ func (v *View) SearchStreams(ctx context.Context, filter *query.Query, f func(St
 	return hasMore, offset, dataRegexes, nil
 }
 
+// SearchStreamsIncremental runs the search like SearchStreams, but calls f as
+// soon as the results are known, see index.SearchStreamsIncremental. progress
+// is called whenever an index was searched, it may be nil.
+func (v *View) SearchStreamsIncremental(ctx context.Context, filter *query.Query, f func(StreamContext) error, progress func(index.SearchProgress), options ...StreamsOption) (bool, uint, *index.DataRegexes, error) {
+	opts := streamsOptions{}
+	for _, o := range options {
+		o(&opts)
+	}
+	if err := v.fetch(); err != nil {
+		return false, 0, nil, err
+	}
+	if opts.prefetchAllTags {
+		for tn := range v.tagDetails {
+			opts.prefetchTags = append(opts.prefetchTags, tn)
+		}
+	}
+	limit := opts.defaultLimit
+	if filter.Limit != nil {
+		limit = *filter.Limit
+	}
+	offset := opts.page * limit
+	hasMore, dataRegexes, err := index.SearchStreamsIncremental(ctx, v.indexes, filter.Conditions, index.SearchOptions{
+		ReferenceTime:  filter.ReferenceTime,
+		Grouping:       filter.Grouping,
+		Sorting:        filter.Sorting,
+		Sampling:       filter.Sampling,
+		Limit:          limit,
+		Skip:           offset,
+		TagDetails:     v.tagDetails,
+		HostSets:       v.hostSets,
+		Ticks:          v.ticks,
+		Imports:        v.imports,
+		Converters:     v.converters,
+		ExtractRegexes: true,
+	}, func(streams []*index.Stream) error {
+		if len(opts.prefetchTags) != 0 {
+			searchedStreams := bitmask.LongBitmask{}
+			for _, s := range streams {
+				searchedStreams.Set(uint(s.StreamID))
+			}
+			if err := v.prefetchTags(ctx, opts.prefetchTags, searchedStreams); err != nil {
+				return err
+			}
+		}
+		for _, s := range streams {
+			if err := f(StreamContext{
+				s: s,
+				v: v,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, progress)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	return hasMore, offset, dataRegexes, nil
+}
+
+// ExplainSearch runs the search like SearchStreams, but returns a description
+// of how the search was executed instead of the results.
+func (v *View) ExplainSearch(ctx context.Context, filter *query.Query, options ...StreamsOption) (*index.SearchExplanation, error) {
+	opts := streamsOptions{}
+	for _, o := range options {
+		o(&opts)
+	}
+	if err := v.fetch(); err != nil {
+		return nil, err
+	}
+	limit := opts.defaultLimit
+	if filter.Limit != nil {
+		limit = *filter.Limit
+	}
+	offset := opts.page * limit
+	return index.ExplainSearchStreams(ctx, v.indexes, filter.Conditions, index.SearchOptions{
+		ReferenceTime: filter.ReferenceTime,
+		Grouping:      filter.Grouping,
+		Sorting:       filter.Sorting,
+		Sampling:      filter.Sampling,
+		Limit:         limit,
+		Skip:          offset,
+		TagDetails:    v.tagDetails,
+		HostSets:      v.hostSets,
+		Ticks:         v.ticks,
+		Imports:       v.imports,
+		Converters:    v.converters,
+	})
+}
+
+// SearchFacets counts the streams of the full result of the search, the limit
+// and the page of the query are ignored while the grouping is applied.
+func (v *View) SearchFacets(ctx context.Context, filter *query.Query) (*SearchFacets, error) {
+	if err := v.fetch(); err != nil {
+		return nil, err
+	}
+	facets := &SearchFacets{
+		Services:    map[string]uint{},
+		Tags:        map[string]uint{},
+		ServerPorts: map[uint16]uint{},
+		ClientHosts: map[string]uint{},
+	}
+	searchedStreams := bitmask.LongBitmask{}
+	err := index.VisitStreams(ctx, v.indexes, filter.Conditions, index.SearchOptions{
+		ReferenceTime: filter.ReferenceTime,
+		Grouping:      filter.Grouping,
+		Sorting:       filter.Sorting,
+		Sampling:      filter.Sampling,
+		TagDetails:    v.tagDetails,
+		HostSets:      v.hostSets,
+		Ticks:         v.ticks,
+		Imports:       v.imports,
+		Converters:    v.converters,
+	}, func(s *index.Stream) {
+		searchedStreams.Set(uint(s.StreamID))
+		facets.ServerPorts[s.ServerPort]++
+		facets.ClientHosts[s.ClientHostIP()]++
+	})
+	if err != nil {
+		return nil, err
+	}
+	if searchedStreams.IsZero() {
+		return facets, nil
+	}
+	allTags := make([]string, 0, len(v.tagDetails))
+	for tn := range v.tagDetails {
+		allTags = append(allTags, tn)
+	}
+	if err := v.prefetchTags(ctx, allTags, searchedStreams); err != nil {
+		return nil, err
+	}
+	for tn, td := range v.tagDetails {
+		// the tags are certain for all searched streams after prefetching them
+		n := uint(td.Matches.AndCopy(searchedStreams).OnesCount())
+		if n == 0 {
+			continue
+		}
+		if strings.HasPrefix(tn, "service/") {
+			facets.Services[tn] = n
+		} else {
+			facets.Tags[tn] = n
+		}
+	}
+	return facets, nil
+}
+
+// AggregateStreams counts the streams of the full result of the search and
+// sums up their sizes, the limit and the page of the query are ignored while
+// the grouping is applied. If bucketSize is not zero, the streams are also
+// aggregated per time bucket of their first packet.
+func (v *View) AggregateStreams(ctx context.Context, filter *query.Query, bucketSize time.Duration) (*index.SearchAggregation, error) {
+	if err := v.fetch(); err != nil {
+		return nil, err
+	}
+	return index.AggregateStreams(ctx, v.indexes, filter.Conditions, index.SearchOptions{
+		ReferenceTime: filter.ReferenceTime,
+		Grouping:      filter.Grouping,
+		Sorting:       filter.Sorting,
+		Sampling:      filter.Sampling,
+		TagDetails:    v.tagDetails,
+		HostSets:      v.hostSets,
+		Ticks:         v.ticks,
+		Imports:       v.imports,
+		Converters:    v.converters,
+	}, bucketSize)
+}
+
+// CompletionValues returns the values suggested for query autocompletion.
+func (v *View) CompletionValues(t query.CompletionValueType) ([]string, error) {
+	if err := v.fetch(); err != nil {
+		return nil, err
+	}
+	res := []string(nil)
+	switch t {
+	case query.CompletionValueTags:
+		for tn := range v.tagDetails {
+			res = append(res, tn)
+		}
+		slices.Sort(res)
+	case query.CompletionValueConverters:
+		for cn := range v.converters {
+			res = append(res, cn)
+		}
+		slices.Sort(res)
+	case query.CompletionValueHostSets:
+		for hsn := range v.hostSets {
+			res = append(res, hsn)
+		}
+		slices.Sort(res)
+	case query.CompletionValueHosts:
+		hosts := map[string]struct{}{}
+		for _, idx := range v.indexes {
+			for _, h := range idx.Hosts() {
+				hosts[h.String()] = struct{}{}
+			}
+		}
+		for h := range hosts {
+			res = append(res, h)
+		}
+		slices.Sort(res)
+	case query.CompletionValuePorts:
+		ports := []uint16(nil)
+		for _, idx := range v.indexes {
+			p, err := idx.ServerPorts()
+			if err != nil {
+				return nil, err
+			}
+			ports = append(ports, p...)
+		}
+		slices.Sort(ports)
+		for _, p := range slices.Compact(ports) {
+			res = append(res, strconv.Itoa(int(p)))
+		}
+	}
+	return res, nil
+}
+
+// TickSchedule returns the configured tick schedule or nil.
+func (v *View) TickSchedule() (*query.TickSchedule, error) {
+	if err := v.fetch(); err != nil {
+		return nil, err
+	}
+	return v.ticks, nil
+}
+
 func (v *View) ReferenceTime() (time.Time, error) {
 	if err := v.fetch(); err != nil {
 		return time.Time{}, err
@@ -2561,6 +3638,48 @@ func (c StreamContext) Stream() *index.Stream {
 	return c.s
 }
 
+// SubQueryStreams returns the streams of each sub query the stream was
+// matched with, they are only set if the search was run with the
+// SubQueryStreams or GroupAlternatives option.
+func (c StreamContext) SubQueryStreams() map[string][]StreamContext {
+	if c.details == nil {
+		return nil
+	}
+	sqs := c.details.SubQueryStreams[c.s.StreamID]
+	if len(sqs) == 0 {
+		return nil
+	}
+	res := make(map[string][]StreamContext, len(sqs))
+	for name, streams := range sqs {
+		res[name] = c.v.streamContexts(streams)
+	}
+	return res
+}
+
+// GroupAlternatives returns the best streams of the group of the stream that
+// are not part of the search result, they are only set if the search was run
+// with the GroupAlternatives option.
+func (c StreamContext) GroupAlternatives() []StreamContext {
+	if c.details == nil {
+		return nil
+	}
+	return c.v.streamContexts(c.details.GroupAlternatives[c.s.StreamID])
+}
+
+func (v *View) streamContexts(streams []*index.Stream) []StreamContext {
+	if len(streams) == 0 {
+		return nil
+	}
+	res := make([]StreamContext, 0, len(streams))
+	for _, s := range streams {
+		res = append(res, StreamContext{
+			s: s,
+			v: v,
+		})
+	}
+	return res
+}
+
 func (c StreamContext) Data(converterName string) ([]index.Data, error) {
 	if c.Stream() == nil {
 		return nil, fmt.Errorf("stream not found")
@@ -2588,6 +3707,81 @@ func (c StreamContext) Data(converterName string) ([]index.Data, error) {
 	return data, err
 }
 
+// DataMatcher returns a matcher for the data conditions of the query and
+// of the tags it references.
+func (v *View) DataMatcher(filter *query.Query) (*DataMatcher, error) {
+	if err := v.fetch(); err != nil {
+		return nil, err
+	}
+	regexes, err := index.ExtractDataConditionRegexes(filter.Conditions, v.tagDetails)
+	if err != nil {
+		return nil, err
+	}
+	return &DataMatcher{
+		regexes: regexes,
+	}, nil
+}
+
+// DataMatches returns the matches of the data conditions in the captured
+// data of the stream and the output of the given converters, the captured
+// data is searched if converters contains the empty string. The output of
+// a converter is only created if a data condition applies to it.
+func (c StreamContext) DataMatches(m *DataMatcher, converters []string) ([]DataMatch, error) {
+	matches := []DataMatch{}
+	for _, cn := range converters {
+		regexes := []*index.DataConditionRegex(nil)
+		for i := range m.regexes {
+			r := &m.regexes[i]
+			if r.Converter == "" || r.Converter == cn || (r.Converter == "none" && cn == "") {
+				regexes = append(regexes, r)
+			}
+		}
+		if len(regexes) == 0 {
+			continue
+		}
+		data, err := c.Data(cn)
+		if err != nil {
+			return nil, err
+		}
+		buffers := [2][]byte{}
+		for _, d := range data {
+			buffers[d.Direction] = append(buffers[d.Direction], d.Content...)
+		}
+		sourceMatches := []DataMatch(nil)
+		for _, r := range regexes {
+			buf := buffers[r.Direction]
+			for _, o := range r.FindAll(buf, maxDataMatches) {
+				start, end := max(o[0]-dataMatchContext, 0), min(o[1]+dataMatchContext, len(buf))
+				sourceMatches = append(sourceMatches, DataMatch{
+					Converter:    cn,
+					Direction:    r.Direction,
+					Start:        o[0],
+					End:          o[1],
+					Snippet:      buf[start:end],
+					SnippetStart: start,
+				})
+			}
+		}
+		slices.SortFunc(sourceMatches, func(a, b DataMatch) int {
+			if a.Direction != b.Direction {
+				return int(a.Direction) - int(b.Direction)
+			}
+			if a.Start != b.Start {
+				return a.Start - b.Start
+			}
+			return a.End - b.End
+		})
+		sourceMatches = slices.CompactFunc(sourceMatches, func(a, b DataMatch) bool {
+			return a.Direction == b.Direction && a.Start == b.Start && a.End == b.End
+		})
+		matches = append(matches, sourceMatches...)
+		if len(matches) >= maxDataMatches {
+			return matches[:maxDataMatches], nil
+		}
+	}
+	return matches, nil
+}
+
 func (c StreamContext) HasTag(name string) (bool, error) {
 	if c.v == nil {
 		return false, fmt.Errorf("no view")
@@ -2618,6 +3812,17 @@ func (c StreamContext) AllTags() ([]string, error) {
 	return tags, nil
 }
 
+// HostSets returns the names of the host sets containing the client and the
+// server host of the stream.
+func (c StreamContext) HostSets() ([]string, []string, error) {
+	if c.v == nil {
+		return nil, nil, fmt.Errorf("no view")
+	}
+	client := c.v.hostSets.Names(net.ParseIP(c.s.ClientHostIP()))
+	server := c.v.hostSets.Names(net.ParseIP(c.s.ServerHostIP()))
+	return client, server, nil
+}
+
 func (c StreamContext) AllConverters() ([]string, error) {
 	if c.v == nil {
 		return nil, fmt.Errorf("no view")
@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -21,6 +22,7 @@ import (
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
 	"github.com/gopacket/gopacket/pcapgo"
+	"github.com/spq/pkappa2/internal/index"
 	"github.com/spq/pkappa2/internal/index/converters"
 	"github.com/spq/pkappa2/internal/query"
 )
@@ -87,6 +89,23 @@ func TestEmptyManager(t *testing.T) {
 	mgr.Close()
 }
 
+func TestManagerOutdatedIndex(t *testing.T) {
+	dirs := makeTempdirs(t)
+	header := make([]byte, 512)
+	copy(header, "pkappa2index\x00\x00\x00\x02")
+	if err := os.WriteFile(path.Join(dirs.index, "old.idx"), header, 0644); err != nil {
+		t.Fatalf("WriteFile failed with error: %v", err)
+	}
+	mgr, err := New(dirs.pcap, dirs.index, dirs.snapshot, dirs.state, dirs.converter, dirs.watch)
+	if err == nil {
+		mgr.Close()
+		t.Fatal("manager.New with an outdated index succeeded, want error")
+	}
+	if !errors.Is(err, index.ErrUnsupportedFormat) {
+		t.Errorf("manager.New failed with error %v, want %v", err, index.ErrUnsupportedFormat)
+	}
+}
+
 func makeUDPPacket(client, server string, t time.Time, payload string) pcapOverIPPacket {
 	clientAddrPort := netip.MustParseAddrPort(client)
 	serverAddrPort := netip.MustParseAddrPort(server)
@@ -469,6 +488,156 @@ func TestManagerMerging(t *testing.T) {
 	waitForEvent(t, events, eventsCloser, "indexesMerged")
 }
 
+func TestManagerCompactionPolicy(t *testing.T) {
+	dirs := makeTempdirs(t)
+	mgr := makeManager(t, dirs)
+	defer mgr.Close()
+	if err := mgr.SetConfig(Config{Compaction: CompactionPolicy{TierFactor: 1}}); err == nil {
+		t.Fatal("Manager.SetConfig with tier factor 1 succeeded, want error")
+	}
+	if err := mgr.SetConfig(Config{Compaction: CompactionPolicy{MinMergeCount: 2, CPULimit: 0.5}}); err != nil {
+		t.Fatalf("Manager.SetConfig failed with error: %v", err)
+	}
+	// the listener is closed by Manager.Close
+	events, _ := mgr.Listen()
+	for i := 0; i < 2; i++ {
+		pcaps, err := writePcaps(mgr.PcapDir, []pcapOverIPPacket{
+			makeUDPPacket(fmt.Sprintf("9.0.0.%d:123", i), "2.3.4.5:9001", t1.Add(time.Second*time.Duration(i)), "foo"),
+		})
+		if err != nil {
+			t.Fatalf("writePcaps failed with error: %v", err)
+		}
+		mgr.ImportPcaps(pcaps)
+		waitForEvent(t, events, func() {}, "pcapProcessed")
+	}
+	progress := (*index.MergeProgress)(nil)
+	for e := range events {
+		if e.Type == "mergeProgress" {
+			progress = e.MergeProgress
+		}
+		if e.Type == "indexesMerged" {
+			break
+		}
+	}
+	if progress == nil || progress.InputIndexes != 2 || progress.MergedIndexes != 2 {
+		t.Fatalf("mergeProgress event = %+v, want 2 merged indexes", progress)
+	}
+	if got := mgr.Status(); got.IndexCount != 1 || got.MergeJobRunning || got.MergeProgress != nil {
+		t.Fatalf("Manager.Status() = %+v, want 1 index and no merge job", got)
+	}
+}
+
+func TestRelativeTags(t *testing.T) {
+	dirs := makeTempdirs(t)
+	mgr := makeManager(t, dirs)
+	defer mgr.Close()
+	if err := mgr.SetConfig(Config{RelativeTagInterval: -time.Second}); err == nil {
+		t.Fatal("Manager.SetConfig with negative relative tag interval succeeded, want error")
+	}
+	if err := mgr.SetConfig(Config{RelativeTagInterval: 100 * time.Millisecond}); err != nil {
+		t.Fatalf("Manager.SetConfig failed with error: %v", err)
+	}
+	if err := mgr.AddTag("tag/old", "red", "ftime::-1s"); err != nil {
+		t.Fatalf("Manager.AddTag failed with error: %v", err)
+	}
+	if err := mgr.AddTag("tag/notold", "red", "-tag:old"); err != nil {
+		t.Fatalf("Manager.AddTag failed with error: %v", err)
+	}
+	// the streams start 1.5s ago, 0.5s ago, in 0.5s and in 1.5s
+	importSomePackets(t, mgr, time.Now().Add(-1500*time.Millisecond), "pcapProcessed")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		tags := mgr.ListTags()
+		if tags[0].Name == "tag/old" {
+			tags[0], tags[1] = tags[1], tags[0]
+		}
+		if tags[0].UncertainCount == 0 && tags[1].UncertainCount == 0 && tags[0].MatchingCount == 0 && tags[1].MatchingCount == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Manager.ListTags() = %+v, want all streams to be old", tags)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestHostSets(t *testing.T) {
+	dirs := makeTempdirs(t)
+	mgr := makeManager(t, dirs)
+	defer mgr.Close()
+	if err := mgr.SetHostSet("team 1", []string{"1.2.3.4"}); err == nil {
+		t.Fatal("Manager.SetHostSet with invalid name succeeded, want error")
+	}
+	if err := mgr.SetHostSet("team1", []string{"foo"}); err == nil {
+		t.Fatal("Manager.SetHostSet with invalid host succeeded, want error")
+	}
+	if err := mgr.AddTag("tag/team1", "red", "team:1"); err == nil {
+		t.Fatal("Manager.AddTag with unknown host set succeeded, want error")
+	}
+	if err := mgr.SetHostSet("team1", []string{"1.2.3.0/24"}); err != nil {
+		t.Fatalf("Manager.SetHostSet failed with error: %v", err)
+	}
+	if err := mgr.SetHostSet("gameserver", []string{"4.3.2.1", "::1"}); err != nil {
+		t.Fatalf("Manager.SetHostSet failed with error: %v", err)
+	}
+	if got, want := mgr.ListHostSets(), map[string][]string{"team1": {"1.2.3.0/24"}, "gameserver": {"4.3.2.1", "::1"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Manager.ListHostSets() = %v, want %v", got, want)
+	}
+	if err := mgr.AddTag("tag/team1", "red", "team:1"); err != nil {
+		t.Fatalf("Manager.AddTag failed with error: %v", err)
+	}
+	importSomePackets(t, mgr, t1, "pcapProcessed")
+	waitForTag := func(matching int) {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			tags := mgr.ListTags()
+			if tags[0].UncertainCount == 0 && tags[0].MatchingCount == uint(matching) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Manager.ListTags() = %+v, want %d matching streams", tags, matching)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForTag(4)
+	if err := mgr.SetHostSet("team1", []string{"1.2.4.0/24"}); err != nil {
+		t.Fatalf("Manager.SetHostSet failed with error: %v", err)
+	}
+	waitForTag(0)
+	if err := mgr.DelHostSet("team1"); err == nil {
+		t.Fatal("Manager.DelHostSet of used host set succeeded, want error")
+	}
+
+	view := mgr.GetView()
+	defer view.Release()
+	q, err := query.Parse("shost:@gameserver -chost:@team1")
+	if err != nil {
+		t.Fatalf("query.Parse failed: %v", err)
+	}
+	n := 0
+	if _, _, _, err := view.SearchStreams(context.Background(), q, func(sc StreamContext) error {
+		n++
+		client, server, err := sc.HostSets()
+		if err != nil || len(client) != 0 || !slices.Equal(server, []string{"gameserver"}) {
+			t.Errorf("StreamContext.HostSets() = %v, %v, %v, want [], [gameserver], nil", client, server, err)
+		}
+		return nil
+	}); err != nil || n != 4 {
+		t.Fatalf("View.SearchStreams() = %v with %d results, want 4 results", err, n)
+	}
+
+	if err := mgr.DelTag("tag/team1"); err != nil {
+		t.Fatalf("Manager.DelTag failed with error: %v", err)
+	}
+	if err := mgr.DelHostSet("team1"); err != nil {
+		t.Fatalf("Manager.DelHostSet failed with error: %v", err)
+	}
+	if err := mgr.DelHostSet("team1"); err == nil {
+		t.Fatal("Manager.DelHostSet of unknown host set succeeded, want error")
+	}
+}
+
 func TestManagerView(t *testing.T) {
 	dirs := makeTempdirs(t)
 	mgr := makeManager(t, dirs)
@@ -513,7 +682,25 @@ func TestManagerView(t *testing.T) {
 	if got, err := sc.AllConverters(); err != nil || len(got) != 0 {
 		t.Fatalf("StreamContext.AllConverters() = %v, %v, want [], nil", got, err)
 	}
-	q, err := query.Parse("")
+	q, err := query.Parse("cdata:o sdata:x")
+	if err != nil {
+		t.Fatalf("query.Parse failed: %v", err)
+	}
+	matcher, err := view.DataMatcher(q)
+	if err != nil {
+		t.Fatalf("View.DataMatcher failed with error: %v", err)
+	}
+	wantMatches := []DataMatch{
+		{Direction: index.DirectionClientToServer, Start: 1, End: 2, Snippet: []byte("foo")},
+		{Direction: index.DirectionClientToServer, Start: 2, End: 3, Snippet: []byte("foo")},
+	}
+	if got, err := sc.DataMatches(matcher, []string{""}); err != nil || !reflect.DeepEqual(got, wantMatches) {
+		t.Fatalf("StreamContext.DataMatches() = %+v, %v, want %+v, nil", got, err, wantMatches)
+	}
+	if got, err := sc.DataMatches(matcher, nil); err != nil || len(got) != 0 {
+		t.Fatalf("StreamContext.DataMatches(nil) = %+v, %v, want [], nil", got, err)
+	}
+	q, err = query.Parse("")
 	if err != nil {
 		t.Fatalf("query.Parse failed: %v", err)
 	}
@@ -525,6 +712,186 @@ func TestManagerView(t *testing.T) {
 	}, Limit(1, 1), PrefetchAllTags()); err != nil || n != 1 || !m {
 		t.Fatalf("View.SearchStreams() = %v, %v, %v, want true, 1, nil", m, n, err)
 	}
+	results, progress := 0, 0
+	if m, n, _, err := view.SearchStreamsIncremental(context.Background(), q, func(StreamContext) error {
+		results++
+		return nil
+	}, func(index.SearchProgress) {
+		progress++
+	}, Limit(2, 1), PrefetchAllTags()); err != nil || n != 2 || m || results != 2 || progress == 0 {
+		t.Fatalf("View.SearchStreamsIncremental() = %v, %v, %v with %d results and %d progress calls, want false, 2, nil with 2 results", m, n, err, results, progress)
+	}
+	q, err = query.Parse(`@other:cport:2 sport:@other:sport@ group:"@sport@" sort:id`)
+	if err != nil {
+		t.Fatalf("query.Parse failed: %v", err)
+	}
+	alternatives, subQueries := []uint64(nil), []uint64(nil)
+	if _, _, _, err := view.SearchStreams(context.Background(), q, func(sc StreamContext) error {
+		for _, a := range sc.GroupAlternatives() {
+			alternatives = append(alternatives, a.Stream().ID())
+		}
+		for _, s := range sc.SubQueryStreams()["other"] {
+			subQueries = append(subQueries, s.Stream().ID())
+		}
+		return nil
+	}, SubQueryStreams(), GroupAlternatives()); err != nil || !slices.Equal(alternatives, []uint64{1, 2, 3}) || !slices.Equal(subQueries, []uint64{1}) {
+		t.Fatalf("View.SearchStreams() = %v with group alternatives %v and sub query streams %v, want nil, [1 2 3], [1]", err, alternatives, subQueries)
+	}
+	q, err = query.Parse("cport:1:3 limit:1")
+	if err != nil {
+		t.Fatalf("query.Parse failed: %v", err)
+	}
+	want := &SearchFacets{
+		Services:    map[string]uint{},
+		Tags:        map[string]uint{"tag/foo": 3},
+		ServerPorts: map[uint16]uint{4321: 3},
+		ClientHosts: map[string]uint{"1.2.3.4": 3},
+	}
+	if got, err := view.SearchFacets(context.Background(), q); err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("View.SearchFacets() = %+v, %v, want %+v, nil", got, err, want)
+	}
+	if got, err := view.AggregateStreams(context.Background(), q, time.Second); err != nil || got.Streams != 3 || got.ClientBytes != 9 || len(got.Buckets) != 3 {
+		t.Fatalf("View.AggregateStreams() = %+v, %v, want 3 streams with 9 bytes in 3 buckets, nil", got, err)
+	}
+}
+
+func TestManagerCursor(t *testing.T) {
+	dirs := makeTempdirs(t)
+	mgr := makeManager(t, dirs)
+	defer mgr.Close()
+	importSomePackets(t, mgr, t1, "pcapProcessed")
+	q, err := query.Parse("")
+	if err != nil {
+		t.Fatalf("query.Parse failed: %v", err)
+	}
+	count := func(v *View) int {
+		n := 0
+		if _, _, _, err := v.SearchStreams(context.Background(), q, func(StreamContext) error {
+			n++
+			return nil
+		}); err != nil {
+			t.Fatalf("View.SearchStreams failed with error: %v", err)
+		}
+		return n
+	}
+	rt := time.Now()
+	id, err := mgr.NewCursor(rt)
+	if err != nil {
+		t.Fatalf("Manager.NewCursor failed with error: %v", err)
+	}
+	importSomePackets(t, mgr, t1.Add(time.Hour), "pcapProcessed")
+	seeds := []uint64{}
+	for range 2 {
+		if err := mgr.WithCursor(id, func(v *View, referenceTime time.Time, sampleSeed uint64) error {
+			if !referenceTime.Equal(rt) {
+				t.Errorf("referenceTime = %v, want %v", referenceTime, rt)
+			}
+			if got := count(v); got != 4 {
+				t.Errorf("cursor view has %d streams, want 4", got)
+			}
+			seeds = append(seeds, sampleSeed)
+			return nil
+		}); err != nil {
+			t.Fatalf("Manager.WithCursor failed with error: %v", err)
+		}
+	}
+	if seeds[0] != seeds[1] {
+		t.Errorf("cursor sample seeds %v differ", seeds)
+	}
+	mgr.ReleaseCursor(id)
+	if err := mgr.WithCursor(id, func(*View, time.Time, uint64) error {
+		return nil
+	}); err != ErrCursorExpired {
+		t.Fatalf("Manager.WithCursor on released cursor = %v, want %v", err, ErrCursorExpired)
+	}
+	view := mgr.GetView()
+	defer view.Release()
+	if got := count(&view); got != 8 {
+		t.Fatalf("current view has %d streams, want 8", got)
+	}
+	if err := mgr.WithCursor("unknown", func(*View, time.Time, uint64) error {
+		return nil
+	}); err != ErrCursorExpired {
+		t.Fatalf("Manager.WithCursor(\"unknown\") = %v, want %v", err, ErrCursorExpired)
+	}
+	if err := mgr.SetConfig(Config{CursorTTL: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("Manager.SetConfig failed with error: %v", err)
+	}
+	id, err = mgr.NewCursor(rt)
+	if err != nil {
+		t.Fatalf("Manager.NewCursor failed with error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := mgr.WithCursor(id, func(*View, time.Time, uint64) error {
+		return nil
+	}); err != ErrCursorExpired {
+		t.Fatalf("Manager.WithCursor on expired cursor = %v, want %v", err, ErrCursorExpired)
+	}
+}
+
+func TestManagerSubscribe(t *testing.T) {
+	dirs := makeTempdirs(t)
+	mgr := makeManager(t, dirs)
+	defer mgr.Close()
+	importSomePackets(t, mgr, t1, "pcapProcessed")
+	q, err := query.Parse("cport:2:3")
+	if err != nil {
+		t.Fatalf("query.Parse failed: %v", err)
+	}
+	type update struct {
+		ports []uint16
+		err   error
+	}
+	updates := make(chan update, 10)
+	unsubscribe, err := mgr.Subscribe(q, func(streams []StreamContext, err error) {
+		u := update{err: err}
+		for _, s := range streams {
+			u.ports = append(u.ports, s.Stream().ClientPort)
+		}
+		slices.Sort(u.ports)
+		updates <- u
+	})
+	if err != nil {
+		t.Fatalf("Manager.Subscribe failed with error: %v", err)
+	}
+	// only the streams of the new import are evaluated
+	importSomePackets(t, mgr, t1.Add(time.Hour), "pcapProcessed")
+	select {
+	case u := <-updates:
+		if u.err != nil || !slices.Equal(u.ports, []uint16{2, 3}) {
+			t.Fatalf("subscription update = %v, %v, want [2 3], nil", u.ports, u.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no subscription update received")
+	}
+	unsubscribe()
+	importSomePackets(t, mgr, t1.Add(2*time.Hour), "pcapProcessed")
+	select {
+	case u := <-updates:
+		t.Fatalf("unexpected subscription update after unsubscribing: %v, %v", u.ports, u.err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// the number of subscriptions is limited
+	unsubscribes := []func(){}
+	for i := 0; i < maxSubscriptions; i++ {
+		unsubscribe, err := mgr.Subscribe(q, func([]StreamContext, error) {})
+		if err != nil {
+			t.Fatalf("Manager.Subscribe failed with error: %v", err)
+		}
+		unsubscribes = append(unsubscribes, unsubscribe)
+	}
+	if _, err := mgr.Subscribe(q, func([]StreamContext, error) {}); !errors.Is(err, ErrTooManySubscriptions) {
+		t.Errorf("Manager.Subscribe over the limit failed with error %v, want %v", err, ErrTooManySubscriptions)
+	}
+	unsubscribes[0]()
+	unsubscribe, err = mgr.Subscribe(q, func([]StreamContext, error) {})
+	if err != nil {
+		t.Fatalf("Manager.Subscribe after unsubscribing failed with error: %v", err)
+	}
+	unsubscribe()
+	for _, unsubscribe := range unsubscribes[1:] {
+		unsubscribe()
+	}
 }
 
 func waitForEvent(t *testing.T, listener <-chan Event, listenerCloser func(), eventType string) {
@@ -542,6 +909,8 @@ func waitForEvent(t *testing.T, listener <-chan Event, listenerCloser func(), ev
 func TestConverters(t *testing.T) {
 	dirs := makeTempdirs(t)
 	addConverter(dirs, "foo")
+	// the name is a data filter modifier
+	addConverter(dirs, "hex")
 	mgr := makeManager(t, dirs)
 	defer mgr.Close()
 	if got := mgr.ListConverters(); len(got) != 1 || got[0].Name != "foo" {
@@ -3,23 +3,70 @@ package index
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/spq/pkappa2/internal/tools"
 )
 
+type (
+	MergeOptions struct {
+		// MaxIndexSize limits the estimated size of the created indexes,
+		// 0 means the size is only limited by the index format.
+		MaxIndexSize int64
+		// MaxDuration stops the merge after the given time has passed,
+		// the remaining (older) input indexes will not be merged. At
+		// least two indexes are always merged to guarantee progress.
+		MaxDuration time.Duration
+		// CPULimit is the fraction of time the merge may spend working,
+		// the rest is spent sleeping. 0 means unlimited.
+		CPULimit float64
+		// Progress is called after each merged input index.
+		Progress func(MergeProgress)
+	}
+	MergeProgress struct {
+		InputIndexes     int
+		MergedIndexes    int
+		InputStreams     int
+		MergedStreams    int
+		OutputIndexes    int
+		StartTime        time.Time
+		EstimatedEndTime time.Time
+	}
+)
+
 func Merge(indexDir string, indexes []*Reader) ([]*Reader, error) {
+	rs, _, err := MergeWithOptions(indexDir, indexes, MergeOptions{})
+	return rs, err
+}
+
+// MergeWithOptions merges the indexes into new indexes. It returns the created
+// indexes and the number of input indexes that were merged into them, the
+// merged indexes are always the newest ones (the end of the slice).
+func MergeWithOptions(indexDir string, indexes []*Reader, opts MergeOptions) ([]*Reader, int, error) {
 	ws := []*Writer{}
 	rs := []*Reader{}
+	progress := MergeProgress{
+		InputIndexes: len(indexes),
+		StartTime:    time.Now(),
+	}
+	for _, idx := range indexes {
+		progress.InputStreams += idx.StreamCount()
+	}
 	err := func() error {
 		for idxIdx := len(indexes); idxIdx > 0; {
+			if opts.MaxDuration != 0 && progress.MergedIndexes >= 2 && time.Since(progress.StartTime) >= opts.MaxDuration {
+				break
+			}
 			idxIdx--
 			idx := indexes[idxIdx]
+			start := time.Now()
 			for wIdx := 0; wIdx <= len(ws); wIdx++ {
 				if wIdx == len(ws) {
 					w, err := NewWriter(tools.MakeFilename(indexDir, "idx"))
 					if err != nil {
 						return err
 					}
+					w.maxSize = opts.MaxIndexSize
 					ws = append(ws, w)
 				}
 				w := ws[wIdx]
@@ -32,6 +79,19 @@ func Merge(indexDir string, indexes []*Reader) ([]*Reader, error) {
 					break
 				}
 			}
+			if opts.CPULimit > 0 && opts.CPULimit < 1 {
+				time.Sleep(time.Duration(float64(time.Since(start)) * (1/opts.CPULimit - 1)))
+			}
+			progress.MergedIndexes++
+			progress.MergedStreams += idx.StreamCount()
+			progress.OutputIndexes = len(ws)
+			if progress.MergedStreams != 0 {
+				elapsed := time.Since(progress.StartTime)
+				progress.EstimatedEndTime = progress.StartTime.Add(time.Duration(float64(elapsed) * float64(progress.InputStreams) / float64(progress.MergedStreams)))
+			}
+			if opts.Progress != nil {
+				opts.Progress(progress)
+			}
 		}
 		for _, w := range ws {
 			r, err := w.Finalize()
@@ -50,16 +110,16 @@ func Merge(indexDir string, indexes []*Reader) ([]*Reader, error) {
 			w.Close()
 			os.Remove(w.filename)
 		}
-		return nil, err
+		return nil, 0, err
 	}
 	inputFiles := []string{}
 	outputFiles := []string{}
-	for _, i := range indexes {
+	for _, i := range indexes[len(indexes)-progress.MergedIndexes:] {
 		inputFiles = append(inputFiles, i.filename)
 	}
 	for _, i := range rs {
 		outputFiles = append(outputFiles, i.filename)
 	}
 	log.Printf("merged indexes %q into %q\n", inputFiles, outputFiles)
-	return rs, nil
+	return rs, progress.MergedIndexes, nil
 }
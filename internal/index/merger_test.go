@@ -116,9 +116,79 @@ func TestMerge(t *testing.T) {
 		if !reflect.DeepEqual(gotData[streamID], wantData) {
 			t.Errorf("Stream %d data mismatch:\nGot:  %v\nWant: %v", streamID, gotData[streamID], wantData)
 		}
-
+		wantSketches, err := indexes[i].sketchesByIndex(wantStream.Index())
+		if err != nil {
+			t.Errorf("sketchesByIndex failed with error: %v", err)
+		}
+		gotSketches, err := merged[0].sketchesByIndex(merged[0].StreamIDs()[streamID])
+		if err != nil {
+			t.Errorf("sketchesByIndex failed with error: %v", err)
+		}
+		if *gotSketches != *wantSketches {
+			t.Errorf("Stream %d sketches mismatch:\nGot:  %v\nWant: %v", streamID, *gotSketches, *wantSketches)
+		}
 	}
 	if err := merged[0].Close(); err != nil {
 		t.Errorf("Close failed with error: %v", err)
 	}
 }
+
+func TestMergeWithOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	indexes := []*Reader(nil)
+	for i := 0; i < 3; i++ {
+		index, err := makeIndex(tmpDir, map[uint64]streamInfo{
+			uint64(i): makeStream("1.2.3.4:1", "5.6.7.8:9", t1.Add(time.Hour*time.Duration(i)), []string{"foo", "bar"}),
+		}, nil)
+		if err != nil {
+			t.Fatalf("makeIndex failed with error: %v", err)
+		}
+		indexes = append(indexes, index)
+	}
+
+	progress := []MergeProgress(nil)
+	merged, nMerged, err := MergeWithOptions(tmpDir, indexes, MergeOptions{
+		MaxIndexSize: 1,
+		Progress: func(p MergeProgress) {
+			progress = append(progress, p)
+		},
+	})
+	if err != nil {
+		t.Fatalf("MergeWithOptions failed with error: %v", err)
+	}
+	if nMerged != 3 {
+		t.Errorf("MergeWithOptions merged %d indexes, want 3", nMerged)
+	}
+	if len(merged) != 3 {
+		t.Errorf("MergeWithOptions created %d indexes, want 3", len(merged))
+	}
+	if len(progress) != 3 {
+		t.Fatalf("MergeWithOptions reported progress %d times, want 3", len(progress))
+	}
+	if got := progress[2]; got.InputIndexes != 3 || got.MergedIndexes != 3 || got.InputStreams != 3 || got.MergedStreams != 3 || got.OutputIndexes != 3 {
+		t.Errorf("MergeWithOptions reported progress %+v, want 3 indexes and streams", got)
+	}
+	for _, r := range merged {
+		r.Close()
+	}
+
+	merged, nMerged, err = MergeWithOptions(tmpDir, indexes, MergeOptions{
+		MaxDuration: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("MergeWithOptions failed with error: %v", err)
+	}
+	if nMerged != 2 {
+		t.Errorf("MergeWithOptions merged %d indexes, want 2", nMerged)
+	}
+	if len(merged) != 1 {
+		t.Fatalf("MergeWithOptions created %d indexes, want 1", len(merged))
+	}
+	got := merged[0].StreamIDs()
+	_, ok1 := got[1]
+	_, ok2 := got[2]
+	if len(got) != 2 || !ok1 || !ok2 {
+		t.Errorf("MergeWithOptions created index with streams %v, want 1 and 2", got)
+	}
+	merged[0].Close()
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+// +build !unix
+
+package index
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory-mapped indexes are not supported on this platform")
+}
+
+func munmapFile(mapping []byte) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+// +build unix
+
+package index
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	return unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+}
+
+func munmapFile(mapping []byte) error {
+	if mapping == nil {
+		return nil
+	}
+	return unix.Munmap(mapping)
+}
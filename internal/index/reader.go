@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"math"
@@ -29,6 +31,7 @@ type (
 	Reader struct {
 		filename   string
 		file       *os.File
+		mapping    []byte
 		size       int64
 		header     fileHeader
 		imports    []readerImportEntry
@@ -61,6 +64,18 @@ type (
 		Content   []byte
 		Time      time.Time
 	}
+	chunkTime struct {
+		ts time.Time
+		sz uint64
+	}
+)
+
+var (
+	// ErrUnsupportedFormat is returned by NewReader for index files written
+	// with a different version of the index format.
+	ErrUnsupportedFormat = errors.New("unsupported index format")
+
+	mmapIndexes = flag.Bool("index_mmap", false, "memory-map index files instead of reading them with syscalls")
 )
 
 const (
@@ -82,12 +97,24 @@ func (r *Reader) Filename() string {
 	return r.filename
 }
 
+// Size returns the size of the index file in bytes.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
 func (r *Reader) calculateOffset(section section, objectSize, index int) int64 {
 	return int64(r.header.Sections[section].Begin) + int64(objectSize*index)
 }
 
+func (r *Reader) readerAt() io.ReaderAt {
+	if r.mapping != nil {
+		return bytes.NewReader(r.mapping)
+	}
+	return r.file
+}
+
 func (r *Reader) readAt(offset int64, d interface{}) error {
-	s := io.NewSectionReader(r.file, offset, r.size-offset)
+	s := io.NewSectionReader(r.readerAt(), offset, r.size-offset)
 	err := binary.Read(s, binary.LittleEndian, d)
 	if err != nil {
 		debug.PrintStack()
@@ -101,7 +128,37 @@ func init() {
 	isLittleEndian = binary.NativeEndian.Uint16([]byte("AB")) == binary.LittleEndian.Uint16([]byte("AB"))
 }
 
+// mapped returns the part of the memory-mapped index at the given offset
+func (r *Reader) mapped(offset int64, size uintptr) ([]byte, error) {
+	if offset < 0 || offset+int64(size) > int64(len(r.mapping)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return r.mapping[offset:][:size], nil
+}
+
+// mappedSlice returns n objects at the given offset of the memory-mapped
+// index without copying them. The slice points into the mapping, it must not
+// be modified and must not outlive the Reader.
+func mappedSlice[T any](r *Reader, offset int64, n int) ([]T, error) {
+	b, err := r.mapped(offset, uintptr(n)*unsafe.Sizeof(*new(T)))
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	return unsafe.Slice((*T)(unsafe.Pointer(&b[0])), n), nil
+}
+
+// streamByIndex returns the stream at the given index of the streams
+// section. For memory-mapped indexes the stream points into the mapping, so
+// it must not be modified and must not be kept after the Reader is closed,
+// use wrap to get a copy.
 func (r *Reader) streamByIndex(index uint32) (*stream, error) {
+	if r.mapping != nil && isLittleEndian {
+		b, err := r.mapped(r.calculateOffset(sectionStreams, int(unsafe.Sizeof(stream{})), int(index)), unsafe.Sizeof(stream{}))
+		if err != nil {
+			return nil, err
+		}
+		return (*stream)(unsafe.Pointer(&b[0])), nil
+	}
 	obj := stream{}
 	var err error
 	var d interface{}
@@ -114,7 +171,40 @@ func (r *Reader) streamByIndex(index uint32) (*stream, error) {
 	return &obj, err
 }
 
+// sketchesByIndex returns the similarity sketches of the stream at the given
+// index. Like for streamByIndex, they might point into the mapping and must
+// not outlive the Reader.
+func (r *Reader) sketchesByIndex(index uint32) (*streamSketches, error) {
+	if r.mapping != nil && isLittleEndian {
+		b, err := r.mapped(r.calculateOffset(sectionStreamSketches, int(unsafe.Sizeof(streamSketches{})), int(index)), unsafe.Sizeof(streamSketches{}))
+		if err != nil {
+			return nil, err
+		}
+		return (*streamSketches)(unsafe.Pointer(&b[0])), nil
+	}
+	obj := streamSketches{}
+	var err error
+	var d interface{}
+	if isLittleEndian {
+		d = (*[unsafe.Sizeof(obj)]byte)(unsafe.Pointer(&obj))
+	} else {
+		d = &obj
+	}
+	err = r.readAt(r.calculateOffset(sectionStreamSketches, int(unsafe.Sizeof(obj)), int(index)), d)
+	return &obj, err
+}
+
+// packetByIndex returns the packet at the given index of the packets section.
+// Like for streamByIndex, the packet might point into the mapping and must
+// not outlive the Reader.
 func (r *Reader) packetByIndex(index uint64) (*packet, error) {
+	if r.mapping != nil && isLittleEndian {
+		b, err := r.mapped(r.calculateOffset(sectionPackets, int(unsafe.Sizeof(packet{})), int(index)), unsafe.Sizeof(packet{}))
+		if err != nil {
+			return nil, err
+		}
+		return (*packet)(unsafe.Pointer(&b[0])), nil
+	}
 	obj := packet{}
 	var err error
 	var d interface{}
@@ -128,13 +218,31 @@ func (r *Reader) packetByIndex(index uint64) (*packet, error) {
 }
 
 func (r *Reader) readLookup(lookup section, index int) (uint32, error) {
+	if r.mapping != nil {
+		b, err := r.mapped(r.calculateOffset(lookup, 4, index), 4)
+		if err != nil {
+			return 0, err
+		}
+		return binary.LittleEndian.Uint32(b), nil
+	}
 	streamIndex := uint32(0)
 	err := r.readAt(r.calculateOffset(lookup, 4, index), &streamIndex)
 	return streamIndex, err
 }
 
-func (r *Reader) readObjects(section section, d interface{}) error {
-	return r.readAt(r.calculateOffset(section, 0, 0), d)
+// readSection returns all objects of a section. For memory-mapped indexes
+// they are not copied, the slice then points into the mapping and must not
+// be modified.
+func readSection[T any](r *Reader, section section) ([]T, error) {
+	n := r.objectCount(section, int(unsafe.Sizeof(*new(T))))
+	if r.mapping != nil && isLittleEndian {
+		return mappedSlice[T](r, r.calculateOffset(section, 0, 0), n)
+	}
+	res := make([]T, n)
+	if err := r.readAt(r.calculateOffset(section, 0, 0), res); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 func (r *Reader) objectCount(section section, objectSize int) int {
@@ -142,7 +250,9 @@ func (r *Reader) objectCount(section section, objectSize int) int {
 }
 
 func (r *Reader) Close() error {
-	return r.file.Close()
+	err := munmapFile(r.mapping)
+	r.mapping = nil
+	return errors.Join(err, r.file.Close())
 }
 
 func NewReader(filename string) (*Reader, error) {
@@ -162,22 +272,32 @@ func NewReader(filename string) (*Reader, error) {
 		if err := r.readAt(0, &r.header); err != nil {
 			return err
 		}
-		if string(r.header.Magic[:]) != fileMagic {
-			return fmt.Errorf("wrong magic: %q, expected %q", string(r.header.Magic[:]), fileMagic)
+		if magic := string(r.header.Magic[:]); magic != fileMagic {
+			if magic[:fileMagicVersionOffset] == fileMagic[:fileMagicVersionOffset] {
+				return fmt.Errorf("%w: version %d, expected %d", ErrUnsupportedFormat, binary.BigEndian.Uint32(r.header.Magic[fileMagicVersionOffset:]), binary.BigEndian.Uint32([]byte(fileMagic[fileMagicVersionOffset:])))
+			}
+			return fmt.Errorf("wrong magic: %q, expected %q", magic, fileMagic)
 		}
 		for _, s := range r.header.Sections {
 			if uint64(r.size) < s.End {
 				r.size = int64(s.End)
 			}
 		}
+		if *mmapIndexes {
+			mapping, err := mmapFile(r.file, r.size)
+			if err != nil {
+				return fmt.Errorf("mmap of %q failed: %w", filename, err)
+			}
+			r.mapping = mapping
+		}
 
 		// read imports
-		importFilenames := make([]byte, r.header.Sections[sectionImportFilenames].size())
-		if err := r.readObjects(sectionImportFilenames, importFilenames); err != nil {
+		importFilenames, err := readSection[byte](&r, sectionImportFilenames)
+		if err != nil {
 			return err
 		}
-		importEntries := make([]importEntry, r.header.Sections[sectionImports].size()/int64(unsafe.Sizeof(importEntry{})))
-		if err := r.readObjects(sectionImports, importEntries); err != nil {
+		importEntries, err := readSection[importEntry](&r, sectionImports)
+		if err != nil {
 			return err
 		}
 		for _, ie := range importEntries {
@@ -189,17 +309,20 @@ func NewReader(filename string) (*Reader, error) {
 			})
 		}
 
-		// read hosts
-		v4hosts := make([]byte, r.header.Sections[sectionV4Hosts].size())
-		if err := r.readObjects(sectionV4Hosts, v4hosts); err != nil {
+		// read hosts, they are copied out of the mapping as the returned
+		// net.IPs might outlive the reader
+		v4hosts, err := readSection[byte](&r, sectionV4Hosts)
+		if err != nil {
 			return err
 		}
-		v6hosts := make([]byte, r.header.Sections[sectionV6Hosts].size())
-		if err := r.readObjects(sectionV6Hosts, v6hosts); err != nil {
+		v4hosts = bytes.Clone(v4hosts)
+		v6hosts, err := readSection[byte](&r, sectionV6Hosts)
+		if err != nil {
 			return err
 		}
-		hostGroups := make([]hostGroupEntry, r.header.Sections[sectionHostGroups].size()/int64(unsafe.Sizeof(hostGroupEntry{})))
-		if err := r.readObjects(sectionHostGroups, hostGroups); err != nil {
+		v6hosts = bytes.Clone(v6hosts)
+		hostGroups, err := readSection[hostGroupEntry](&r, sectionHostGroups)
+		if err != nil {
 			return err
 		}
 		for _, hg := range hostGroups {
@@ -307,6 +430,41 @@ func (r *Reader) StreamIDs() map[uint64]uint32 {
 	return r.containedStreamIds
 }
 
+// Hosts returns all client and server hosts of the streams in the index.
+func (r *Reader) Hosts() []net.IP {
+	res := []net.IP(nil)
+	for _, hg := range r.hostGroups {
+		for i := 0; i < hg.hostCount; i++ {
+			res = append(res, hg.get(uint16(i)))
+		}
+	}
+	return res
+}
+
+// ServerPorts returns the sorted distinct server ports of the streams in
+// the index.
+func (r *Reader) ServerPorts() ([]uint16, error) {
+	res := []uint16(nil)
+	for port := 0; port <= math.MaxUint16; {
+		idx, ok, err := r.streamIndexByLookup(sectionStreamsByServerPort, func(s *stream) (bool, error) {
+			return int(s.ServerPort) >= port, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		s, err := r.streamByIndex(idx)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s.ServerPort)
+		port = int(s.ServerPort) + 1
+	}
+	return res, nil
+}
+
 func (s stream) wrap(r *Reader, idx uint32) (*Stream, error) {
 	return &Stream{
 		stream: s,
@@ -366,6 +524,56 @@ func (r *Reader) streamIndexByLookup(section section, f func(s *stream) (bool, e
 	return streamIndex, true, firstError
 }
 
+// lookupRange returns the range of positions in the lookup section that
+// contain streams for which compare returns 0. compare has to return a
+// negative number for streams before and a positive number for streams after
+// the range.
+func (r *Reader) lookupRange(section section, compare func(s *stream) int) (int, int, error) {
+	var firstError error
+	search := func(f func(int) bool) int {
+		return sort.Search(r.StreamCount(), func(i int) bool {
+			if firstError != nil {
+				return false
+			}
+			streamIndex, err := r.readLookup(section, i)
+			if err != nil {
+				firstError = err
+				return false
+			}
+			s, err := r.streamByIndex(streamIndex)
+			if err != nil {
+				firstError = err
+				return false
+			}
+			return f(compare(s))
+		})
+	}
+	begin := search(func(c int) bool {
+		return c >= 0
+	})
+	end := search(func(c int) bool {
+		return c > 0
+	})
+	return begin, end, firstError
+}
+
+// readLookupRange returns the stream indexes at the positions [begin, end)
+// of the lookup section. For memory-mapped indexes the slice points into the
+// mapping and must not be modified.
+func (r *Reader) readLookupRange(section section, begin, end int) ([]uint32, error) {
+	if begin >= end {
+		return nil, nil
+	}
+	if r.mapping != nil && isLittleEndian {
+		return mappedSlice[uint32](r, r.calculateOffset(section, 4, begin), end-begin)
+	}
+	res := make([]uint32, end-begin)
+	if err := r.readAt(r.calculateOffset(section, 4, begin), res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (r *Reader) StreamByFirstPacketSource(pcapFilename string, packetIndex uint64) (*Stream, error) {
 	firstPacketSource := func(s *stream) (string, uint64, error) {
 		p, err := r.packetByIndex(uint64(s.PacketInfoStart))
@@ -467,24 +675,43 @@ func (s *Stream) Packets() ([]Packet, error) {
 	return packets, nil
 }
 
-func (s *Stream) Data() ([]Data, error) {
+// PcapFilenames returns the names of the pcaps containing the packets of the
+// stream, ordered by their first use.
+func (s *Stream) PcapFilenames() ([]string, error) {
+	filenames := []string{}
+	seen := map[uint32]struct{}{}
+	for i := uint64(s.PacketInfoStart); ; i++ {
+		p, err := s.r.packetByIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[p.ImportID]; !ok {
+			seen[p.ImportID] = struct{}{}
+			filenames = append(filenames, s.r.imports[p.ImportID].filename)
+		}
+		if p.Flags&flagsPacketHasNext == 0 {
+			break
+		}
+	}
+	return filenames, nil
+}
+
+// chunkTimes returns the sizes and times of the data chunks of both
+// directions, packets received shortly after each other are merged.
+func (r *Reader) chunkTimes(s *stream) ([2][]chunkTime, error) {
 	off := int64(s.PacketInfoStart) * int64(unsafe.Sizeof(packet{}))
-	sr := io.NewSectionReader(s.r.file, int64(s.r.header.Sections[sectionPackets].Begin)+off, s.r.header.Sections[sectionPackets].size()-off)
+	sr := io.NewSectionReader(r.readerAt(), int64(r.header.Sections[sectionPackets].Begin)+off, r.header.Sections[sectionPackets].size()-off)
 	br := bufio.NewReader(sr)
 	p := packet{}
-	refTime := s.FirstPacket()
-	type packetTime struct {
-		ts time.Time
-		sz uint64
-	}
+	refTime := r.ReferenceTime.Add(time.Duration(s.FirstPacketTimeNS) * time.Nanosecond)
 	expectWraps := (time.Duration(s.LastPacketTimeNS-s.FirstPacketTimeNS)*time.Nanosecond + time.Microsecond) / (time.Microsecond << 32)
-	packetTimes := [2][]packetTime{nil, nil}
+	packetTimes := [2][]chunkTime{nil, nil}
 	lastRelPacketTimeMS := uint32(0)
 	prevTs := time.Time{}
 	prevDir := uint8(0)
 	for {
 		if err := binary.Read(br, binary.LittleEndian, &p); err != nil {
-			return nil, err
+			return [2][]chunkTime{}, err
 		}
 		if expectWraps != 0 {
 			if p.RelPacketTimeMS < lastRelPacketTimeMS {
@@ -500,7 +727,7 @@ func (s *Stream) Data() ([]Data, error) {
 			if len(*ci) != 0 && dir == prevDir && ts.Sub(prevTs) < ChunkSplitThreshold {
 				(*ci)[len(*ci)-1].sz += uint64(p.DataSize)
 			} else {
-				*ci = append(*ci, packetTime{ts, uint64(p.DataSize)})
+				*ci = append(*ci, chunkTime{ts, uint64(p.DataSize)})
 			}
 			prevTs = ts
 			prevDir = dir
@@ -510,13 +737,21 @@ func (s *Stream) Data() ([]Data, error) {
 		}
 		if p.SkipPacketsForData != 0 && expectWraps == 0 {
 			if _, err := br.Discard(int(p.SkipPacketsForData) * int(unsafe.Sizeof(packet{}))); err != nil {
-				return nil, err
+				return [2][]chunkTime{}, err
 			}
 		}
 	}
+	return packetTimes, nil
+}
+
+func (s *Stream) Data() ([]Data, error) {
+	packetTimes, err := s.r.chunkTimes(&s.stream)
+	if err != nil {
+		return nil, err
+	}
 	data := []Data{}
-	sr = io.NewSectionReader(s.r.file, int64(s.r.header.Sections[sectionData].Begin+s.DataStart), s.r.header.Sections[sectionData].size()-int64(s.DataStart))
-	br = bufio.NewReader(sr)
+	sr := io.NewSectionReader(s.r.readerAt(), int64(s.r.header.Sections[sectionData].Begin+s.DataStart), s.r.header.Sections[sectionData].size()-int64(s.DataStart))
+	br := bufio.NewReader(sr)
 
 	content := [2][]byte{}
 	content[DirectionClientToServer] = make([]byte, s.ClientBytes)
@@ -589,11 +824,19 @@ func (s *Stream) Reader() *Reader {
 	return s.r
 }
 
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
 func (s *Stream) MarshalJSON() ([]byte, error) {
 	type SideInfo struct {
-		Host  string
-		Port  uint16
-		Bytes uint64
+		Host       string
+		Port       uint16
+		Bytes      uint64
+		Hash       string
+		PrefixHash string
+		Entropy    float64
+		Printable  float64
 	}
 	return json.Marshal(struct {
 		ID                      uint64
@@ -606,14 +849,22 @@ func (s *Stream) MarshalJSON() ([]byte, error) {
 		FirstPacket: s.FirstPacket().Local(),
 		LastPacket:  s.LastPacket().Local(),
 		Client: SideInfo{
-			Host:  s.r.hostGroups[s.HostGroup].get(s.ClientHost).String(),
-			Port:  s.ClientPort,
-			Bytes: s.ClientBytes,
+			Host:       s.r.hostGroups[s.HostGroup].get(s.ClientHost).String(),
+			Port:       s.ClientPort,
+			Bytes:      s.ClientBytes,
+			Hash:       formatHash(s.ClientHash),
+			PrefixHash: formatHash(s.ClientPrefixHash),
+			Entropy:    float64(s.ClientEntropy) / 1000,
+			Printable:  float64(s.ClientPrintable) / 1000,
 		},
 		Server: SideInfo{
-			Host:  s.r.hostGroups[s.HostGroup].get(s.ServerHost).String(),
-			Port:  s.ServerPort,
-			Bytes: s.ServerBytes,
+			Host:       s.r.hostGroups[s.HostGroup].get(s.ServerHost).String(),
+			Port:       s.ServerPort,
+			Bytes:      s.ServerBytes,
+			Hash:       formatHash(s.ServerHash),
+			PrefixHash: formatHash(s.ServerPrefixHash),
+			Entropy:    float64(s.ServerEntropy) / 1000,
+			Printable:  float64(s.ServerPrintable) / 1000,
 		},
 		Protocol: s.Protocol(),
 		Index:    s.r.filename,
@@ -639,7 +890,7 @@ func (r *Reader) AllStreams(handler func(*Stream) error) error {
 
 func (r *Reader) sectionReader(section section) *io.SectionReader {
 	s := r.header.Sections[section]
-	return io.NewSectionReader(r.file, int64(s.Begin), s.size())
+	return io.NewSectionReader(r.readerAt(), int64(s.Begin), s.size())
 }
 
 func (d *Data) MarshalJSON() ([]byte, error) {
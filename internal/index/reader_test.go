@@ -3,6 +3,7 @@ package index
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"testing"
 	"time"
 
@@ -76,6 +77,35 @@ func TestReader(t *testing.T) {
 	}
 }
 
+func TestReaderHostsAndPorts(t *testing.T) {
+	streams := map[uint64]streamInfo{
+		0: makeStream("10.0.0.1:1234", "10.0.0.2:80", t1, []string{"foo"}),
+		1: makeStream("10.0.0.3:1235", "10.0.0.2:443", t1.Add(time.Second), []string{"foo"}),
+		2: makeStream("10.0.0.1:1236", "10.0.0.4:80", t1.Add(2*time.Second), []string{"foo"}),
+		3: makeStream("10.0.0.1:1237", "10.0.0.2:22", t1.Add(3*time.Second), []string{"foo"}),
+	}
+	idx, err := makeIndex(t.TempDir(), streams, nil)
+	if err != nil {
+		t.Fatalf("makeIndex failed: %v", err)
+	}
+	defer idx.Close()
+	hosts := []string(nil)
+	for _, h := range idx.Hosts() {
+		hosts = append(hosts, h.String())
+	}
+	slices.Sort(hosts)
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}; !slices.Equal(hosts, want) {
+		t.Errorf("Reader.Hosts() = %v, want %v", hosts, want)
+	}
+	ports, err := idx.ServerPorts()
+	if err != nil {
+		t.Fatalf("Reader.ServerPorts failed with error: %v", err)
+	}
+	if want := []uint16{22, 80, 443}; !slices.Equal(ports, want) {
+		t.Errorf("Reader.ServerPorts() = %v, want %v", ports, want)
+	}
+}
+
 func TestLongPackets(t *testing.T) {
 	tmpDir := t.TempDir()
 	pi := pcapmetadata.PcapInfo{
@@ -191,3 +221,211 @@ func TestLongPackets(t *testing.T) {
 		}
 	}
 }
+
+func setMmapIndexes(tb testing.TB, enabled bool) {
+	old := *mmapIndexes
+	*mmapIndexes = enabled
+	tb.Cleanup(func() {
+		*mmapIndexes = old
+	})
+}
+
+func TestMmapReader(t *testing.T) {
+	tmpDir := t.TempDir()
+	streams := map[uint64]streamInfo{}
+	for i := 0; i < 10; i++ {
+		streams[uint64(i*100)] = makeStream("1.2.3.4:1234", "4.3.2.1:4321", t1.Add(time.Hour*time.Duration(i)), []string{fmt.Sprintf("foo%d", i), fmt.Sprintf("bar%d", i)})
+	}
+	idx, err := makeIndex(tmpDir, streams, nil)
+	if err != nil {
+		t.Fatalf("makeIndex failed: %v", err)
+	}
+	defer idx.Close()
+	setMmapIndexes(t, true)
+	mmapIdx, err := NewReader(idx.Filename())
+	if err != nil {
+		t.Fatalf("NewReader failed with error: %v", err)
+	}
+	defer mmapIdx.Close()
+	if mmapIdx.mapping == nil {
+		t.Fatal("NewReader did not map the index")
+	}
+	if got, want := mmapIdx.StreamCount(), idx.StreamCount(); got != want {
+		t.Errorf("Reader.StreamCount() = %v, want %v", got, want)
+	}
+	if got, want := mmapIdx.PacketCount(), idx.PacketCount(); got != want {
+		t.Errorf("Reader.PacketCount() = %v, want %v", got, want)
+	}
+	for i := 0; i < idx.StreamCount(); i++ {
+		want, err := idx.streamByIndex(uint32(i))
+		if err != nil {
+			t.Fatalf("Reader.streamByIndex failed with error: %v", err)
+		}
+		got, err := mmapIdx.streamByIndex(uint32(i))
+		if err != nil {
+			t.Fatalf("Reader.streamByIndex failed with error: %v", err)
+		}
+		if *got != *want {
+			t.Errorf("streamByIndex(%d) = %+v, want %+v", i, *got, *want)
+		}
+		wantLookup, err := idx.readLookup(sectionStreamsByFirstPacketTime, i)
+		if err != nil {
+			t.Fatalf("Reader.readLookup failed with error: %v", err)
+		}
+		gotLookup, err := mmapIdx.readLookup(sectionStreamsByFirstPacketTime, i)
+		if err != nil {
+			t.Fatalf("Reader.readLookup failed with error: %v", err)
+		}
+		if gotLookup != wantLookup {
+			t.Errorf("readLookup(%d) = %v, want %v", i, gotLookup, wantLookup)
+		}
+	}
+	for i := 0; i < idx.PacketCount(); i++ {
+		want, err := idx.packetByIndex(uint64(i))
+		if err != nil {
+			t.Fatalf("Reader.packetByIndex failed with error: %v", err)
+		}
+		got, err := mmapIdx.packetByIndex(uint64(i))
+		if err != nil {
+			t.Fatalf("Reader.packetByIndex failed with error: %v", err)
+		}
+		if *got != *want {
+			t.Errorf("packetByIndex(%d) = %+v, want %+v", i, *got, *want)
+		}
+	}
+	wantRange, err := idx.readLookupRange(sectionStreamsByFirstPacketTime, 1, idx.StreamCount())
+	if err != nil {
+		t.Fatalf("Reader.readLookupRange failed with error: %v", err)
+	}
+	gotRange, err := mmapIdx.readLookupRange(sectionStreamsByFirstPacketTime, 1, mmapIdx.StreamCount())
+	if err != nil {
+		t.Fatalf("Reader.readLookupRange failed with error: %v", err)
+	}
+	if !slices.Equal(gotRange, wantRange) {
+		t.Errorf("readLookupRange() = %v, want %v", gotRange, wantRange)
+	}
+	wantSection, err := readSection[uint32](idx, sectionStreamsByServerPort)
+	if err != nil {
+		t.Fatalf("readSection failed with error: %v", err)
+	}
+	gotSection, err := readSection[uint32](mmapIdx, sectionStreamsByServerPort)
+	if err != nil {
+		t.Fatalf("readSection failed with error: %v", err)
+	}
+	if !slices.Equal(gotSection, wantSection) {
+		t.Errorf("readSection() = %v, want %v", gotSection, wantSection)
+	}
+	if _, err := mmapIdx.readLookupRange(sectionStreamsByFirstPacketTime, 0, mmapIdx.StreamCount()*1000); err == nil {
+		t.Error("Reader.readLookupRange out of bounds did not fail")
+	}
+	if _, err := mmapIdx.streamByIndex(uint32(mmapIdx.StreamCount() * 1000)); err == nil {
+		t.Error("Reader.streamByIndex out of bounds did not fail")
+	}
+	for streamID := range streams {
+		s, err := mmapIdx.StreamByID(streamID)
+		if err != nil {
+			t.Fatalf("Reader.StreamByID failed with error: %v", err)
+		}
+		data, err := s.Data()
+		if err != nil {
+			t.Fatalf("Stream.Data failed with error: %v", err)
+		}
+		if len(data) != 2 {
+			t.Fatalf("len(Stream.Data()) = %v, want 2", len(data))
+		}
+		if got, want := string(data[0].Content), fmt.Sprintf("foo%d", streamID/100); got != want {
+			t.Errorf("Stream[%d].Data()[0] = %q, want %q", streamID, got, want)
+		}
+		if got, want := string(data[1].Content), fmt.Sprintf("bar%d", streamID/100); got != want {
+			t.Errorf("Stream[%d].Data()[1] = %q, want %q", streamID, got, want)
+		}
+	}
+}
+
+func makeBenchmarkReader(b *testing.B, mmap bool) *Reader {
+	streams := map[uint64]streamInfo{}
+	for i := 0; i < 1000; i++ {
+		streams[uint64(i)] = makeStream("1.2.3.4:1234", "4.3.2.1:4321", t1.Add(time.Second*time.Duration(i)), []string{"foo", "bar"})
+	}
+	idx, err := makeIndex(b.TempDir(), streams, nil)
+	if err != nil {
+		b.Fatalf("makeIndex failed: %v", err)
+	}
+	idx.Close()
+	setMmapIndexes(b, mmap)
+	r, err := NewReader(idx.Filename())
+	if err != nil {
+		b.Fatalf("NewReader failed with error: %v", err)
+	}
+	b.Cleanup(func() {
+		r.Close()
+	})
+	return r
+}
+
+func BenchmarkReader(b *testing.B) {
+	for _, mode := range []struct {
+		name string
+		mmap bool
+	}{{"file", false}, {"mmap", true}} {
+		b.Run(mode.name, func(b *testing.B) {
+			r := makeBenchmarkReader(b, mode.mmap)
+			b.Run("streamByIndex", func(b *testing.B) {
+				n := uint32(r.StreamCount())
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := r.streamByIndex(uint32(i) % n); err != nil {
+						b.Fatalf("Reader.streamByIndex failed with error: %v", err)
+					}
+				}
+			})
+			b.Run("packetByIndex", func(b *testing.B) {
+				n := uint64(r.PacketCount())
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := r.packetByIndex(uint64(i) % n); err != nil {
+						b.Fatalf("Reader.packetByIndex failed with error: %v", err)
+					}
+				}
+			})
+			b.Run("readLookup", func(b *testing.B) {
+				n := r.StreamCount()
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := r.readLookup(sectionStreamsByFirstPacketTime, i%n); err != nil {
+						b.Fatalf("Reader.readLookup failed with error: %v", err)
+					}
+				}
+			})
+			b.Run("readLookupRange", func(b *testing.B) {
+				n := r.StreamCount()
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := r.readLookupRange(sectionStreamsByFirstPacketTime, i%n, n); err != nil {
+						b.Fatalf("Reader.readLookupRange failed with error: %v", err)
+					}
+				}
+			})
+			b.Run("readSection", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := readSection[uint32](r, sectionStreamsByServerPort); err != nil {
+						b.Fatalf("readSection failed with error: %v", err)
+					}
+				}
+			})
+			b.Run("Data", func(b *testing.B) {
+				s, err := r.StreamByID(0)
+				if err != nil {
+					b.Fatalf("Reader.StreamByID failed with error: %v", err)
+				}
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := s.Data(); err != nil {
+						b.Fatalf("Stream.Data failed with error: %v", err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -7,12 +7,16 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"math/rand/v2"
+	"path"
 	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/spq/pkappa2/internal/query"
 	"github.com/spq/pkappa2/internal/tools/bitmask"
+	"rsc.io/binaryregexp"
 )
 
 type (
@@ -39,25 +43,164 @@ type (
 		Client []string
 		Server []string
 	}
+	// DataConditionRegex is the regex of an element of a data condition.
+	DataConditionRegex struct {
+		// Converter is the converter of the data condition, it is
+		// empty if the condition applies to the captured data and all
+		// converters and "none" for only the captured data
+		Converter string
+		Direction Direction
+		Regex     string
+		compiled  *binaryregexp.Regexp
+	}
 	resultData struct {
-		streams             []*Stream
-		matchingQueryPart   []bitmask.ConnectedBitmask
-		groups              map[string]int
+		streams           []*Stream
+		matchingQueryPart []bitmask.ConnectedBitmask
+		// groups contains the streams of each group, ordered by the sorting
+		groups map[string][]*Stream
+		// streamGroups contains the group key of every stream in a group
+		streamGroups        map[uint64]string
 		variableAssociation map[uint64]int
 		variableData        []variableDataCollection
 		resultDropped       uint
+		// groupAlternatives contains the best streams of each group that
+		// are not part of the result, ordered by the sorting
+		groupAlternatives map[string][]*Stream
+		// searchContexts contains the matching search contexts of every
+		// stream, they are only kept for collecting the result details
+		searchContexts map[uint64][]*searchContext
+	}
+	// ResultDetails are details of the streams of a search result, they are
+	// only collected by SearchStreamsWithDetails.
+	ResultDetails struct {
+		// SubQueryStreams contains the streams of each sub query a result
+		// was matched with by the stream id of the result, at most
+		// maxResultDetails per sub query
+		SubQueryStreams map[uint64]map[string][]*Stream
+		// GroupAlternatives contains the best streams of the group of a
+		// result that are not part of the result by the stream id of the
+		// result, at most maxResultDetails. They are only collected if
+		// requested.
+		GroupAlternatives map[uint64][]*Stream
 	}
 	queryPart struct {
 		filters  []func(*searchContext, *stream) (bool, error)
 		lookups  []func() ([]uint32, error)
 		possible bool
+		// descriptions of the filters, lookups and regex prefilters,
+		// only used for explaining searches
+		filterNames, lookupNames, regexPrefilters []string
 	}
 	grouper struct {
 		key  func(s *Stream) []byte
 		vars []string
 	}
+	searchCandidate struct {
+		si               uint32
+		activeQueryParts bitmask.ShortBitmask
+	}
+	searchCandidates struct {
+		// streams is nil if all streams of the index are candidates
+		// in file order, using activeQueryParts for all of them
+		streams          []searchCandidate
+		activeQueryParts bitmask.ShortBitmask
+		count            int
+		// stopAtLimit is set if the candidates are in the order of
+		// the sorting, so no later candidate can make it into the result
+		stopAtLimit bool
+	}
+	searchEvaluation struct {
+		stream                 *Stream
+		matchingQueryParts     bitmask.ShortBitmask
+		matchingSearchContexts []*searchContext
+	}
+	searchCollector struct {
+		result      *resultData
+		grouper     *grouper
+		sortingLess func(a, b *Stream) bool
+		limit       uint
+		// groupLimit is the maximum number of streams per group
+		groupLimit uint
+		// positions contains the index of every stream in the result,
+		// it is only maintained when grouping
+		positions map[*Stream]int
+		// visit receives the streams instead of the result if it is set
+		visit func(*Stream)
+		// emit receives the streams of the result as soon as their
+		// position is final, the first skip streams are dropped
+		emit    func([]*Stream) error
+		skip    uint
+		emitted uint
+		emitErr error
+		// final reports if no stream of the tasks that were not finished
+		// yet can precede the stream, it is nil if that is only known
+		// once all tasks are finished
+		final func(s *Stream, tasksFinished int) bool
+		// progress is called after all candidates of a task were considered
+		progress      func(SearchProgress)
+		tasks         int
+		tasksFinished int
+		// details enables keeping the search contexts of the streams
+		details bool
+		// alternatives enables collecting the group alternatives
+		alternatives bool
+	}
+	// searchHooks are optional observers of searchStreams.
+	searchHooks struct {
+		// explanation is filled with the details of the search
+		explanation *SearchExplanation
+		// visit receives the matching streams instead of the result,
+		// unless grouping or sampling requires collecting them
+		visit func(*Stream)
+		// emit receives the streams of the result as soon as their position
+		// is final, unless grouping or sampling is requested
+		emit func([]*Stream) error
+		// progress is called whenever an index of the main query was searched
+		progress func(SearchProgress)
+		// details is filled with the details of the returned streams
+		details *ResultDetails
+		// groupAlternatives enables collecting the streams of each group
+		// that are not part of the result, it requires details
+		groupAlternatives bool
+	}
+	// SearchOptions are the parameters of a search besides the conditions.
+	SearchOptions struct {
+		// LimitIDs restricts the search to the given stream ids if set.
+		LimitIDs *bitmask.LongBitmask
+		// ReferenceTime is the time relative times are evaluated against.
+		ReferenceTime time.Time
+		Grouping      *query.Grouping
+		Sorting       []query.Sorting
+		Sampling      *query.Sampling
+		// Limit is the maximum number of results, 0 means unlimited.
+		// Aggregations and explanations ignore Limit and Skip.
+		Limit, Skip    uint
+		TagDetails     map[string]query.TagDetails
+		HostSets       query.HostSets
+		Ticks          *query.TickSchedule
+		Imports        []string
+		Converters     map[string]ConverterAccess
+		ExtractRegexes bool
+	}
+	// SearchProgress describes how far a search has progressed.
+	SearchProgress struct {
+		// IndexesSearched of the Indexes were searched completely
+		IndexesSearched, Indexes int
+		// Results is the number of results collected so far
+		Results uint
+	}
 )
 
+func (c *searchCandidates) get(i int) searchCandidate {
+	if c.streams == nil {
+		return searchCandidate{
+			si:               uint32(i),
+			activeQueryParts: c.activeQueryParts,
+		}
+	}
+	return c.streams[i]
+}
+
 func (sqs *subQuerySelection) remove(subqueries []string, forbidden []*bitmask.ConnectedBitmask) {
 	oldRemaining := sqs.remaining
 	sqs.remaining = nil
@@ -92,6 +235,40 @@ func (sqs *subQuerySelection) empty() bool {
 	return len(sqs.remaining) == 0
 }
 
+// streams returns the streams of the sub query results that are still
+// allowed by the selection, ordered like the sub query results.
+func (sqs *subQuerySelection) streams(subQueryResults map[string]resultData) map[string][]*Stream {
+	res := map[string][]*Stream(nil)
+	for sq, rd := range subQueryResults {
+		selected := bitmask.ConnectedBitmask{}
+		for _, remaining := range sqs.remaining {
+			if bm, ok := remaining[sq]; ok {
+				selected.Or(bm)
+			}
+		}
+		streams := []*Stream(nil)
+		for i, l := 0, min(selected.Len(), len(rd.streams)); i < l && len(streams) < maxResultDetails; i++ {
+			if selected.IsSet(uint(i)) {
+				streams = append(streams, rd.streams[i])
+			}
+		}
+		if len(streams) == 0 {
+			continue
+		}
+		if res == nil {
+			res = map[string][]*Stream{}
+		}
+		res[sq] = streams
+	}
+	return res
+}
+
+const (
+	// maxResultDetails limits the number of sub query streams and group
+	// alternatives returned per result
+	maxResultDetails = 32
+)
+
 var (
 	alwaysSuccess = ([]func(sc *searchContext, s *stream) (bool, error))(nil)
 	alwaysFail    = []func(sc *searchContext, s *stream) (bool, error){
@@ -108,20 +285,152 @@ func isAlwaysFail(f []func(*searchContext, *stream) (bool, error)) bool {
 	return &f[0] == &alwaysFail[0]
 }
 
-func (r *Reader) buildSearchObjects(subQuery string, queryPartIndex int, previousResults map[string]resultData, refTime time.Time, q *query.Conditions, superseedingIndexes []*Reader, limitIDs *bitmask.LongBitmask, tagDetails map[string]query.TagDetails, converters map[string]ConverterAccess) (queryPart, error) {
+// hash returns the xor of the stream's hashes of the given types.
+func (s *stream) hash(types []query.HashConditionSourceType) uint64 {
+	h := uint64(0)
+	for _, t := range types {
+		switch t {
+		case query.HashConditionSourceTypeClient:
+			h ^= s.ClientHash
+		case query.HashConditionSourceTypeServer:
+			h ^= s.ServerHash
+		case query.HashConditionSourceTypeClientPrefix:
+			h ^= s.ClientPrefixHash
+		case query.HashConditionSourceTypeServerPrefix:
+			h ^= s.ServerPrefixHash
+		}
+	}
+	return h
+}
+
+// hostLookup creates a lookup for the streams allowed by the host condition
+// bitmaps, using the client or the server host lookup, whichever results in
+// fewer streams. It returns nil if no lookup reduces the number of streams.
+func (r *Reader) hostLookup(hostConditionBitmaps [][]uint64) (func() ([]uint32, error), error) {
+	type lookupRange struct {
+		begin, end int
+	}
+	bestSection, bestRanges, bestCount := section(0), []lookupRange(nil), r.StreamCount()
+	for _, side := range []struct {
+		section section
+		server  bool
+	}{
+		{sectionStreamsByClientHost, false},
+		{sectionStreamsByServerHost, true},
+	} {
+		type allowedHost struct {
+			hostGroup, host uint16
+		}
+		allowedHosts := []allowedHost(nil)
+		allAllowed := true
+		for hgi, bm := range hostConditionBitmaps {
+			if bm == nil {
+				allAllowed = false
+				continue
+			}
+			count := r.hostGroups[hgi].hostCount
+			for h := 0; h < count; h++ {
+				allowed := false
+				for o := 0; o < count && !allowed; o++ {
+					bit := h + o*count
+					if side.server {
+						bit = o + h*count
+					}
+					allowed = (bm[bit/64]>>(bit%64))&1 == 0
+				}
+				if allowed {
+					allowedHosts = append(allowedHosts, allowedHost{uint16(hgi), uint16(h)})
+				} else {
+					allAllowed = false
+				}
+			}
+		}
+		if allAllowed {
+			continue
+		}
+		ranges := []lookupRange(nil)
+		count := 0
+		for _, ah := range allowedHosts {
+			begin, end, err := r.lookupRange(side.section, func(s *stream) int {
+				if s.HostGroup != ah.hostGroup {
+					return int(s.HostGroup) - int(ah.hostGroup)
+				}
+				if side.server {
+					return int(s.ServerHost) - int(ah.host)
+				}
+				return int(s.ClientHost) - int(ah.host)
+			})
+			if err != nil {
+				return nil, err
+			}
+			if begin == end {
+				continue
+			}
+			ranges = append(ranges, lookupRange{begin, end})
+			count += end - begin
+			if count >= bestCount {
+				break
+			}
+		}
+		if count < bestCount {
+			bestSection, bestRanges, bestCount = side.section, ranges, count
+		}
+	}
+	if bestCount == r.StreamCount() {
+		return nil, nil
+	}
+	return func() ([]uint32, error) {
+		if len(bestRanges) == 1 {
+			return r.readLookupRange(bestSection, bestRanges[0].begin, bestRanges[0].end)
+		}
+		lookup := make([]uint32, 0, bestCount)
+		for _, lr := range bestRanges {
+			l, err := r.readLookupRange(bestSection, lr.begin, lr.end)
+			if err != nil {
+				return nil, err
+			}
+			lookup = append(lookup, l...)
+		}
+		return lookup, nil
+	}, nil
+}
+
+func (r *Reader) buildSearchObjects(subQuery string, queryPartIndex int, previousResults map[string]resultData, refTime time.Time, q *query.Conditions, superseedingIndexes []*Reader, limitIDs *bitmask.LongBitmask, tagDetails map[string]query.TagDetails, converters map[string]ConverterAccess, similarityReferences map[uint64]*streamSketches) (queryPart, error) {
 	filters := []func(*searchContext, *stream) (bool, error)(nil)
 	lookups := []func() ([]uint32, error)(nil)
+	filterNames, lookupNames := []string(nil), []string(nil)
+	addFilter := func(name string, f func(*searchContext, *stream) (bool, error)) {
+		filters = append(filters, f)
+		filterNames = append(filterNames, name)
+	}
+	addLookup := func(name string, l func() ([]uint32, error)) {
+		lookups = append(lookups, l)
+		lookupNames = append(lookupNames, name)
+	}
 
 	// filter to caller requested ids
 	if limitIDs != nil {
-		filters = append(filters, func(_ *searchContext, s *stream) (bool, error) {
+		addFilter("requested ids", func(_ *searchContext, s *stream) (bool, error) {
 			return limitIDs.IsSet(uint(s.StreamID)), nil
 		})
+		// use the ids as a lookup only if there are fewer of them than
+		// streams in the index, otherwise scanning the index is cheaper
+		if limitIDs.OnesCount() < r.StreamCount() {
+			addLookup("requested ids", func() ([]uint32, error) {
+				res := []uint32(nil)
+				for id, maxID := uint(r.MinStreamID()), uint(r.MaxStreamID()); limitIDs.Next(&id) && id <= maxID; id++ {
+					if si, ok := r.containedStreamIds[uint64(id)]; ok {
+						res = append(res, si)
+					}
+				}
+				return res, nil
+			})
+		}
 	}
 
 	// filter out streams superseeded by newer indexes
 	if len(superseedingIndexes) != 0 {
-		filters = append(filters, func(_ *searchContext, s *stream) (bool, error) {
+		addFilter("not superseded", func(_ *searchContext, s *stream) (bool, error) {
 			for _, r2 := range superseedingIndexes {
 				if _, ok := r2.containedStreamIds[s.StreamID]; ok {
 					return false, nil
@@ -132,6 +441,7 @@ func (r *Reader) buildSearchObjects(subQuery string, queryPartIndex int, previou
 	}
 
 	minIDFilter, maxIDFilter := uint64(0), uint64(math.MaxUint64)
+	minServerPortFilter, maxServerPortFilter := 0, math.MaxUint16
 	hostConditionBitmaps := [][]uint64(nil)
 	dcc := dataConditionsContainer{}
 conditions:
@@ -192,10 +502,10 @@ conditions:
 				}
 			}
 			if f != nil {
-				filters = append(filters, func(_ *searchContext, s *stream) (bool, error) {
+				addFilter(cc.String(), func(_ *searchContext, s *stream) (bool, error) {
 					return f(s.StreamID), nil
 				})
-				lookups = append(lookups, func() ([]uint32, error) {
+				addLookup(cc.String(), func() ([]uint32, error) {
 					lookup := []uint32(nil)
 					for id, index := range r.containedStreamIds {
 						if f(id) {
@@ -219,7 +529,7 @@ conditions:
 				continue
 			}
 			if len(cc.SubQueries) == 1 {
-				filters = append(filters, func(_ *searchContext, s *stream) (bool, error) {
+				addFilter(cc.String(), func(_ *searchContext, s *stream) (bool, error) {
 					return s.Flags&cc.Mask != cc.Value, nil
 				})
 				continue
@@ -252,7 +562,7 @@ conditions:
 					}
 				}
 			}
-			filters = append(filters, func(sc *searchContext, s *stream) (bool, error) {
+			addFilter(cc.String(), func(sc *searchContext, s *stream) (bool, error) {
 				forbidden, possible := flagValues[s.Flags&cc.Mask]
 				if !possible {
 					// no combination of sub queries produces the forbidden result
@@ -265,6 +575,116 @@ conditions:
 				sc.allowedSubQueries.remove(subqueries, forbidden)
 				return !sc.allowedSubQueries.empty(), nil
 			})
+		case *query.HashCondition:
+			shouldEvaluate := false
+			for _, hcs := range cc.HashConditionSources {
+				if hcs.SubQuery == subQuery {
+					shouldEvaluate = true
+				} else if _, ok := previousResults[hcs.SubQuery]; !ok {
+					shouldEvaluate = false
+					break
+				}
+			}
+			if !shouldEvaluate {
+				continue
+			}
+			myTypes, otherTypes := []query.HashConditionSourceType(nil), []query.HashConditionSourceType(nil)
+			otherSubQuery := ""
+			for _, hcs := range cc.HashConditionSources {
+				if hcs.SubQuery == subQuery {
+					myTypes = append(myTypes, hcs.Type)
+					continue
+				}
+				if otherSubQuery != "" && otherSubQuery != hcs.SubQuery {
+					return queryPart{}, errors.New("complex hash condition not supported")
+				}
+				otherSubQuery = hcs.SubQuery
+				otherTypes = append(otherTypes, hcs.Type)
+			}
+			if otherSubQuery == "" {
+				addFilter(cc.String(), func(_ *searchContext, s *stream) (bool, error) {
+					return (s.hash(myTypes) == cc.Hash) != cc.Invert, nil
+				})
+				continue
+			}
+			relevantResults := previousResults[otherSubQuery]
+			otherResults := bitmask.ConnectedBitmask{}
+			resultsByHash := map[uint64]*bitmask.ConnectedBitmask{}
+			for rIdx, res := range relevantResults.streams {
+				h := res.hash(otherTypes)
+				bm := resultsByHash[h]
+				if bm == nil {
+					bm = &bitmask.ConnectedBitmask{}
+					resultsByHash[h] = bm
+				}
+				bm.Set(uint(rIdx))
+				otherResults.Set(uint(rIdx))
+			}
+			addFilter(cc.String(), func(sc *searchContext, s *stream) (bool, error) {
+				matching := resultsByHash[s.hash(myTypes)^cc.Hash]
+				forbidden := &otherResults
+				if cc.Invert {
+					if matching == nil {
+						return true, nil
+					}
+					forbidden = matching
+				} else if matching != nil {
+					tmp := otherResults.SubCopy(*matching)
+					forbidden = &tmp
+				}
+				sc.allowedSubQueries.remove([]string{otherSubQuery}, []*bitmask.ConnectedBitmask{forbidden})
+				return !sc.allowedSubQueries.empty(), nil
+			})
+		case *query.SimilarityCondition:
+			if cc.SubQuery != subQuery {
+				continue
+			}
+			ref := similarityReferences[cc.StreamID]
+			if ref == nil {
+				return queryPart{}, fmt.Errorf("stream %d used in similarity filter does not exist", cc.StreamID)
+			}
+			addFilter(cc.String(), func(_ *searchContext, s *stream) (bool, error) {
+				sketches, err := r.sketchesByIndex(r.containedStreamIds[s.StreamID])
+				if err != nil {
+					return false, err
+				}
+				return (sketches.similarity(ref, cc.Type) >= cc.Threshold) != cc.Invert, nil
+			})
+		case *query.PcapCondition:
+			if cc.SubQuery != subQuery {
+				continue
+			}
+			// check which imports of the index are accepted
+			accepted := make([]bool, len(r.imports))
+			someAccepted, someRejected := false, false
+			for i, imp := range r.imports {
+				matched := false
+				for _, p := range cc.Patterns {
+					if ok, _ := path.Match(p, imp.filename); ok {
+						matched = true
+						break
+					}
+				}
+				accepted[i] = matched != cc.Invert
+				if accepted[i] {
+					someAccepted = true
+				} else {
+					someRejected = true
+				}
+			}
+			if !someAccepted {
+				return queryPart{}, nil
+			}
+			if !someRejected {
+				continue
+			}
+			addFilter(cc.String(), func(_ *searchContext, s *stream) (bool, error) {
+				p, err := r.packetByIndex(uint64(s.PacketInfoStart))
+				if err != nil {
+					return false, err
+				}
+				return accepted[p.ImportID], nil
+			})
 		case *query.HostCondition:
 			hcsc, hcss := false, false
 			usedType := map[query.HostConditionSourceType]*bool{
@@ -317,14 +737,14 @@ conditions:
 					for hgi, hg := range r.hostGroups {
 						forbiddenSubQueryResultsPerHostGroup[hgi] = &otherHosts[hg.hostSize/16]
 					}
-					filters = append(filters, func(sc *searchContext, s *stream) (bool, error) {
+					addFilter(cc.String(), func(sc *searchContext, s *stream) (bool, error) {
 						f := forbiddenSubQueryResultsPerHostGroup[s.HostGroup]
 						sc.allowedSubQueries.remove([]string{otherSubQuery}, []*bitmask.ConnectedBitmask{f})
 						return !sc.allowedSubQueries.empty(), nil
 					})
 					continue
 				}
-				filters = append(filters, func(sc *searchContext, s *stream) (bool, error) {
+				addFilter(cc.String(), func(sc *searchContext, s *stream) (bool, error) {
 					myHG := &r.hostGroups[s.HostGroup]
 					myHid := s.ClientHost
 					if myHcss {
@@ -449,8 +869,20 @@ conditions:
 					}
 				}
 			}
+			if len(cc.Summands) == 1 && cc.Summands[0].SubQuery == subQuery && cc.Summands[0].Type == query.NumberConditionSummandTypeServerPort {
+				switch cc.Summands[0].Factor {
+				case +1:
+					// sport >= -N
+					minServerPortFilter = max(minServerPortFilter, -cc.Number)
+				case -1:
+					// sport <= N
+					maxServerPortFilter = min(maxServerPortFilter, cc.Number)
+				}
+			}
 			type factor struct {
-				id, clientBytes, serverBytes, clientPort, serverPort int
+				id, clientBytes, serverBytes, clientPort, serverPort           int
+				clientPackets, serverPackets, clientChunks, serverChunks       int
+				clientEntropy, serverEntropy, clientPrintable, serverPrintable int
 			}
 			factors := map[string]factor{}
 			for _, sum := range cc.Summands {
@@ -469,8 +901,24 @@ conditions:
 					f.clientPort += sum.Factor
 				case query.NumberConditionSummandTypeServerPort:
 					f.serverPort += sum.Factor
-				}
-				if f.clientBytes == 0 && f.clientPort == 0 && f.id == 0 && f.serverBytes == 0 && f.serverPort == 0 {
+				case query.NumberConditionSummandTypeClientPackets:
+					f.clientPackets += sum.Factor
+				case query.NumberConditionSummandTypeServerPackets:
+					f.serverPackets += sum.Factor
+				case query.NumberConditionSummandTypeClientChunks:
+					f.clientChunks += sum.Factor
+				case query.NumberConditionSummandTypeServerChunks:
+					f.serverChunks += sum.Factor
+				case query.NumberConditionSummandTypeClientEntropy:
+					f.clientEntropy += sum.Factor
+				case query.NumberConditionSummandTypeServerEntropy:
+					f.serverEntropy += sum.Factor
+				case query.NumberConditionSummandTypeClientPrintable:
+					f.clientPrintable += sum.Factor
+				case query.NumberConditionSummandTypeServerPrintable:
+					f.serverPrintable += sum.Factor
+				}
+				if f == (factor{}) {
 					delete(factors, sum.SubQuery)
 				} else {
 					factors[sum.SubQuery] = f
@@ -482,13 +930,21 @@ conditions:
 			myFactors := factors[subQuery]
 			delete(factors, subQuery)
 			if len(factors) == 0 {
-				filters = append(filters, func(_ *searchContext, s *stream) (bool, error) {
+				addFilter(cc.String(), func(_ *searchContext, s *stream) (bool, error) {
 					n := cc.Number
 					n += myFactors.id * int(s.StreamID)
 					n += myFactors.clientBytes * int(s.ClientBytes)
 					n += myFactors.serverBytes * int(s.ServerBytes)
 					n += myFactors.clientPort * int(s.ClientPort)
 					n += myFactors.serverPort * int(s.ServerPort)
+					n += myFactors.clientPackets * int(s.ClientPackets)
+					n += myFactors.serverPackets * int(s.ServerPackets)
+					n += myFactors.clientChunks * int(s.ClientChunks)
+					n += myFactors.serverChunks * int(s.ServerChunks)
+					n += myFactors.clientEntropy * int(s.ClientEntropy)
+					n += myFactors.serverEntropy * int(s.ServerEntropy)
+					n += myFactors.clientPrintable * int(s.ClientPrintable)
+					n += myFactors.serverPrintable * int(s.ServerPrintable)
 					return n >= 0, nil
 				})
 				continue
@@ -512,6 +968,14 @@ conditions:
 					n += f.serverBytes * int(res.ServerBytes)
 					n += f.clientPort * int(res.ClientPort)
 					n += f.serverPort * int(res.ServerPort)
+					n += f.clientPackets * int(res.ClientPackets)
+					n += f.serverPackets * int(res.ServerPackets)
+					n += f.clientChunks * int(res.ClientChunks)
+					n += f.serverChunks * int(res.ServerChunks)
+					n += f.clientEntropy * int(res.ClientEntropy)
+					n += f.serverEntropy * int(res.ServerEntropy)
+					n += f.clientPrintable * int(res.ClientPrintable)
+					n += f.serverPrintable * int(res.ServerPrintable)
 					if pos, ok := numbers[n]; ok {
 						results[pos].ranges.Set(uint(resId))
 						continue
@@ -538,13 +1002,21 @@ conditions:
 				*r = r.OrCopy(lastSubQueryData[i].ranges)
 			}
 
-			filters = append(filters, func(sc *searchContext, s *stream) (bool, error) {
+			addFilter(cc.String(), func(sc *searchContext, s *stream) (bool, error) {
 				n := cc.Number
 				n += myFactors.id * int(s.StreamID)
 				n += myFactors.clientBytes * int(s.ClientBytes)
 				n += myFactors.serverBytes * int(s.ServerBytes)
 				n += myFactors.clientPort * int(s.ClientPort)
 				n += myFactors.serverPort * int(s.ServerPort)
+				n += myFactors.clientPackets * int(s.ClientPackets)
+				n += myFactors.serverPackets * int(s.ServerPackets)
+				n += myFactors.clientChunks * int(s.ClientChunks)
+				n += myFactors.serverChunks * int(s.ServerChunks)
+				n += myFactors.clientEntropy * int(s.ClientEntropy)
+				n += myFactors.serverEntropy * int(s.ServerEntropy)
+				n += myFactors.clientPrintable * int(s.ClientPrintable)
+				n += myFactors.serverPrintable * int(s.ServerPrintable)
 				if n+minSum >= 0 {
 					return true, nil
 				}
@@ -631,12 +1103,12 @@ conditions:
 						LastPacketTimeNS:  r.lastPacketTimeNS.max,
 					})
 					if matchesOnEarlyPacket != matchesOnLatePacket {
-						filters = append(filters, filter)
+						addFilter(cc.String(), filter)
 					} else if !matchesOnEarlyPacket {
 						return queryPart{}, nil
 					}
 				} else {
-					filters = append(filters, filter)
+					addFilter(cc.String(), filter)
 				}
 				continue
 			}
@@ -681,7 +1153,7 @@ conditions:
 				r := &lastSubQueryData[i+1].ranges
 				*r = r.OrCopy(lastSubQueryData[i].ranges)
 			}
-			filters = append(filters, func(sc *searchContext, s *stream) (bool, error) {
+			addFilter(cc.String(), func(sc *searchContext, s *stream) (bool, error) {
 				d := startD
 				d += time.Duration(myFactors.ftime) * time.Duration(s.FirstPacketTimeNS)
 				d += time.Duration(myFactors.ltime) * time.Duration(s.LastPacketTimeNS)
@@ -745,11 +1217,11 @@ conditions:
 		if !ok {
 			return queryPart{}, nil
 		}
-		lookups = append(lookups, func() ([]uint32, error) {
+		addLookup(fmt.Sprintf("id %d", minIDFilter), func() ([]uint32, error) {
 			return []uint32{idx}, nil
 		})
 	} else if minIDFilter != 0 || maxIDFilter != math.MaxUint64 {
-		lookups = append(lookups, func() ([]uint32, error) {
+		addLookup(fmt.Sprintf("id %d:%d", minIDFilter, maxIDFilter), func() ([]uint32, error) {
 			lookup := []uint32(nil)
 			for id, index := range r.containedStreamIds {
 				if id >= minIDFilter && id <= maxIDFilter {
@@ -759,6 +1231,31 @@ conditions:
 			return lookup, nil
 		})
 	}
+	if minServerPortFilter > maxServerPortFilter {
+		return queryPart{}, nil
+	}
+	if minServerPortFilter != 0 || maxServerPortFilter != math.MaxUint16 {
+		begin, end, err := r.lookupRange(sectionStreamsByServerPort, func(s *stream) int {
+			if int(s.ServerPort) < minServerPortFilter {
+				return -1
+			}
+			if int(s.ServerPort) > maxServerPortFilter {
+				return 1
+			}
+			return 0
+		})
+		if err != nil {
+			return queryPart{}, err
+		}
+		if begin == end {
+			return queryPart{}, nil
+		}
+		if end-begin < r.StreamCount() {
+			addLookup(fmt.Sprintf("sport %d:%d", minServerPortFilter, maxServerPortFilter), func() ([]uint32, error) {
+				return r.readLookupRange(sectionStreamsByServerPort, begin, end)
+			})
+		}
+	}
 	if hostConditionBitmaps != nil {
 		someFail, someSucceed := false, false
 	outer:
@@ -782,7 +1279,7 @@ conditions:
 			return queryPart{}, nil
 		}
 		if someFail {
-			filters = append(filters, func(_ *searchContext, s *stream) (bool, error) {
+			addFilter("host", func(_ *searchContext, s *stream) (bool, error) {
 				hg := hostConditionBitmaps[s.HostGroup]
 				if len(hg) == 0 {
 					return hg != nil, nil
@@ -792,6 +1289,13 @@ conditions:
 				fail := (hg[bit/64]>>(bit%64))&1 != 0
 				return !fail, nil
 			})
+			hostLookup, err := r.hostLookup(hostConditionBitmaps)
+			if err != nil {
+				return queryPart{}, err
+			}
+			if hostLookup != nil {
+				addLookup("host", hostLookup)
+			}
 		}
 	}
 	dataFilters, err := dcc.finalize(r, queryPartIndex, previousResults, converters)
@@ -801,11 +1305,16 @@ conditions:
 	if isAlwaysFail(dataFilters) {
 		return queryPart{}, nil
 	}
-	filters = append(filters, dataFilters...)
+	for _, f := range dataFilters {
+		addFilter("data", f)
+	}
 	return queryPart{
-		filters:  filters,
-		lookups:  lookups,
-		possible: true,
+		filters:         filters,
+		lookups:         lookups,
+		filterNames:     filterNames,
+		lookupNames:     lookupNames,
+		regexPrefilters: dcc.prefilters(),
+		possible:        true,
 	}, nil
 }
 
@@ -814,6 +1323,7 @@ var (
 		query.SortingKeyID:              sectionStreamsByStreamID,
 		query.SortingKeyFirstPacketTime: sectionStreamsByFirstPacketTime,
 		query.SortingKeyLastPacketTime:  sectionStreamsByLastPacketTime,
+		query.SortingKeyServerPort:      sectionStreamsByServerPort,
 	}
 	sorterFunctions = map[query.SortingKey]func(a, b *Stream) bool{
 		query.SortingKeyID: func(a, b *Stream) bool {
@@ -865,16 +1375,125 @@ var (
 		query.SortingKeyServerPort: func(a, b *Stream) bool {
 			return a.ServerPort < b.ServerPort
 		},
+		query.SortingKeyClientHash: func(a, b *Stream) bool {
+			return a.ClientHash < b.ClientHash
+		},
+		query.SortingKeyServerHash: func(a, b *Stream) bool {
+			return a.ServerHash < b.ServerHash
+		},
+		query.SortingKeyClientPrefixHash: func(a, b *Stream) bool {
+			return a.ClientPrefixHash < b.ClientPrefixHash
+		},
+		query.SortingKeyServerPrefixHash: func(a, b *Stream) bool {
+			return a.ServerPrefixHash < b.ServerPrefixHash
+		},
+		query.SortingKeyDuration: func(a, b *Stream) bool {
+			return a.LastPacketTimeNS-a.FirstPacketTimeNS < b.LastPacketTimeNS-b.FirstPacketTimeNS
+		},
+		query.SortingKeyPackets: func(a, b *Stream) bool {
+			return a.ClientPackets+a.ServerPackets < b.ClientPackets+b.ServerPackets
+		},
+		query.SortingKeyClientPackets: func(a, b *Stream) bool {
+			return a.ClientPackets < b.ClientPackets
+		},
+		query.SortingKeyServerPackets: func(a, b *Stream) bool {
+			return a.ServerPackets < b.ServerPackets
+		},
+		query.SortingKeyChunks: func(a, b *Stream) bool {
+			return a.ClientChunks+a.ServerChunks < b.ClientChunks+b.ServerChunks
+		},
+		query.SortingKeyClientChunks: func(a, b *Stream) bool {
+			return a.ClientChunks < b.ClientChunks
+		},
+		query.SortingKeyServerChunks: func(a, b *Stream) bool {
+			return a.ServerChunks < b.ServerChunks
+		},
+		query.SortingKeyClientEntropy: func(a, b *Stream) bool {
+			return a.ClientEntropy < b.ClientEntropy
+		},
+		query.SortingKeyServerEntropy: func(a, b *Stream) bool {
+			return a.ServerEntropy < b.ServerEntropy
+		},
+		query.SortingKeyClientPrintable: func(a, b *Stream) bool {
+			return a.ClientPrintable < b.ClientPrintable
+		},
+		query.SortingKeyServerPrintable: func(a, b *Stream) bool {
+			return a.ServerPrintable < b.ServerPrintable
+		},
 	}
 )
 
+// ExtractDataConditionRegexes returns the regexes of the data conditions of
+// the query and the referenced tags, they can be used to find the data
+// matched by the query. Inverted conditions and elements using variables
+// are left out.
+func ExtractDataConditionRegexes(qs query.ConditionsSet, tagDetails map[string]query.TagDetails) ([]DataConditionRegex, error) {
+	res := []DataConditionRegex(nil)
+	seen := map[DataConditionRegex]struct{}{}
+	queue := []*query.ConditionsSet{&qs}
+	for len(queue) > 0 {
+		cs := *queue[0]
+		queue = queue[1:]
+		for _, ccs := range cs.InlineTagFilters(tagDetails, time.Time{}) {
+			for _, cc := range ccs {
+				switch ccc := cc.(type) {
+				case *query.DataCondition:
+					if ccc.Inverted {
+						continue
+					}
+					for _, e := range ccc.Elements {
+						if len(e.Variables) != 0 {
+							continue
+						}
+						k := DataConditionRegex{
+							Converter: e.ConverterName,
+							Direction: DirectionServerToClient,
+							Regex:     e.Regex,
+						}
+						if e.Flags&query.DataRequirementSequenceFlagsDirection == query.DataRequirementSequenceFlagsDirectionClientToServer {
+							k.Direction = DirectionClientToServer
+						}
+						if _, ok := seen[k]; ok {
+							continue
+						}
+						seen[k] = struct{}{}
+						re, err := binaryregexp.Compile(e.Regex)
+						if err != nil {
+							return nil, err
+						}
+						k.compiled = re
+						res = append(res, k)
+					}
+				case *query.TagCondition:
+					ti := tagDetails[ccc.TagName]
+					queue = append(queue, &ti.Conditions)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// FindAll returns the start and end offsets of at most n successive
+// non-empty matches of the regex in the data, all matches if n is negative.
+func (r *DataConditionRegex) FindAll(data []byte, n int) [][2]int {
+	res := [][2]int(nil)
+	for _, m := range r.compiled.FindAllIndex(data, n) {
+		if m[0] == m[1] {
+			continue
+		}
+		res = append(res, [2]int{m[0], m[1]})
+	}
+	return res
+}
+
 func extractDataRegexes(qs query.ConditionsSet, tagDetails map[string]query.TagDetails) *DataRegexes {
 	dataConditions := DataRegexes{}
 	queue := []*query.ConditionsSet{&qs}
 	for len(queue) > 0 {
 		cs := *queue[0]
 		queue = queue[1:]
-		for _, ccs := range cs.InlineTagFilters(tagDetails) {
+		for _, ccs := range cs.InlineTagFilters(tagDetails, time.Time{}) {
 			for _, cc := range ccs {
 				switch ccc := cc.(type) {
 				case *query.DataCondition:
@@ -899,12 +1518,90 @@ func extractDataRegexes(qs query.ConditionsSet, tagDetails map[string]query.TagD
 	return &dataConditions
 }
 
-func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.LongBitmask, refTime time.Time, qs query.ConditionsSet, grouping *query.Grouping, sorting []query.Sorting, limit, skip uint, tagDetails map[string]query.TagDetails, converters map[string]ConverterAccess, extractRegexes bool) ([]*Stream, bool, *DataRegexes, error) {
+// SearchStreams returns the streams matching the conditions, see
+// SearchOptions for the parameters of the search.
+func SearchStreams(ctx context.Context, indexes []*Reader, qs query.ConditionsSet, options SearchOptions) ([]*Stream, bool, *DataRegexes, error) {
+	return searchStreams(ctx, indexes, qs, options, searchHooks{})
+}
+
+// SearchStreamsWithDetails runs a search like SearchStreams, but also returns
+// the sub query streams the results were matched with and, if alternatives is
+// set, the best streams of each group that are not part of the result. With
+// alternatives, streams only differing from the result by their group are
+// evaluated, so the search might be slower.
+func SearchStreamsWithDetails(ctx context.Context, indexes []*Reader, qs query.ConditionsSet, options SearchOptions, alternatives bool) ([]*Stream, *ResultDetails, bool, *DataRegexes, error) {
+	details := &ResultDetails{}
+	res, hasMore, dataRegexes, err := searchStreams(ctx, indexes, qs, options, searchHooks{
+		details:           details,
+		groupAlternatives: alternatives,
+	})
+	if err != nil {
+		return nil, nil, false, nil, err
+	}
+	return res, details, hasMore, dataRegexes, nil
+}
+
+// SearchStreamsIncremental runs a search like SearchStreams, but passes the
+// results to emit in batches instead of returning them. When sorting by the
+// first packet time, which is the default, and without grouping and sampling,
+// the streams are passed as soon as no stream of the remaining indexes can
+// precede them, otherwise they are passed once the search is complete. The
+// order is the same as the one of SearchStreams. progress is called whenever
+// an index was searched, it may be nil.
+func SearchStreamsIncremental(ctx context.Context, indexes []*Reader, qs query.ConditionsSet, options SearchOptions, emit func([]*Stream) error, progress func(SearchProgress)) (bool, *DataRegexes, error) {
+	res, hasMore, dataRegexes, err := searchStreams(ctx, indexes, qs, options, searchHooks{
+		emit:     emit,
+		progress: progress,
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	if len(res) != 0 {
+		if err := emit(res); err != nil {
+			return false, nil, err
+		}
+	}
+	return hasMore, dataRegexes, nil
+}
+
+// searchStreams implements SearchStreams, the hooks allow observing the search.
+func searchStreams(ctx context.Context, indexes []*Reader, qs query.ConditionsSet, options SearchOptions, hooks searchHooks) ([]*Stream, bool, *DataRegexes, error) {
+	// both are replaced by defaults below
+	limitIDs, sorting := options.LimitIDs, options.Sorting
 	if len(qs) == 0 {
 		return nil, false, nil, nil
 	}
-	qs = qs.InlineTagFilters(tagDetails)
+	explanation := hooks.explanation
+	prepareStart := time.Now()
+	qs = qs.InlineTagFilters(options.TagDetails, options.ReferenceTime)
+	qs, err := qs.InlineHostSets(options.HostSets)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	qs, err = qs.InlineTicks(options.Ticks, options.ReferenceTime)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	qs = qs.InlineImports(options.Imports)
+	similarityReferences, err := findSimilarityReferences(indexes, qs)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if explanation != nil {
+		explanation.Conditions = qs.String()
+	}
 
+	sorterFunction := func(key query.SortingKey) (func(a, b *Stream) bool, error) {
+		if key != query.SortingKeyTick {
+			return sorterFunctions[key], nil
+		}
+		if options.Ticks == nil {
+			return nil, errors.New("sorting by tick requires a tick schedule")
+		}
+		return func(a, b *Stream) bool {
+			return options.Ticks.Tick(a.FirstPacket()) < options.Ticks.Tick(b.FirstPacket())
+		}, nil
+	}
 	var sortingLess func(a, b *Stream) bool
 	switch len(sorting) {
 	case 0:
@@ -915,7 +1612,10 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 		}}
 		fallthrough
 	case 1:
-		sortingLess = sorterFunctions[sorting[0].Key]
+		sortingLess, err = sorterFunction(sorting[0].Key)
+		if err != nil {
+			return nil, false, nil, err
+		}
 		if sorting[0].Dir == query.SortingDirDescending {
 			asc := sortingLess
 			sortingLess = func(a, b *Stream) bool {
@@ -925,7 +1625,10 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 	default:
 		sorters := []func(a, b *Stream) bool{}
 		for _, s := range sorting {
-			af := sorterFunctions[s.Key]
+			af, err := sorterFunction(s.Key)
+			if err != nil {
+				return nil, false, nil, err
+			}
 			df := func(a, b *Stream) bool {
 				return af(b, a)
 			}
@@ -952,8 +1655,24 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 		}
 	}
 
+	// the sample consists of the streams with the smallest sample keys, it
+	// is collected like a sorted result and sorted afterwards
+	sampleLess := (func(a, b *Stream) bool)(nil)
+	if options.Sampling != nil {
+		seed := rand.Uint64()
+		if options.Sampling.Seed != nil {
+			seed = *options.Sampling.Seed
+		}
+		sampleLess = func(a, b *Stream) bool {
+			ka, kb := sampleKey(seed, a.StreamID), sampleKey(seed, b.StreamID)
+			return ka < kb || (ka == kb && a.StreamID < b.StreamID)
+		}
+	}
+
 	groupingData := (*grouper)(nil)
-	if grouping != nil {
+	groupLimit := uint(1)
+	if options.Grouping != nil {
+		groupLimit = max(options.Grouping.Limit, 1)
 		groupingKeyMap := map[string]func(s *Stream) []byte{
 			"id": func(s *Stream) []byte {
 				b := [8]byte{}
@@ -985,6 +1704,36 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 				binary.LittleEndian.PutUint64(b[:], s.ServerBytes)
 				return b[:]
 			},
+			"packets": func(s *Stream) []byte {
+				b := [8]byte{}
+				binary.LittleEndian.PutUint64(b[:], uint64(s.ClientPackets)+uint64(s.ServerPackets))
+				return b[:]
+			},
+			"cpackets": func(s *Stream) []byte {
+				b := [4]byte{}
+				binary.LittleEndian.PutUint32(b[:], s.ClientPackets)
+				return b[:]
+			},
+			"spackets": func(s *Stream) []byte {
+				b := [4]byte{}
+				binary.LittleEndian.PutUint32(b[:], s.ServerPackets)
+				return b[:]
+			},
+			"chunks": func(s *Stream) []byte {
+				b := [8]byte{}
+				binary.LittleEndian.PutUint64(b[:], uint64(s.ClientChunks)+uint64(s.ServerChunks))
+				return b[:]
+			},
+			"cchunks": func(s *Stream) []byte {
+				b := [4]byte{}
+				binary.LittleEndian.PutUint32(b[:], s.ClientChunks)
+				return b[:]
+			},
+			"schunks": func(s *Stream) []byte {
+				b := [4]byte{}
+				binary.LittleEndian.PutUint32(b[:], s.ServerChunks)
+				return b[:]
+			},
 
 			"ftime": func(s *Stream) []byte {
 				b := [16]byte{}
@@ -1000,6 +1749,11 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 				binary.LittleEndian.PutUint64(b[8:], uint64(t.UnixNano()))
 				return b[:]
 			},
+			"tick": func(s *Stream) []byte {
+				b := [8]byte{}
+				binary.LittleEndian.PutUint64(b[:], uint64(options.Ticks.Tick(s.FirstPacket())))
+				return b[:]
+			},
 			"duration": func(s *Stream) []byte {
 				b := [8]byte{}
 				ft := s.r.ReferenceTime.Add(time.Nanosecond * time.Duration(s.FirstPacketTimeNS))
@@ -1008,6 +1762,27 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 				return b[:]
 			},
 
+			"chash": func(s *Stream) []byte {
+				b := [8]byte{}
+				binary.LittleEndian.PutUint64(b[:], s.ClientHash)
+				return b[:]
+			},
+			"shash": func(s *Stream) []byte {
+				b := [8]byte{}
+				binary.LittleEndian.PutUint64(b[:], s.ServerHash)
+				return b[:]
+			},
+			"cprefixhash": func(s *Stream) []byte {
+				b := [8]byte{}
+				binary.LittleEndian.PutUint64(b[:], s.ClientPrefixHash)
+				return b[:]
+			},
+			"sprefixhash": func(s *Stream) []byte {
+				b := [8]byte{}
+				binary.LittleEndian.PutUint64(b[:], s.ServerPrefixHash)
+				return b[:]
+			},
+
 			"chost": func(s *Stream) []byte {
 				hg := s.r.hostGroups[s.HostGroup]
 				return append([]byte{byte(hg.hostSize)}, hg.get(s.ClientHost)...)
@@ -1016,13 +1791,25 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 				hg := s.r.hostGroups[s.HostGroup]
 				return append([]byte{byte(hg.hostSize)}, hg.get(s.ServerHost)...)
 			},
+
+			"chostset": func(s *Stream) []byte {
+				names := options.HostSets.Names(s.r.hostGroups[s.HostGroup].get(s.ClientHost))
+				return append([]byte(strings.Join(names, ",")), 0)
+			},
+			"shostset": func(s *Stream) []byte {
+				names := options.HostSets.Names(s.r.hostGroups[s.HostGroup].get(s.ServerHost))
+				return append([]byte(strings.Join(names, ",")), 0)
+			},
 		}
 		keyFuncs := []func(s *Stream) []byte(nil)
 		variables := []string(nil)
-		for _, v := range grouping.Variables {
+		for _, v := range options.Grouping.Variables {
 			if v.SubQuery != "" {
 				return nil, false, nil, errors.New("SubQueries not yet fully supported")
 			}
+			if v.Name == "tick" && options.Ticks == nil {
+				return nil, false, nil, errors.New("grouping by tick requires a tick schedule")
+			}
 			g, ok := groupingKeyMap[v.Name]
 			if ok {
 				keyFuncs = append(keyFuncs, g)
@@ -1055,35 +1842,68 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 		}
 	}
 
+	if explanation != nil {
+		explanation.Prepare = time.Since(prepareStart)
+	}
+
 	allResults := map[string]resultData{}
+	emitted := false
 	for _, subQuery := range qs.SubQueries() {
 		results := resultData{
 			matchingQueryPart: make([]bitmask.ConnectedBitmask, len(qs)),
 		}
+		sqExplanation := (*SubQueryExplanation)(nil)
+		if explanation != nil {
+			explanation.SubQueries = append(explanation.SubQueries, SubQueryExplanation{
+				SubQuery: subQuery,
+				Indexes:  make([]IndexExplanation, 0, len(indexes)),
+			})
+			sqExplanation = &explanation.SubQueries[len(explanation.SubQueries)-1]
+		}
 		sorter := sortingLess
-		resultLimit := limit + skip
+		resultLimit := options.Limit + options.Skip
 		limitIDs := limitIDs
+		if sampleLess != nil {
+			sorter = sampleLess
+			resultLimit = options.Sampling.Size
+		}
 		if subQuery != "" {
 			sorter = nil
 			resultLimit = 0
 			limitIDs = nil
 		}
+		visit := (func(*Stream))(nil)
+		if subQuery == "" && groupingData == nil && sampleLess == nil {
+			visit = hooks.visit
+		}
+		emit := (func([]*Stream) error)(nil)
+		if subQuery == "" && groupingData == nil && sampleLess == nil {
+			emit = hooks.emit
+		}
+		progress := (func(SearchProgress))(nil)
+		if subQuery == "" {
+			progress = hooks.progress
+		}
 
+		tasks := make([]searchTask, 0, len(indexes))
 		for idxIdx := len(indexes) - 1; idxIdx >= 0; idxIdx-- {
 			idx := indexes[idxIdx]
 
 			sortingLookup := (func() ([]uint32, error))(nil)
 			if resultLimit != 0 {
-				if section, ok := sorterLookupSections[sorting[0].Key]; sorter != nil && ok {
+				if section, ok := sorterLookupSections[sorting[0].Key]; sorter != nil && sampleLess == nil && ok {
 					res := []uint32(nil)
 					reverse := sorting[0].Dir == query.SortingDirDescending
 					sortingLookup = func() ([]uint32, error) {
 						if res == nil {
-							res = make([]uint32, idx.StreamCount())
-							if err := idx.readObjects(section, res); err != nil {
+							var err error
+							res, err = readSection[uint32](idx, section)
+							if err != nil {
 								return nil, err
 							}
 							if reverse {
+								// the lookup might be mapped, so reverse a copy
+								res = slices.Clone(res)
 								for i, j := 0, len(res)-1; i < j; {
 									res[i], res[j] = res[j], res[i]
 									i++
@@ -1096,298 +1916,576 @@ func SearchStreams(ctx context.Context, indexes []*Reader, limitIDs *bitmask.Lon
 				}
 			}
 			//get all filters and lookups for each sub-query
-			queryParts := make([]queryPart, 0, len(qs))
-			for qID := range qs {
-				//build search structures
-				queryPart, err := idx.buildSearchObjects(subQuery, qID, allResults, refTime, &qs[qID], indexes[idxIdx+1:], limitIDs, tagDetails, converters)
-				if err != nil {
-					return nil, false, nil, err
+			buildQueryParts := func() ([]queryPart, error) {
+				queryParts := make([]queryPart, 0, len(qs))
+				for qID := range qs {
+					//build search structures
+					queryPart, err := idx.buildSearchObjects(subQuery, qID, allResults, options.ReferenceTime, &qs[qID], indexes[idxIdx+1:], limitIDs, options.TagDetails, options.Converters, similarityReferences)
+					if err != nil {
+						return nil, err
+					}
+					queryParts = append(queryParts, queryPart)
 				}
-				queryParts = append(queryParts, queryPart)
+				return queryParts, nil
+			}
+			buildStart := time.Now()
+			queryParts, err := buildQueryParts()
+			if err != nil {
+				return nil, false, nil, err
 			}
-			err := idx.searchStreams(ctx, &results, allResults, queryParts, groupingData, sorter, resultLimit, sortingLookup)
+			candidatesStart := time.Now()
+			candidates, err := idx.searchCandidates(queryParts, resultLimit, sortingLookup)
 			if err != nil {
 				return nil, false, nil, err
 			}
+			if sqExplanation != nil {
+				sqExplanation.Build += candidatesStart.Sub(buildStart)
+				sqExplanation.Candidates += time.Since(candidatesStart)
+				sqExplanation.Indexes = append(sqExplanation.Indexes, explainIndex(idx, qs, queryParts, candidates))
+			}
+			tasks = append(tasks, searchTask{
+				r:               idx,
+				queryParts:      queryParts,
+				buildQueryParts: buildQueryParts,
+				candidates:      candidates,
+			})
+		}
+		final := (func(*Stream, int) bool)(nil)
+		if emit != nil && sorting[0].Key == query.SortingKeyFirstPacketTime {
+			// bounds contains the best first packet time of the streams of
+			// the remaining tasks, streams that are strictly better are final
+			descending := sorting[0].Dir == query.SortingDirDescending
+			bounds := make([]time.Time, len(tasks))
+			for i := len(tasks) - 1; i >= 0; i-- {
+				r := tasks[i].r
+				bound := r.ReferenceTime.Add(time.Duration(r.firstPacketTimeNS.min))
+				if descending {
+					bound = r.ReferenceTime.Add(time.Duration(r.firstPacketTimeNS.max))
+				}
+				if i+1 < len(tasks) && (bounds[i+1].After(bound) == descending) {
+					bound = bounds[i+1]
+				}
+				bounds[i] = bound
+			}
+			final = func(s *Stream, tasksFinished int) bool {
+				if descending {
+					return s.FirstPacket().After(bounds[tasksFinished])
+				}
+				return s.FirstPacket().Before(bounds[tasksFinished])
+			}
+		}
+		collector := searchCollector{
+			result:      &results,
+			grouper:     groupingData,
+			sortingLess: sorter,
+			limit:       resultLimit,
+			groupLimit:  groupLimit,
+			visit:       visit,
+			emit:        emit,
+			skip:        options.Skip,
+			final:       final,
+			progress:    progress,
+			tasks:       len(tasks),
+		}
+		if subQuery == "" {
+			collector.details = hooks.details != nil && len(allResults) != 0
+			collector.alternatives = hooks.details != nil && hooks.groupAlternatives && groupingData != nil
+		}
+		if sqExplanation != nil {
+			for i := range tasks {
+				tasks[i].explanation = &sqExplanation.Indexes[i]
+			}
+		}
+		evaluateStart := time.Now()
+		if err := searchTasks(ctx, tasks, allResults, &collector); err != nil {
+			return nil, false, nil, err
 		}
-		if len(results.streams) == 0 {
+		if collector.emitErr != nil {
+			return nil, false, nil, collector.emitErr
+		}
+		if sqExplanation != nil {
+			sqExplanation.Evaluate = time.Since(evaluateStart)
+			sqExplanation.Results = len(results.streams)
+		}
+		if len(results.streams) == 0 && collector.emitted == 0 {
 			return nil, false, nil, nil
 		}
 		allResults[subQuery] = results
+		emitted = emit != nil
 	}
 	results := allResults[""]
-	if uint(len(results.streams)) <= skip {
+	hasMore := results.resultDropped != 0
+	if emitted {
+		// the streams were already passed to emit, skipping the first ones
+		var dataRegexes *DataRegexes
+		if options.ExtractRegexes {
+			dataRegexes = extractDataRegexes(qs, options.TagDetails)
+		}
+		return nil, hasMore, dataRegexes, nil
+	}
+	if sampleLess != nil {
+		slices.SortStableFunc(results.streams, func(a, b *Stream) int {
+			if sortingLess(a, b) {
+				return -1
+			}
+			if sortingLess(b, a) {
+				return 1
+			}
+			return 0
+		})
+		hasMore = options.Limit != 0 && uint(len(results.streams)) > options.Limit+options.Skip
+		if hasMore {
+			results.streams = results.streams[:options.Limit+options.Skip]
+		}
+	}
+	if uint(len(results.streams)) <= options.Skip {
 		return nil, false, nil, nil
 	}
+	streams := results.streams[options.Skip:]
+	if hooks.details != nil {
+		delete(allResults, "")
+		hooks.details.collect(streams, &results, allResults)
+	}
 	var dataRegexes *DataRegexes
-	if extractRegexes {
-		dataRegexes = extractDataRegexes(qs, tagDetails)
+	if options.ExtractRegexes {
+		dataRegexes = extractDataRegexes(qs, options.TagDetails)
 	}
-	return results.streams[skip:], results.resultDropped != 0, dataRegexes, nil
+	return streams, hasMore, dataRegexes, nil
 }
 
-func (r *Reader) searchStreams(ctx context.Context, result *resultData, subQueryResults map[string]resultData, queryParts []queryPart, grouper *grouper, sortingLess func(a, b *Stream) bool, limit uint, sortingLookup func() ([]uint32, error)) error {
-	// apply filters to lookup results or all streams, if no lookups could be used
-	filterAndAddToResult := func(activeQueryParts bitmask.ShortBitmask, si uint32) (bool, error) {
-		if err := ctx.Err(); err != nil {
-			return false, err
-		}
-
-		// check if the sorting and limit would allow any stream
-		limitReached := result.resultDropped != 0 && limit != 0 && uint(len(result.streams)) >= limit
-		if limitReached && sortingLess == nil {
-			return true, nil
+// collect fills the details of the returned streams of the result.
+func (d *ResultDetails) collect(streams []*Stream, results *resultData, subQueryResults map[string]resultData) {
+	for _, s := range streams {
+		if key, ok := results.streamGroups[s.StreamID]; ok {
+			if alternatives, ok := results.groupAlternatives[key]; ok {
+				if d.GroupAlternatives == nil {
+					d.GroupAlternatives = make(map[uint64][]*Stream)
+				}
+				d.GroupAlternatives[s.StreamID] = alternatives
+			}
 		}
-
-		s, err := r.streamByIndex(si)
-		if err != nil {
-			return false, err
+		subQueryStreams := map[string][]*Stream(nil)
+		for _, sc := range results.searchContexts[s.StreamID] {
+			for sq, matched := range sc.allowedSubQueries.streams(subQueryResults) {
+				if subQueryStreams == nil {
+					subQueryStreams = map[string][]*Stream{}
+				}
+				for _, sqs := range matched {
+					if len(subQueryStreams[sq]) < maxResultDetails && !slices.Contains(subQueryStreams[sq], sqs) {
+						subQueryStreams[sq] = append(subQueryStreams[sq], sqs)
+					}
+				}
+			}
 		}
-		ss, err := s.wrap(r, si)
-		if err != nil {
-			return false, err
+		if subQueryStreams != nil {
+			if d.SubQueryStreams == nil {
+				d.SubQueryStreams = make(map[uint64]map[string][]*Stream)
+			}
+			d.SubQueryStreams[s.StreamID] = subQueryStreams
 		}
+	}
+}
 
-		// check if the sorting and limit would allow this stream
-		if limitReached && !sortingLess(ss, result.streams[limit-1]) {
-			return true, nil
+// sampleKey returns a pseudo random key for the stream, it only depends on
+// the seed and the stream id, so samples with the same seed are stable.
+func sampleKey(seed, streamID uint64) uint64 {
+	// splitmix64
+	z := seed + streamID*0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// accepts checks if the sorting, limit and grouping allow adding the stream
+// to the result, limitReached is set if the limit prevents adding it.
+func (c *searchCollector) accepts(ss *Stream) (accepted bool, limitReached bool) {
+	result := c.result
+
+	// check if the sorting and limit would allow this stream
+	if result.resultDropped != 0 && c.limit != 0 && c.collected() >= c.limit {
+		if c.sortingLess == nil || !c.sortingLess(ss, result.streams[c.limit-1]) {
+			return false, true
 		}
+	}
 
-		// check if the sorting within the groupKey allow this stream
-		groupKey := []byte(nil)
-		groupPos := -1
-		if grouper != nil && len(grouper.vars) == 0 {
-			groupKey = grouper.key(ss)
-			pos, ok := result.groups[string(groupKey)]
-			if ok {
-				groupPos = pos
-				if sortingLess == nil || !sortingLess(ss, result.streams[pos]) {
-					return false, nil
-				}
+	// check if the sorting within the groupKey allow this stream, when
+	// collecting alternatives, add will check it after the evaluation
+	if c.grouper != nil && len(c.grouper.vars) == 0 && !c.alternatives {
+		if members := result.groups[string(c.grouper.key(ss))]; uint(len(members)) >= c.groupLimit {
+			if c.sortingLess == nil || !c.sortingLess(ss, members[len(members)-1]) {
+				return false, false
 			}
 		}
+	}
+	return true, false
+}
 
-		matchingQueryParts := bitmask.ShortBitmask{}
-		matchingSearchContexts := []*searchContext(nil)
+// full returns true if no stream can be added to the result anymore.
+func (c *searchCollector) full() bool {
+	return c.emitErr != nil || (c.sortingLess == nil && c.result.resultDropped != 0 && c.limit != 0 && c.collected() >= c.limit)
+}
 
-	queryPart:
-		for qpIdx, qpLen := 0, activeQueryParts.Len(); qpIdx < qpLen; qpIdx++ {
-			if !activeQueryParts.IsSet(uint(qpIdx)) {
-				continue
-			}
-			tmp := map[string]bitmask.ConnectedBitmask{}
-			for k, v := range subQueryResults {
-				if v.matchingQueryPart[qpIdx].IsZero() {
-					continue queryPart
-				}
-				tmp[k] = v.matchingQueryPart[qpIdx].Copy()
-			}
-			sc := &searchContext{
-				allowedSubQueries: subQuerySelection{
-					remaining: []map[string]bitmask.ConnectedBitmask{tmp},
-				},
-			}
-			for _, f := range queryParts[qpIdx].filters {
-				matching, err := f(sc, s)
-				if err != nil {
-					return false, err
-				}
-				if !matching {
-					continue queryPart
-				}
-			}
-			matchingQueryParts.Set(uint(qpIdx))
-			matchingSearchContexts = append(matchingSearchContexts, sc)
-		}
-		if matchingQueryParts.IsZero() {
-			return false, nil
+// collected returns the number of streams added to the result.
+func (c *searchCollector) collected() uint {
+	return uint(len(c.result.streams))
+}
+
+// finishTasks emits the streams that became final and reports the progress
+// once the candidates of the first n tasks were considered.
+func (c *searchCollector) finishTasks(n int) {
+	if n <= c.tasksFinished {
+		return
+	}
+	c.tasksFinished = n
+	c.emitFinal()
+	if c.progress == nil {
+		return
+	}
+	c.progress(SearchProgress{
+		IndexesSearched: n,
+		Indexes:         c.tasks,
+		Results:         c.collected(),
+	})
+}
+
+// emitFinal passes the streams of the result whose position can't change
+// anymore to emit, skipping the first ones.
+func (c *searchCollector) emitFinal() {
+	if c.emit == nil || c.emitErr != nil {
+		return
+	}
+	streams := c.result.streams
+	end := c.emitted
+	for ; end < uint(len(streams)); end++ {
+		if c.tasksFinished < c.tasks && (c.final == nil || !c.final(streams[end], c.tasksFinished)) {
+			break
 		}
+	}
+	start := max(c.emitted, c.skip)
+	c.emitted = end
+	if start < end {
+		c.emitErr = c.emit(streams[start:end])
+	}
+}
 
-		if grouper != nil && len(grouper.vars) != 0 {
-			groupKey = grouper.key(ss)
-			for _, vn := range grouper.vars {
-				vvsm := map[string]struct{}{}
-				vvsl := []string(nil)
-				for _, sc := range matchingSearchContexts {
-					for _, vv := range sc.outputVariables[vn] {
-						if _, ok := vvsm[vv]; ok {
-							continue
-						}
-						vvsm[vv] = struct{}{}
-						vvsl = append(vvsl, vv)
+// add adds an evaluated stream to the result, it returns true if the limit
+// prevented adding it. The stream has to be checked using accepts before.
+func (c *searchCollector) add(e *searchEvaluation) bool {
+	if c.visit != nil {
+		c.visit(e.stream)
+		return false
+	}
+	result, grouper, limit := c.result, c.grouper, c.limit
+	ss := e.stream
+	matchingQueryParts, matchingSearchContexts := e.matchingQueryParts, e.matchingSearchContexts
+
+	groupKey := []byte(nil)
+	groupPos := -1
+	if grouper != nil {
+		groupKey = grouper.key(ss)
+		for _, vn := range grouper.vars {
+			vvsm := map[string]struct{}{}
+			vvsl := []string(nil)
+			for _, sc := range matchingSearchContexts {
+				for _, vv := range sc.outputVariables[vn] {
+					if _, ok := vvsm[vv]; ok {
+						continue
 					}
+					vvsm[vv] = struct{}{}
+					vvsl = append(vvsl, vv)
 				}
-				sort.Strings(vvsl)
+			}
+			sort.Strings(vvsl)
+			groupKey = append(groupKey, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(groupKey[len(groupKey)-8:], uint64(len(vvsl)))
+			for _, vv := range vvsl {
 				groupKey = append(groupKey, make([]byte, 8)...)
-				binary.LittleEndian.PutUint64(groupKey[len(groupKey)-8:], uint64(len(vvsl)))
-				for _, vv := range vvsl {
-					groupKey = append(groupKey, make([]byte, 8)...)
-					binary.LittleEndian.PutUint64(groupKey[len(groupKey)-8:], uint64(len(vv)))
-					groupKey = append(groupKey, []byte(vv)...)
-				}
+				binary.LittleEndian.PutUint64(groupKey[len(groupKey)-8:], uint64(len(vv)))
+				groupKey = append(groupKey, []byte(vv)...)
 			}
-			pos, ok := result.groups[string(groupKey)]
-			if ok {
-				groupPos = pos
-				if sortingLess == nil || !sortingLess(ss, result.streams[pos]) {
+		}
+		if members := result.groups[string(groupKey)]; uint(len(members)) >= c.groupLimit {
+			// without variables and alternatives, accepts already checked the sorting within the group
+			worst := members[len(members)-1]
+			if (len(grouper.vars) != 0 || c.alternatives) && (c.sortingLess == nil || !c.sortingLess(ss, worst)) {
+				if len(grouper.vars) != 0 {
 					result.resultDropped++
-					return false, nil
 				}
+				c.addAlternative(string(groupKey), ss)
+				return false
 			}
+			groupPos = c.positions[worst]
 		}
+	}
 
-		replacePos := groupPos
-		if groupPos == -1 {
-			if limit == 0 || uint(len(result.streams)) < limit {
-				// we have no limit or the limit is not yet reached
-				replacePos = len(result.streams)
-				result.streams = append(result.streams, nil)
-			} else if sortingLess != nil && sortingLess(ss, result.streams[limit-1]) {
-				// we have a limit but we are better than the last
-				replacePos = len(result.streams) - 1
-			} else {
-				// we have a limit and are worse than the last
-				result.resultDropped++
-				return true, nil
-			}
+	replacePos := groupPos
+	if groupPos == -1 {
+		if limit == 0 || uint(len(result.streams)) < limit {
+			// we have no limit or the limit is not yet reached
+			replacePos = len(result.streams)
+			result.streams = append(result.streams, nil)
+		} else if c.sortingLess != nil && c.sortingLess(ss, result.streams[limit-1]) {
+			// we have a limit but we are better than the last
+			replacePos = len(result.streams) - 1
+		} else {
+			// we have a limit and are worse than the last
+			result.resultDropped++
+			return true
 		}
+	}
 
-		if r := &result.streams[replacePos]; *r != nil {
-			if groupPos != -1 {
-				// we should replace the group slot
-				delete(result.groups, string(groupKey))
-			} else if grouper != nil {
-				// we should replace the last slot
-				delete(result.groups, string(grouper.key(*r)))
+	if r := &result.streams[replacePos]; *r != nil {
+		if grouper != nil {
+			// remove the replaced stream from its group
+			delete(c.positions, *r)
+			key := result.streamGroups[(*r).StreamID]
+			delete(result.streamGroups, (*r).StreamID)
+			members := slices.DeleteFunc(result.groups[key], func(s *Stream) bool {
+				return s == *r
+			})
+			if len(members) == 0 {
+				delete(result.groups, key)
+			} else {
+				result.groups[key] = members
 			}
-			if d, ok := result.variableAssociation[(*r).StreamID]; ok {
-				result.variableData[d].uses--
-				delete(result.variableAssociation, (*r).StreamID)
+			if groupPos != -1 {
+				// the stream was replaced by a better one of its group
+				c.addAlternative(key, *r)
+			} else if len(members) == 0 {
+				// the group was dropped because of the limit
+				delete(result.groupAlternatives, key)
 			}
-			for i := range result.matchingQueryPart {
-				result.matchingQueryPart[i].Extract(uint(replacePos))
+		}
+		if d, ok := result.variableAssociation[(*r).StreamID]; ok {
+			result.variableData[d].uses--
+			delete(result.variableAssociation, (*r).StreamID)
+		}
+		delete(result.searchContexts, (*r).StreamID)
+		for i := range result.matchingQueryPart {
+			result.matchingQueryPart[i].Extract(uint(replacePos))
+		}
+		*r = nil
+		if groupPos == -1 {
+			result.resultDropped++
+		}
+	}
+	// replacePos now points to the position of a nil slot that we can use
+
+	// insert the result at the right place
+	insertPos := replacePos
+	if c.sortingLess != nil {
+		insertPos = sort.Search(len(result.streams)-1, func(i int) bool {
+			if i >= replacePos {
+				i++
+			}
+			return c.sortingLess(ss, result.streams[i])
+		})
+		if replacePos < insertPos {
+			insertPos++
+			for ; replacePos < insertPos; replacePos++ {
+				result.streams[replacePos] = result.streams[replacePos+1]
+				c.trackPosition(replacePos)
 			}
-			*r = nil
-			if groupPos == -1 {
-				result.resultDropped++
+		} else if replacePos > insertPos {
+			for ; replacePos > insertPos; replacePos-- {
+				result.streams[replacePos] = result.streams[replacePos-1]
+				c.trackPosition(replacePos)
 			}
 		}
-		// replacePos now points to the position of a nil slot that we can use
+	}
+	result.streams[insertPos] = ss
+	c.trackPosition(insertPos)
+	if c.details {
+		// the sub query streams are only looked up for the final result
+		if result.searchContexts == nil {
+			result.searchContexts = make(map[uint64][]*searchContext)
+		}
+		result.searchContexts[ss.StreamID] = matchingSearchContexts
+	}
 
-		// insert the result at the right place
-		insertPos := replacePos
-		if sortingLess != nil {
-			insertPos = sort.Search(len(result.streams)-1, func(i int) bool {
-				if i >= replacePos {
-					i++
-				}
-				return sortingLess(ss, result.streams[i])
+	if grouper != nil {
+		if result.groups == nil {
+			result.groups = make(map[string][]*Stream)
+			result.streamGroups = make(map[uint64]string)
+		}
+		members := result.groups[string(groupKey)]
+		memberPos := len(members)
+		if c.sortingLess != nil {
+			memberPos = sort.Search(len(members), func(i int) bool {
+				return c.sortingLess(ss, members[i])
 			})
-			if replacePos < insertPos {
-				insertPos++
-				for ; replacePos < insertPos; replacePos++ {
-					result.streams[replacePos] = result.streams[replacePos+1]
-				}
-			} else if replacePos > insertPos {
-				for ; replacePos > insertPos; replacePos-- {
-					result.streams[replacePos] = result.streams[replacePos-1]
-				}
-			}
 		}
-		result.streams[insertPos] = ss
+		result.groups[string(groupKey)] = slices.Insert(members, memberPos, ss)
+		result.streamGroups[ss.StreamID] = string(groupKey)
+	}
 
-		if grouper != nil {
-			if result.groups == nil {
-				result.groups = make(map[string]int)
-			}
-			result.groups[string(groupKey)] = insertPos
+	vdv := []variableDataValue(nil)
+	for scIdx, qpIdx, qpLen := -1, 0, matchingQueryParts.Len(); qpIdx < qpLen; qpIdx++ {
+		matching := matchingQueryParts.IsSet(uint(qpIdx))
+		result.matchingQueryPart[qpIdx].Inject(uint(insertPos), matching)
+		if !matching {
+			continue
 		}
-
-		vdv := []variableDataValue(nil)
-		for scIdx, qpIdx, qpLen := -1, 0, matchingQueryParts.Len(); qpIdx < qpLen; qpIdx++ {
-			matching := matchingQueryParts.IsSet(uint(qpIdx))
-			result.matchingQueryPart[qpIdx].Inject(uint(insertPos), matching)
-			if !matching {
-				continue
-			}
-			scIdx++
-			sc := matchingSearchContexts[scIdx]
-			if sc.outputVariables == nil {
-				continue
-			}
-			qp := bitmask.ShortBitmask{}
-			qp.Set(uint(qpIdx))
-			for k, vs := range sc.outputVariables {
-			values:
-				for _, v := range vs {
-					for i := range vdv {
-						vdvp := &vdv[i]
-						if k != vdvp.name {
-							continue
-						}
-						if v != vdvp.value {
-							continue
-						}
-						vdvp.queryParts.Set(uint(qpIdx))
-						continue values
+		scIdx++
+		sc := matchingSearchContexts[scIdx]
+		if sc.outputVariables == nil {
+			continue
+		}
+		qp := bitmask.ShortBitmask{}
+		qp.Set(uint(qpIdx))
+		for k, vs := range sc.outputVariables {
+		values:
+			for _, v := range vs {
+				for i := range vdv {
+					vdvp := &vdv[i]
+					if k != vdvp.name {
+						continue
 					}
-					vdv = append(vdv, variableDataValue{
-						name:       k,
-						value:      v,
-						queryParts: qp,
-					})
+					if v != vdvp.value {
+						continue
+					}
+					vdvp.queryParts.Set(uint(qpIdx))
+					continue values
 				}
+				vdv = append(vdv, variableDataValue{
+					name:       k,
+					value:      v,
+					queryParts: qp,
+				})
 			}
 		}
-		if len(vdv) == 0 {
-			return false, nil
+	}
+	if len(vdv) == 0 {
+		return false
+	}
+	sort.Slice(vdv, func(i, j int) bool {
+		a, b := &vdv[i], &vdv[j]
+		if a.name != b.name {
+			return a.name < b.name
 		}
-		sort.Slice(vdv, func(i, j int) bool {
-			a, b := &vdv[i], &vdv[j]
-			if a.name != b.name {
-				return a.name < b.name
-			}
-			return a.value < b.value
-		})
-		if result.variableAssociation == nil {
-			result.variableAssociation = make(map[uint64]int)
+		return a.value < b.value
+	})
+	if result.variableAssociation == nil {
+		result.variableAssociation = make(map[uint64]int)
+	}
+	freeSlot := len(result.variableData)
+varData:
+	for i := range result.variableData {
+		d := &result.variableData[i]
+		if d.uses == 0 {
+			freeSlot = i
 		}
-		freeSlot := len(result.variableData)
-	varData:
-		for i := range result.variableData {
-			d := &result.variableData[i]
-			if d.uses == 0 {
-				freeSlot = i
+		if len(d.data) != len(vdv) {
+			continue
+		}
+		for j := range vdv {
+			if vdv[j].name != d.data[j].name {
+				continue varData
 			}
-			if len(d.data) != len(vdv) {
-				continue
+			if vdv[j].value != d.data[j].value {
+				continue varData
 			}
-			for j := range vdv {
-				if vdv[j].name != d.data[j].name {
-					continue varData
-				}
-				if vdv[j].value != d.data[j].value {
-					continue varData
-				}
-				if !vdv[j].queryParts.Equal(d.data[j].queryParts) {
-					continue varData
-				}
+			if !vdv[j].queryParts.Equal(d.data[j].queryParts) {
+				continue varData
 			}
-			d.uses++
-			result.variableAssociation[s.StreamID] = i
-			return false, nil
 		}
-		if freeSlot == len(result.variableData) {
-			result.variableData = append(result.variableData, variableDataCollection{})
+		d.uses++
+		result.variableAssociation[ss.StreamID] = i
+		return false
+	}
+	if freeSlot == len(result.variableData) {
+		result.variableData = append(result.variableData, variableDataCollection{})
+	}
+	result.variableData[freeSlot] = variableDataCollection{
+		uses: 1,
+		data: vdv,
+	}
+	result.variableAssociation[ss.StreamID] = freeSlot
+	return false
+}
+
+// trackPosition records the index of the stream at position i of the result.
+func (c *searchCollector) trackPosition(i int) {
+	if c.grouper == nil {
+		return
+	}
+	if c.positions == nil {
+		c.positions = make(map[*Stream]int)
+	}
+	c.positions[c.result.streams[i]] = i
+}
+
+// addAlternative adds a stream of a group that is not part of the result to
+// the alternatives of the group, if they are collected.
+func (c *searchCollector) addAlternative(groupKey string, ss *Stream) {
+	if !c.alternatives {
+		return
+	}
+	result := c.result
+	alternatives := result.groupAlternatives[groupKey]
+	pos := len(alternatives)
+	if c.sortingLess != nil {
+		pos = sort.Search(len(alternatives), func(i int) bool {
+			return c.sortingLess(ss, alternatives[i])
+		})
+	}
+	if pos >= maxResultDetails {
+		return
+	}
+	alternatives = slices.Insert(alternatives, pos, ss)
+	if len(alternatives) > maxResultDetails {
+		alternatives = alternatives[:maxResultDetails]
+	}
+	if result.groupAlternatives == nil {
+		result.groupAlternatives = make(map[string][]*Stream)
+	}
+	result.groupAlternatives[groupKey] = alternatives
+}
+
+// evaluateStream applies the filters of the active query parts to the stream,
+// it returns nil if the stream doesn't match any query part.
+func evaluateStream(subQueryResults map[string]resultData, queryParts []queryPart, activeQueryParts bitmask.ShortBitmask, ss *Stream) (*searchEvaluation, error) {
+	e := &searchEvaluation{
+		stream: ss,
+	}
+queryPart:
+	for qpIdx, qpLen := 0, activeQueryParts.Len(); qpIdx < qpLen; qpIdx++ {
+		if !activeQueryParts.IsSet(uint(qpIdx)) {
+			continue
+		}
+		tmp := map[string]bitmask.ConnectedBitmask{}
+		for k, v := range subQueryResults {
+			if v.matchingQueryPart[qpIdx].IsZero() {
+				continue queryPart
+			}
+			tmp[k] = v.matchingQueryPart[qpIdx].Copy()
 		}
-		result.variableData[freeSlot] = variableDataCollection{
-			uses: 1,
-			data: vdv,
+		sc := &searchContext{
+			allowedSubQueries: subQuerySelection{
+				remaining: []map[string]bitmask.ConnectedBitmask{tmp},
+			},
+		}
+		for _, f := range queryParts[qpIdx].filters {
+			matching, err := f(sc, &ss.stream)
+			if err != nil {
+				return nil, err
+			}
+			if !matching {
+				continue queryPart
+			}
 		}
-		result.variableAssociation[s.StreamID] = freeSlot
-		return false, nil
+		e.matchingQueryParts.Set(uint(qpIdx))
+		e.matchingSearchContexts = append(e.matchingSearchContexts, sc)
+	}
+	if e.matchingQueryParts.IsZero() {
+		return nil, nil
 	}
+	return e, nil
+}
 
+// searchCandidates collects the streams that have to be evaluated for the
+// query parts, in the order they have to be evaluated in.
+func (r *Reader) searchCandidates(queryParts []queryPart, limit uint, sortingLookup func() ([]uint32, error)) (searchCandidates, error) {
 	// check if all queries use lookups, if not don't use lookups
 	activeQueryParts := bitmask.ShortBitmask{}
 	lookupMissing := false
@@ -1401,7 +2499,7 @@ func (r *Reader) searchStreams(ctx context.Context, result *resultData, subQuery
 		}
 	}
 	if activeQueryParts.OnesCount() == 0 {
-		return nil
+		return searchCandidates{}, nil
 	}
 
 	// if we don't have a limit, we should not use the sorting lookup as no early exit is possible
@@ -1414,34 +2512,33 @@ func (r *Reader) searchStreams(ctx context.Context, result *resultData, subQuery
 
 		// without sorting lookup, we will evaluate in file order without early exit
 		if sortingLookup == nil {
-			for si, sc := 0, r.StreamCount(); si < sc; si++ {
-				if _, err := filterAndAddToResult(activeQueryParts, uint32(si)); err != nil {
-					return err
-				}
-			}
-			return nil
+			return searchCandidates{
+				activeQueryParts: activeQueryParts,
+				count:            r.StreamCount(),
+			}, nil
 		}
 
 		// with sorting lookup, we might be able to exit early if we reach the limit
 		sortedStreamIndexes, err := sortingLookup()
 		if err != nil {
-			return err
+			return searchCandidates{}, err
+		}
+		candidates := searchCandidates{
+			streams:     make([]searchCandidate, 0, len(sortedStreamIndexes)),
+			count:       len(sortedStreamIndexes),
+			stopAtLimit: true,
 		}
 		for _, si := range sortedStreamIndexes {
-			if limitReached, err := filterAndAddToResult(activeQueryParts, si); err != nil {
-				return err
-			} else if limitReached {
-				break
-			}
+			candidates.streams = append(candidates.streams, searchCandidate{
+				si:               si,
+				activeQueryParts: activeQueryParts,
+			})
 		}
+		return candidates, nil
 	}
 
 	// all query parts have lookups, build a map of stream indexes to active query parts
-	type streamIndex struct {
-		si               uint32
-		activeQueryParts bitmask.ShortBitmask
-	}
-	streamIndexes := []streamIndex(nil)
+	streamIndexes := []searchCandidate(nil)
 
 	// build a list of stream indexes that match any query part
 	streamIndexesPosition := map[uint32]int{}
@@ -1450,19 +2547,26 @@ func (r *Reader) searchStreams(ctx context.Context, result *resultData, subQuery
 			continue
 		}
 		streamIndexesOfQuery := []uint32(nil)
+		ownStreamIndexes := false
 		for _, l := range qp.lookups {
 			newStreamIndexes, err := l()
 			if err != nil {
-				return err
+				return searchCandidates{}, err
 			}
 			if len(newStreamIndexes) == 0 {
 				streamIndexesOfQuery = nil
 				break
 			}
 			if len(streamIndexesOfQuery) == 0 {
+				// the lookup might point into the mapping, it is
+				// cloned before being filtered in place
 				streamIndexesOfQuery = newStreamIndexes
 				continue
 			}
+			if !ownStreamIndexes {
+				streamIndexesOfQuery = slices.Clone(streamIndexesOfQuery)
+				ownStreamIndexes = true
+			}
 			newStreamIndexesMap := make(map[uint32]struct{}, len(newStreamIndexes))
 			for _, si := range newStreamIndexes {
 				newStreamIndexesMap[si] = struct{}{}
@@ -1489,7 +2593,7 @@ func (r *Reader) searchStreams(ctx context.Context, result *resultData, subQuery
 				sis.activeQueryParts.Set(uint(qpIdx))
 			} else {
 				streamIndexesPosition[si] = len(streamIndexes)
-				streamIndexes = append(streamIndexes, streamIndex{
+				streamIndexes = append(streamIndexes, searchCandidate{
 					si:               si,
 					activeQueryParts: bitmask.ShortBitmask{},
 				})
@@ -1505,33 +2609,30 @@ func (r *Reader) searchStreams(ctx context.Context, result *resultData, subQuery
 		sort.Slice(streamIndexes, func(i, j int) bool {
 			return streamIndexes[i].si < streamIndexes[j].si
 		})
-		for _, si := range streamIndexes {
-			_, err := filterAndAddToResult(si.activeQueryParts, si.si)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+		return searchCandidates{
+			streams: streamIndexes,
+			count:   len(streamIndexes),
+		}, nil
 	}
 
 	sortedStreamIndexes, err := sortingLookup()
 	if err != nil {
-		return err
+		return searchCandidates{}, err
 	}
 
 	// evaluate the steams using the sort order lookup and test
 	// each index against the information from the lookups
+	candidates := searchCandidates{
+		streams:     make([]searchCandidate, 0, len(streamIndexes)),
+		stopAtLimit: true,
+	}
 	for _, si := range sortedStreamIndexes {
 		pos, ok := streamIndexesPosition[si]
 		if !ok {
 			continue
 		}
-		aqp := streamIndexes[pos].activeQueryParts
-		if limitReached, err := filterAndAddToResult(aqp, si); err != nil {
-			return err
-		} else if limitReached {
-			break
-		}
+		candidates.streams = append(candidates.streams, streamIndexes[pos])
 	}
-	return nil
+	candidates.count = len(candidates.streams)
+	return candidates, nil
 }
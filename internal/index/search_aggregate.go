@@ -0,0 +1,104 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/spq/pkappa2/internal/query"
+)
+
+type (
+	// SearchAggregation summarizes the streams matching a search.
+	SearchAggregation struct {
+		Streams                  uint
+		ClientBytes, ServerBytes uint64
+		// FirstPacket and LastPacket span the packets of all matching
+		// streams, they are zero if no stream matched
+		FirstPacket, LastPacket time.Time
+		// Buckets split the matching streams by the time of their first
+		// packet, they are only set if a bucket size was given
+		Buckets []AggregationBucket
+
+		bucketSize  time.Duration
+		bucketIndex map[int64]int
+	}
+	AggregationBucket struct {
+		Start                    time.Time
+		Streams                  uint
+		ClientBytes, ServerBytes uint64
+	}
+)
+
+// AggregateStreams runs a search like SearchStreams, but instead of the
+// matching streams it returns their number, byte sums and time range. The
+// streams are counted while evaluating the search, so they are not kept in
+// memory unless grouping or sampling requires collecting them first.
+func AggregateStreams(ctx context.Context, indexes []*Reader, qs query.ConditionsSet, options SearchOptions, bucketSize time.Duration) (*SearchAggregation, error) {
+	if bucketSize < 0 {
+		return nil, errors.New("bucket size must not be negative")
+	}
+	a := &SearchAggregation{
+		bucketSize: bucketSize,
+	}
+	if bucketSize != 0 {
+		a.Buckets = []AggregationBucket{}
+		a.bucketIndex = map[int64]int{}
+	}
+	if err := VisitStreams(ctx, indexes, qs, options, a.add); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(a.Buckets, func(x, y AggregationBucket) int {
+		return x.Start.Compare(y.Start)
+	})
+	return a, nil
+}
+
+// VisitStreams runs a search like SearchStreams, but passes the matching
+// streams to visit instead of returning them. The streams are passed while
+// evaluating the search in no particular order, unless grouping or sampling
+// requires collecting them first. Limit and Skip are ignored.
+func VisitStreams(ctx context.Context, indexes []*Reader, qs query.ConditionsSet, options SearchOptions, visit func(*Stream)) error {
+	options.Limit, options.Skip = 0, 0
+	res, _, _, err := searchStreams(ctx, indexes, qs, options, searchHooks{
+		visit: visit,
+	})
+	if err != nil {
+		return err
+	}
+	// with grouping or sampling, the streams are returned instead of being visited during the search
+	for _, s := range res {
+		visit(s)
+	}
+	return nil
+}
+
+func (a *SearchAggregation) add(s *Stream) {
+	a.Streams++
+	a.ClientBytes += s.ClientBytes
+	a.ServerBytes += s.ServerBytes
+	fp, lp := s.FirstPacket(), s.LastPacket()
+	if a.FirstPacket.IsZero() || fp.Before(a.FirstPacket) {
+		a.FirstPacket = fp
+	}
+	if a.LastPacket.IsZero() || lp.After(a.LastPacket) {
+		a.LastPacket = lp
+	}
+	if a.bucketSize == 0 {
+		return
+	}
+	start := fp.Truncate(a.bucketSize)
+	i, ok := a.bucketIndex[start.UnixNano()]
+	if !ok {
+		i = len(a.Buckets)
+		a.bucketIndex[start.UnixNano()] = i
+		a.Buckets = append(a.Buckets, AggregationBucket{
+			Start: start,
+		})
+	}
+	b := &a.Buckets[i]
+	b.Streams++
+	b.ClientBytes += s.ClientBytes
+	b.ServerBytes += s.ServerBytes
+}
@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"math"
 	"slices"
 	"sort"
+	"time"
 
 	"github.com/spq/pkappa2/internal/query"
 	"github.com/spq/pkappa2/internal/tools/bitmask"
@@ -68,6 +70,12 @@ type (
 		variant map[string]int
 		// flags for this progress
 		flags progressVariantFlag
+		// the time of the chunk containing the end of the last match
+		lastMatchTime time.Time
+		// the state before the last match with the offset moved behind its
+		// start, used for retrying with a later match if the following
+		// match was received too late
+		retry *progressVariant
 	}
 	variantResult struct {
 		variant   map[string]int
@@ -79,6 +87,13 @@ type (
 		successes, fails int
 		variantResults   []variantResult
 	}
+	// dataTimes contains the end offsets and times of the data chunks of
+	// both directions, it is used for evaluating timing constraints.
+	dataTimes [2][]dataTime
+	dataTime  struct {
+		end int
+		ts  time.Time
+	}
 )
 
 const (
@@ -173,6 +188,27 @@ regexElements:
 	return nil
 }
 
+// prefilters describes the literal prefixes, suffixes and accepted lengths
+// used to skip data before running the finalized regexes.
+func (dcc *dataConditionsContainer) prefilters() []string {
+	res := []string(nil)
+	for _, r := range dcc.regexes {
+		if r.root.regex == nil {
+			continue
+		}
+		length := fmt.Sprintf("%d-%d", r.root.acceptedLength.MinLength, r.root.acceptedLength.MaxLength)
+		if r.root.acceptedLength.MaxLength == math.MaxUint {
+			length = fmt.Sprintf(">=%d", r.root.acceptedLength.MinLength)
+		}
+		desc := fmt.Sprintf("%q: prefix %q, suffix %q, length %s", r.root.regex.String(), r.root.prefix, r.root.suffix, length)
+		if r.root.isPrecondition {
+			desc += ", precondition"
+		}
+		res = append(res, desc)
+	}
+	return res
+}
+
 func (dcc *dataConditionsContainer) finalize(r *Reader, queryPartIndex int, previousResults map[string]resultData, converters map[string]ConverterAccess) ([]func(sc *searchContext, s *stream) (bool, error), error) {
 	if len(dcc.conditions) == 0 {
 		return alwaysSuccess, nil
@@ -183,6 +219,15 @@ func (dcc *dataConditionsContainer) finalize(r *Reader, queryPartIndex int, prev
 			return nil, fmt.Errorf("converter %q not found", converterName)
 		}
 	}
+	hasTiming := false
+	for _, c := range dcc.conditions {
+		for _, e := range c.Elements {
+			hasTiming = hasTiming || e.Timing != nil
+		}
+	}
+	if hasTiming && converterName != "" && converterName != "none" {
+		return nil, errors.New("timing constraints are not supported for converted data")
+	}
 	//sort the regexes
 	for rIdx := range dcc.regexes {
 		r := &dcc.regexes[rIdx]
@@ -489,7 +534,14 @@ func (dcc *dataConditionsContainer) finalize(r *Reader, queryPartIndex int, prev
 			return bufferLengths, buffers, nil
 		})
 	}
-	if converterName != "none" {
+	// converters don't keep the times of the data, so timing
+	// constraints are only evaluated on the captured data
+	times := (func(s *stream) (*dataTimes, error))(nil)
+	if hasTiming {
+		times = func(s *stream) (*dataTimes, error) {
+			return r.dataTimes(s)
+		}
+	} else if converterName != "none" {
 		for c := range converters {
 			if converterName != "" && converterName != c {
 				continue
@@ -509,7 +561,50 @@ func (dcc *dataConditionsContainer) finalize(r *Reader, queryPartIndex int, prev
 		}
 	}
 
-	return append(filters, makeDataConditionFilter(dataSources, possibleSubQueries, dcc.conditions, dcc.regexes)), nil
+	return append(filters, makeDataConditionFilter(dataSources, times, possibleSubQueries, dcc.conditions, dcc.regexes)), nil
+}
+
+func (r *Reader) dataTimes(s *stream) (*dataTimes, error) {
+	chunks, err := r.chunkTimes(s)
+	if err != nil {
+		return nil, err
+	}
+	dt := &dataTimes{}
+	for dir := range chunks {
+		end := 0
+		for _, c := range chunks[dir] {
+			end += int(c.sz)
+			dt[dir] = append(dt[dir], dataTime{
+				end: end,
+				ts:  c.ts,
+			})
+		}
+	}
+	return dt, nil
+}
+
+// at returns the time of the chunk containing the byte at the offset.
+func (dt *dataTimes) at(dir uint8, offset int) time.Time {
+	chunks := dt[dir]
+	if len(chunks) == 0 {
+		return time.Time{}
+	}
+	i := sort.Search(len(chunks), func(i int) bool {
+		return chunks[i].end > offset
+	})
+	return chunks[min(i, len(chunks)-1)].ts
+}
+
+// offset returns the offset of the first chunk not received before t.
+func (dt *dataTimes) offset(dir uint8, t time.Time) int {
+	chunks := dt[dir]
+	i := sort.Search(len(chunks), func(i int) bool {
+		return !chunks[i].ts.Before(t)
+	})
+	if i == 0 {
+		return 0
+	}
+	return chunks[i-1].end
 }
 
 func (p *progressVariant) find(buffers [2][]byte, dir uint8) []int {
@@ -573,6 +668,88 @@ func (p *progressVariant) find(buffers [2][]byte, dir uint8) []int {
 	return res
 }
 
+// chunkBounds returns the offsets of the start and the end of the chunk with
+// the given index within the data of the direction.
+func chunkBounds(bufferLengths [][2]int, dir uint8, chunk int) (int, int, bool) {
+	for i := 1; i < len(bufferLengths); i++ {
+		start, end := bufferLengths[i-1][dir], bufferLengths[i][dir]
+		if start == end {
+			continue
+		}
+		if chunk == 0 {
+			return start, end, true
+		}
+		chunk--
+	}
+	return 0, 0, false
+}
+
+// findAnchored works like find, but only returns matches within the chunk
+// and starting at the offset required by the anchor.
+func (p *progressVariant) findAnchored(buffers [2][]byte, bufferLengths [][2]int, dir uint8, anchor *query.DataConditionAnchor) []int {
+	if anchor == nil {
+		return p.find(buffers, dir)
+	}
+	start, end := 0, len(buffers[dir])
+	if anchor.Chunk != -1 {
+		ok := false
+		if start, end, ok = chunkBounds(bufferLengths, dir, anchor.Chunk); !ok {
+			p.streamOffset[dir] = len(buffers[dir])
+			return nil
+		}
+	}
+	if anchor.Offset != -1 {
+		start += anchor.Offset
+		if start > end || p.streamOffset[dir] > start {
+			p.streamOffset[dir] = len(buffers[dir])
+			return nil
+		}
+	}
+	if p.streamOffset[dir] > end {
+		// the chunk was passed by a previous match
+		p.streamOffset[dir] = len(buffers[dir])
+		return nil
+	}
+	p.streamOffset[dir] = max(p.streamOffset[dir], start)
+	window := buffers
+	window[dir] = buffers[dir][:end]
+	res := p.find(window, dir)
+	if res == nil || anchor.Offset != -1 && p.streamOffset[dir]+res[0] != start {
+		// the anchored chunk or offset can't match anymore
+		p.streamOffset[dir] = len(buffers[dir])
+		return nil
+	}
+	return res
+}
+
+// findTimed works like findAnchored, but only returns matches starting within
+// the allowed time after the last match. If the match was received too late,
+// the progress is reset to retry the last match and retried is set.
+func (p *progressVariant) findTimed(buffers [2][]byte, bufferLengths [][2]int, dir uint8, times *dataTimes, e *query.DataConditionElement) (res []int, retried bool) {
+	timing := e.Timing
+	if timing.Min != 0 {
+		// skip the chunks received too early
+		p.streamOffset[dir] = max(p.streamOffset[dir], times.offset(dir, p.lastMatchTime.Add(timing.Min)))
+	}
+	res = p.findAnchored(buffers, bufferLengths, dir, e.Anchor)
+	if res == nil {
+		return nil, false
+	}
+	if times.at(dir, p.streamOffset[dir]+res[0]).Sub(p.lastMatchTime) <= timing.Max {
+		return res, false
+	}
+	if p.retry == nil {
+		// later matches are received even later
+		p.streamOffset[dir] = len(buffers[dir])
+		return nil, false
+	}
+	retry := *p.retry
+	retry.variables = maps.Clone(retry.variables)
+	retry.variant = maps.Clone(retry.variant)
+	*p = retry
+	return nil, true
+}
+
 func (ps *progressGroup) prepare(r *regex, pIdx int, e *query.DataConditionElement, possibleSubQueries map[string]subQueryVariableData) (*progressVariant, error) {
 	p := &ps.variants[pIdx]
 	if p.regex != nil {
@@ -618,6 +795,7 @@ func (ps *progressGroup) prepare(r *regex, pIdx int, e *query.DataConditionEleme
 				acceptedLength: c.acceptedLength,
 				prefix:         c.prefix,
 				suffix:         c.suffix,
+				lastMatchTime:  p.lastMatchTime,
 				variant: map[string]int{
 					root.childSubQuery: cIdx,
 				},
@@ -673,10 +851,11 @@ func (ps *progressGroup) prepare(r *regex, pIdx int, e *query.DataConditionEleme
 					// the precondition regex matched, split this progress element
 					for j := 1; j < len(psq.variableData); j++ {
 						np := progressVariant{
-							streamOffset: p.streamOffset,
-							nSuccessful:  p.nSuccessful,
-							flags:        progressVariantFlagStateUninitialzed,
-							variant:      map[string]int{v.SubQuery: j},
+							streamOffset:  p.streamOffset,
+							nSuccessful:   p.nSuccessful,
+							flags:         progressVariantFlagStateUninitialzed,
+							lastMatchTime: p.lastMatchTime,
+							variant:       map[string]int{v.SubQuery: j},
 						}
 						for k, v := range p.variant {
 							np.variant[k] = v
@@ -729,7 +908,7 @@ func (ps *progressGroup) prepare(r *regex, pIdx int, e *query.DataConditionEleme
 	return p, nil
 }
 
-func makeDataConditionFilter(dataSources []func(s *stream) ([][2]int, [2][]byte, error), possibleSubQueries map[string]subQueryVariableData, conditions []*query.DataCondition, regexes []regex) func(sc *searchContext, s *stream) (bool, error) {
+func makeDataConditionFilter(dataSources []func(s *stream) ([][2]int, [2][]byte, error), times func(s *stream) (*dataTimes, error), possibleSubQueries map[string]subQueryVariableData, conditions []*query.DataCondition, regexes []regex) func(sc *searchContext, s *stream) (bool, error) {
 	progressGroups := make([]progressGroup, len(conditions))
 	//add filter for scanning the data section
 	return func(sc *searchContext, s *stream) (bool, error) {
@@ -739,6 +918,13 @@ func makeDataConditionFilter(dataSources []func(s *stream) ([][2]int, [2][]byte,
 			ps.fails = 0
 			ps.successes = 0
 		}
+		dt := (*dataTimes)(nil)
+		if times != nil {
+			var err error
+			if dt, err = times(s); err != nil {
+				return false, err
+			}
+		}
 		evaluatedDataSources := 0
 		for _, dataSource := range dataSources {
 			bufferLengths, buffers, err := dataSource(s)
@@ -772,7 +958,15 @@ func makeDataConditionFilter(dataSources []func(s *stream) ([][2]int, [2][]byte,
 								return false, err
 							}
 
-							res := p.find(buffers, dir)
+							res := []int(nil)
+							if e.Timing != nil && p.nSuccessful != 0 {
+								retried := false
+								if res, retried = p.findTimed(buffers, bufferLengths, dir, dt, e); retried {
+									recheckRegexes = true
+								}
+							} else {
+								res = p.findAnchored(buffers, bufferLengths, dir, e.Anchor)
+							}
 							if res == nil {
 								continue
 							}
@@ -784,8 +978,22 @@ func makeDataConditionFilter(dataSources []func(s *stream) ([][2]int, [2][]byte,
 								continue
 							}
 							p.flags = 0
-							p.nSuccessful++
 							d := conditions[o.condition]
+							// when the next element has to be received within some time, remember how to
+							// retry with a later match, inverted conditions succeed on the first failure
+							if next := p.nSuccessful + 1; next < len(d.Elements) && d.Elements[next].Timing != nil && d.Elements[next].Timing.Max != math.MaxInt64 && !(d.Inverted && next == len(d.Elements)-1) && p.streamOffset[dir]+res[0] < len(buffers[dir]) {
+								retry := *p
+								retry.streamOffset[dir] += res[0] + 1
+								retry.variables = maps.Clone(p.variables)
+								retry.variant = maps.Clone(p.variant)
+								p.retry = &retry
+							} else {
+								p.retry = nil
+							}
+							p.nSuccessful++
+							if dt != nil {
+								p.lastMatchTime = dt.at(dir, p.streamOffset[dir]+max(res[1]-1, res[0]))
+							}
 							if p.nSuccessful != len(d.Elements) {
 								// remember that we advanced a sequence that has a follow up and we have to re-check the regexes
 								recheckRegexes = true
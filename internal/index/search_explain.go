@@ -0,0 +1,99 @@
+package index
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/spq/pkappa2/internal/query"
+)
+
+type (
+	// SearchExplanation describes how a search was executed.
+	SearchExplanation struct {
+		// Conditions is the normalized query after inlining tags, host sets and ticks
+		Conditions string
+		// SubQueries are in evaluation order, the main query is the last one
+		SubQueries []SubQueryExplanation
+		Results    int
+		// Prepare is the time spent normalizing the query and setting up sorting and grouping
+		Prepare time.Duration
+		Total   time.Duration
+	}
+	SubQueryExplanation struct {
+		SubQuery string
+		// Indexes are in evaluation order, newest index first
+		Indexes []IndexExplanation
+		Results int
+		// time spent building the filters and lookups, collecting the candidates and evaluating them
+		Build, Candidates, Evaluate time.Duration
+	}
+	IndexExplanation struct {
+		Index   string
+		Streams int
+		// QueryParts contains one entry per element of the normalized conditions set
+		QueryParts []QueryPartExplanation
+		// FullScan is set if at least one query part has no lookup, so all streams are candidates
+		FullScan bool
+		// SortedCandidates is set if the candidates are evaluated in the order of
+		// the sorting, the evaluation stops as soon as the limit is reached
+		SortedCandidates bool
+		// EstimatedCandidates is the number of candidates determined using the lookups
+		EstimatedCandidates int
+		// ConsideredCandidates were checked against sorting, limit and grouping
+		ConsideredCandidates int
+		// EvaluatedCandidates were checked against the filters
+		EvaluatedCandidates int
+		MatchingCandidates  int
+	}
+	QueryPartExplanation struct {
+		Conditions string
+		// Possible is false if the query part can't match any stream of the index
+		Possible        bool
+		Lookups         []string
+		Filters         []string
+		RegexPrefilters []string
+	}
+)
+
+// ExplainSearchStreams runs a search like SearchStreams, but instead of the
+// results it returns a description of the chosen lookups and filters, the
+// number of candidates and the time spent in the different stages.
+func ExplainSearchStreams(ctx context.Context, indexes []*Reader, qs query.ConditionsSet, options SearchOptions) (*SearchExplanation, error) {
+	start := time.Now()
+	e := &SearchExplanation{
+		SubQueries: []SubQueryExplanation{},
+	}
+	res, _, _, err := searchStreams(ctx, indexes, qs, options, searchHooks{
+		explanation: e,
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.Results = len(res)
+	e.Total = time.Since(start)
+	return e, nil
+}
+
+func explainIndex(r *Reader, qs query.ConditionsSet, queryParts []queryPart, candidates searchCandidates) IndexExplanation {
+	e := IndexExplanation{
+		Index:               filepath.Base(r.Filename()),
+		Streams:             r.StreamCount(),
+		QueryParts:          make([]QueryPartExplanation, 0, len(queryParts)),
+		SortedCandidates:    candidates.stopAtLimit,
+		EstimatedCandidates: candidates.count,
+	}
+	for qpIdx, qp := range queryParts {
+		if qp.possible && len(qp.lookups) == 0 {
+			e.FullScan = true
+		}
+		e.QueryParts = append(e.QueryParts, QueryPartExplanation{
+			Conditions:      qs[qpIdx].String(),
+			Possible:        qp.possible,
+			Lookups:         append([]string{}, qp.lookupNames...),
+			Filters:         append([]string{}, qp.filterNames...),
+			RegexPrefilters: append([]string{}, qp.regexPrefilters...),
+		})
+	}
+	return e
+}
@@ -0,0 +1,252 @@
+package index
+
+import (
+	"context"
+	"flag"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	searchWorkers = flag.Int("search_workers", 0, "number of goroutines evaluating streams during a search, 0 uses GOMAXPROCS")
+
+	// searchChunkSize is the number of candidates a search worker evaluates at once
+	searchChunkSize = 1024
+)
+
+type (
+	searchTask struct {
+		r               *Reader
+		queryParts      []queryPart
+		buildQueryParts func() ([]queryPart, error)
+		candidates      searchCandidates
+		// explanation collects the candidate counts if the search is explained
+		explanation *IndexExplanation
+	}
+	searchRecord struct {
+		stream     *Stream
+		evaluation *searchEvaluation
+		readErr    error
+		evalErr    error
+	}
+	searchChunk struct {
+		task       int
+		begin, end int
+		records    []searchRecord
+		err        error
+		done       chan struct{}
+	}
+)
+
+func (r *Reader) candidateStream(si uint32) (*Stream, error) {
+	s, err := r.streamByIndex(si)
+	if err != nil {
+		return nil, err
+	}
+	return s.wrap(r, si)
+}
+
+// consider adds the stream to the result if the collector accepts it,
+// evaluate is only called if the stream could make it into the result.
+// It returns true if no further candidates of the task have to be considered.
+func (c *searchCollector) consider(t *searchTask, ss *Stream, evaluate func() (*searchEvaluation, error)) (bool, error) {
+	if t.explanation != nil {
+		t.explanation.ConsideredCandidates++
+	}
+	accepted, limitReached := c.accepts(ss)
+	if limitReached && t.candidates.stopAtLimit {
+		return true, nil
+	}
+	if !accepted {
+		return false, nil
+	}
+	e, err := evaluate()
+	if err == nil && t.explanation != nil {
+		t.explanation.EvaluatedCandidates++
+		if e != nil {
+			t.explanation.MatchingCandidates++
+		}
+	}
+	if err != nil || e == nil {
+		return false, err
+	}
+	return c.add(e) && t.candidates.stopAtLimit, nil
+}
+
+// searchTasks evaluates the candidates of all tasks and adds the matching
+// streams to the collector. The result is the same as when evaluating the
+// tasks one after another, but the candidates might be evaluated concurrently.
+func searchTasks(ctx context.Context, tasks []searchTask, subQueryResults map[string]resultData, collector *searchCollector) error {
+	workers := *searchWorkers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	total := 0
+	for _, t := range tasks {
+		total += t.candidates.count
+	}
+	if workers == 1 || total <= searchChunkSize {
+		return searchTasksSequential(ctx, tasks, subQueryResults, collector)
+	}
+	return searchTasksParallel(ctx, tasks, subQueryResults, collector, workers)
+}
+
+func searchTasksSequential(ctx context.Context, tasks []searchTask, subQueryResults map[string]resultData, collector *searchCollector) error {
+	for tIdx, t := range tasks {
+		for i := 0; i < t.candidates.count; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if collector.full() {
+				break
+			}
+			c := t.candidates.get(i)
+			ss, err := t.r.candidateStream(c.si)
+			if err != nil {
+				return err
+			}
+			stop, err := collector.consider(&t, ss, func() (*searchEvaluation, error) {
+				return evaluateStream(subQueryResults, t.queryParts, c.activeQueryParts, ss)
+			})
+			if err != nil {
+				return err
+			}
+			if stop {
+				break
+			}
+		}
+		collector.finishTasks(tIdx + 1)
+	}
+	return nil
+}
+
+// searchTasksParallel splits the candidates into chunks that are evaluated by
+// the workers, while the results are added to the collector in the original order.
+func searchTasksParallel(ctx context.Context, tasks []searchTask, subQueryResults map[string]resultData, collector *searchCollector, workers int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	wg := sync.WaitGroup{}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	chunks := []*searchChunk(nil)
+	for tIdx := range tasks {
+		for begin, count := 0, tasks[tIdx].candidates.count; begin < count; begin += searchChunkSize {
+			chunks = append(chunks, &searchChunk{
+				task:  tIdx,
+				begin: begin,
+				end:   min(begin+searchChunkSize, count),
+				done:  make(chan struct{}),
+			})
+		}
+	}
+	// stopped is set for tasks where no further candidates have to be considered
+	stopped := make([]atomic.Bool, len(tasks))
+
+	// limit the number of chunks that are evaluated ahead of the collector
+	window := make(chan struct{}, 2*workers)
+	work := make(chan *searchChunk)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(work)
+		for _, c := range chunks {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case work <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the filters keep state, so every worker needs its own query parts
+			queryParts := make([][]queryPart, len(tasks))
+			for c := range work {
+				c.evaluate(ctx, &tasks[c.task], &queryParts[c.task], subQueryResults, &stopped[c.task])
+				close(c.done)
+			}
+		}()
+	}
+
+	for _, c := range chunks {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-window
+		collector.finishTasks(c.task)
+		if stopped[c.task].Load() {
+			continue
+		}
+		if c.err != nil {
+			return c.err
+		}
+		t := &tasks[c.task]
+		for i := range c.records {
+			rec := &c.records[i]
+			if collector.full() {
+				stopped[c.task].Store(true)
+				break
+			}
+			if rec.readErr != nil {
+				return rec.readErr
+			}
+			stop, err := collector.consider(t, rec.stream, func() (*searchEvaluation, error) {
+				return rec.evaluation, rec.evalErr
+			})
+			if err != nil {
+				return err
+			}
+			if stop {
+				stopped[c.task].Store(true)
+				break
+			}
+		}
+	}
+	collector.finishTasks(len(tasks))
+	return nil
+}
+
+// evaluate reads and evaluates the candidates of the chunk, it stops early
+// if the task was stopped, the records are not used in that case.
+func (c *searchChunk) evaluate(ctx context.Context, t *searchTask, queryParts *[]queryPart, subQueryResults map[string]resultData, stopped *atomic.Bool) {
+	if stopped.Load() {
+		return
+	}
+	if *queryParts == nil {
+		qp, err := t.buildQueryParts()
+		if err != nil {
+			c.err = err
+			return
+		}
+		*queryParts = qp
+	}
+	c.records = make([]searchRecord, c.end-c.begin)
+	for i := range c.records {
+		if err := ctx.Err(); err != nil {
+			c.err = err
+			return
+		}
+		if stopped.Load() {
+			return
+		}
+		rec := &c.records[i]
+		cand := t.candidates.get(c.begin + i)
+		rec.stream, rec.readErr = t.r.candidateStream(cand.si)
+		if rec.readErr != nil {
+			continue
+		}
+		rec.evaluation, rec.evalErr = evaluateStream(subQueryResults, *queryParts, cand.activeQueryParts, rec.stream)
+	}
+}
@@ -2,9 +2,12 @@ package index
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"net/netip"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"github.com/spq/pkappa2/internal/index/streams"
 	"github.com/spq/pkappa2/internal/query"
 	"github.com/spq/pkappa2/internal/tools"
+	"github.com/spq/pkappa2/internal/tools/bitmask"
 	pcapmetadata "github.com/spq/pkappa2/internal/tools/pcapMetadata"
 )
 
@@ -166,6 +170,10 @@ func (c *fakeConverter) DataForSearch(streamID uint64) ([2][]byte, [][2]int, uin
 }
 
 func TestSearchStreams(t *testing.T) {
+	allBytes := ""
+	for b := 0; b < 256; b++ {
+		allBytes += string([]byte{byte(b)})
+	}
 	tmpDir := t.TempDir()
 	testCases := []struct {
 		name     string
@@ -328,6 +336,79 @@ func TestSearchStreams(t *testing.T) {
 			"cdata:needle1 then sdata:needle2",
 			[]uint64{0},
 		},
+		{
+			"entropy query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"hello hello hello"}),
+				makeStream("192.168.0.100:234", "192.168.0.1:80", t1.Add(time.Hour*2), []string{allBytes}),
+			},
+			"centropy:7.5:",
+			[]uint64{1},
+		},
+		{
+			"exact entropy query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"hello hello hello"}),
+				makeStream("192.168.0.100:234", "192.168.0.1:80", t1.Add(time.Hour*2), []string{allBytes}),
+			},
+			"centropy:8",
+			[]uint64{1},
+		},
+		{
+			"printable query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"hello hello hello"}),
+				makeStream("192.168.0.100:234", "192.168.0.1:80", t1.Add(time.Hour*2), []string{allBytes}),
+			},
+			"cprintable:.9: sprintable:0",
+			[]uint64{0},
+		},
+		{
+			"entropy query using a subquery variable",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"hello hello hello"}),
+				makeStream("192.168.0.100:234", "192.168.0.1:80", t1.Add(time.Hour*2), []string{allBytes}),
+				makeStream("192.168.0.100:345", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"hello"}),
+			},
+			"@sub:id:0 centropy:@sub:centropy@+0.5:",
+			[]uint64{1},
+		},
+		{
+			"hex data query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"foo\xde\xad\xbe\xefbar"}),
+				makeStream("192.168.0.100:234", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"foo\xde\xad\xbe\xeebar"}),
+			},
+			"cdata.hex:\"de ad be ef 62\"",
+			[]uint64{0},
+		},
+		{
+			"literal data query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a.b*c"}),
+				makeStream("192.168.0.100:234", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"axbbc"}),
+			},
+			"cdata.lit:a.b*c",
+			[]uint64{0},
+		},
+		{
+			"case insensitive data query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"HeLLo.World"}),
+				makeStream("192.168.0.100:234", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"hello world"}),
+			},
+			"cdata.i:hello.world",
+			[]uint64{0},
+		},
+		{
+			"literal data query using a specific converter",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"ne*dle"}, []string{"needle"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"foo"}, nil, []string{"ne*dle"}),
+			},
+			"cdata.lit.c1:ne*dle",
+			[]uint64{1},
+		},
 		{
 			"test protocol:tcp query",
 			[]streamInfo{
@@ -518,6 +599,332 @@ func TestSearchStreams(t *testing.T) {
 			"id:123: limit:2",
 			nil,
 		},
+		{
+			"sport range lookup",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:22", t1.Add(time.Hour*1), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:443", t1.Add(time.Hour*3), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:8080", t1.Add(time.Hour*4), []string{"foo"}),
+			},
+			"sport:80:443 sort:id",
+			[]uint64{1, 2},
+		},
+		{
+			"impossible sport range",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"foo"}),
+			},
+			"sport:443:80",
+			nil,
+		},
+		{
+			"host lookups",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"foo"}),
+				makeStream("192.168.0.101:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"foo"}),
+				makeStream("192.168.0.102:123", "192.168.0.2:80", t1.Add(time.Hour*3), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.2:80", t1.Add(time.Hour*4), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:22", t1.Add(time.Hour*5), []string{"foo"}),
+			},
+			"chost:192.168.0.100 shost:192.168.0.1 sport:80",
+			[]uint64{0},
+		},
+		{
+			"client hash of sub query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"hello", "world"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"hello", "other"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"bye", "world"}),
+			},
+			"@a:id:0 chash:@a:chash@ sort:id",
+			[]uint64{0, 1},
+		},
+		{
+			"server hash",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"hello", "world"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"hello", "other"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"bye", "world"}),
+			},
+			fmt.Sprintf("shash:%x sort:id", fnvHash("world")),
+			[]uint64{0, 2},
+		},
+		{
+			"negated server hash",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"hello", "world"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"hello", "other"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"bye", "world"}),
+			},
+			fmt.Sprintf("-shash:%x sort:id", fnvHash("world")),
+			[]uint64{1},
+		},
+		{
+			"client prefix hash",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{strings.Repeat("A", 128) + "foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{strings.Repeat("A", 128) + "bar"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{strings.Repeat("A", 127) + "B"}),
+			},
+			"@a:id:0 cprefixhash:@a:cprefixhash@ -chash:@a:chash@ sort:id",
+			[]uint64{1},
+		},
+		{
+			"client and server hash of the same stream",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"echo", "echo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"ping", "pong"}),
+			},
+			"chash:@shash@",
+			[]uint64{0},
+		},
+		{
+			"duration filter",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"duration:4s: sort:id",
+			[]uint64{1, 2},
+		},
+		{
+			"duration sorting",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"sort:-duration",
+			[]uint64{2, 1, 0},
+		},
+		{
+			"duration of sub query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"@a:id:1 duration:@a:duration@+1s: sort:id",
+			[]uint64{2},
+		},
+		{
+			"duration and time variables",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"@a:id:1 ltime:@a:ftime@+@a:duration@ sort:id",
+			[]uint64{1},
+		},
+		{
+			"client packets filter",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"cpackets:3 sort:id",
+			[]uint64{0, 1},
+		},
+		{
+			"packets filter",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"packets:2",
+			[]uint64{2},
+		},
+		{
+			"server packets sorting",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"spackets:1: sort:-spackets",
+			[]uint64{2, 1},
+		},
+		{
+			"packets of sub query",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"@a:id:2 cpackets:@a:cpackets@-1 sort:id",
+			[]uint64{0, 1},
+		},
+		{
+			"chunks filter",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"chunks:2",
+			[]uint64{2},
+		},
+		{
+			"server chunks filter",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a", "b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"a", "b", "c", "d"}),
+			},
+			"schunks:1: sort:-chunks",
+			[]uint64{2, 1},
+		},
+		{
+			"similar client data",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /flag?id=1234567890 HTTP/1.1\r\nHost: example.com\r\n\r\n", "OK"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"GET /flag?id=1234567891 HTTP/1.1\r\nHost: example.com\r\n\r\n", "error"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"POST /login HTTP/1.0\r\nContent-Length: 0\r\n\r\n", "OK"}),
+			},
+			"csimilar:0:0.5 sort:id",
+			[]uint64{0, 1},
+		},
+		{
+			"similar server data",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /flag?id=1234567890 HTTP/1.1\r\nHost: example.com\r\n\r\n", "OK"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"GET /flag?id=1234567891 HTTP/1.1\r\nHost: example.com\r\n\r\n", "error"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"POST /login HTTP/1.0\r\nContent-Length: 0\r\n\r\n", "OK"}),
+			},
+			"ssimilar:0:1 sort:id",
+			[]uint64{0, 2},
+		},
+		{
+			"not similar data of both directions",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /flag?id=1234567890 HTTP/1.1\r\nHost: example.com\r\n\r\n", "OK"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"GET /flag?id=1234567891 HTTP/1.1\r\nHost: example.com\r\n\r\n", "error"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"POST /login HTTP/1.0\r\nContent-Length: 0\r\n\r\n", "OK"}),
+			},
+			"-similar:1:0.3 sort:id",
+			[]uint64{2},
+		},
+		{
+			"sorting lookup with query part without lookup",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:443", t1.Add(time.Hour*1), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"bar"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:22", t1.Add(time.Hour*3), []string{"foo"}),
+			},
+			"sport:80 or cdata:foo sort:sport",
+			[]uint64{2, 1, 0},
+		},
+		{
+			"data anchored to the start of the first chunk",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /a", "200", "GET /flag", "200"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"POST /x GET", "500"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"xGET", "200"}),
+			},
+			"cdata.chunk0.at0:GET sort:id",
+			[]uint64{0},
+		},
+		{
+			"data anchored to a later chunk",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /a", "200", "GET /flag", "200"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"POST /x GET", "500"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"xGET", "200"}),
+			},
+			"cdata.chunk1:flag sort:id",
+			[]uint64{0},
+		},
+		{
+			"data anchored to an offset",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /a", "200", "GET /flag", "200"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"POST /x GET", "500"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"xGET", "200"}),
+			},
+			"cdata.at1:GET sort:id",
+			[]uint64{2},
+		},
+		{
+			"data anchored to an offset within a chunk",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /a", "200", "GET /flag", "200"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"POST /x GET", "500"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"xGET", "200"}),
+			},
+			"cdata.chunk1.at5.lit:flag sort:id",
+			[]uint64{0},
+		},
+		{
+			"data anchored to a chunk followed by another direction",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /a", "200", "GET /flag", "200"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"POST /x GET", "500"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"xGET", "200"}),
+			},
+			"cdata:GET then sdata.chunk0:200 sort:id",
+			[]uint64{0, 2},
+		},
+		{
+			"data anchored to a chunk before the previous match",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"GET /a", "200", "GET /flag", "200"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"POST /x GET", "500"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"xGET", "200"}),
+			},
+			"cdata.chunk1:GET then sdata.chunk0:200 sort:id",
+			[]uint64{},
+		},
+		{
+			"top streams per group",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"foo"}),
+				makeStream("192.168.0.101:123", "192.168.0.1:80", t1.Add(time.Hour*4), []string{"foo"}),
+				makeStream("192.168.0.101:123", "192.168.0.1:80", t1.Add(time.Hour*5), []string{"foo"}),
+			},
+			"group:\"@chost@\" sort:ftime limit:,2",
+			[]uint64{0, 1, 3, 4},
+		},
+		{
+			"top streams per group with total limit",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"foo"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"foo"}),
+				makeStream("192.168.0.101:123", "192.168.0.1:80", t1.Add(time.Hour*4), []string{"foo"}),
+				makeStream("192.168.0.101:123", "192.168.0.1:80", t1.Add(time.Hour*5), []string{"foo"}),
+			},
+			"group:\"@chost@\" sort:-ftime limit:3,2",
+			[]uint64{4, 3, 2},
+		},
+		{
+			"top streams per group of variables",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"b"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*4), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*5), []string{"b"}),
+			},
+			"cdata:\"(?P<x>[ab])\" group:\"@x@\" sort:-id limit:,2",
+			[]uint64{4, 3, 2, 1},
+		},
+		{
+			"group member moved by sorted insertion",
+			[]streamInfo{
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*1), []string{"aaa"}),
+				makeStream("192.168.0.101:123", "192.168.0.1:80", t1.Add(time.Hour*2), []string{"a"}),
+				makeStream("192.168.0.100:123", "192.168.0.1:80", t1.Add(time.Hour*3), []string{"aa"}),
+			},
+			"group:\"@chost@\" sort:cbytes",
+			[]uint64{1, 2},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -541,21 +948,911 @@ func TestSearchStreams(t *testing.T) {
 			if q.Limit != nil {
 				l = *q.Limit
 			}
-			results, _, _, err := SearchStreams(context.Background(), []*Reader{r}, nil, q.ReferenceTime, q.Conditions, q.Grouping, q.Sorting, l, 0, nil, converters, false)
-			if err != nil {
-				t.Fatalf("Error searching streams: %v", err)
+			for _, workers := range []int{1, 4} {
+				withSearchWorkers(workers, 1, func() {
+					results, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+						ReferenceTime: q.ReferenceTime,
+						Grouping:      q.Grouping,
+						Sorting:       q.Sorting,
+						Sampling:      q.Sampling,
+						Limit:         l,
+						Converters:    converters,
+					})
+					if err != nil {
+						t.Fatalf("Error searching streams with %d workers: %v", workers, err)
+					}
+					got := []uint64(nil)
+					for _, s := range results {
+						got = append(got, s.StreamID)
+					}
+					if !slices.Equal(got, tc.expected) {
+						t.Errorf("Unexpected streams with %d workers: %v, want: %v", workers, got, tc.expected)
+					}
+				})
 			}
-			got := []uint64(nil)
-			for _, s := range results {
-				got = append(got, s.StreamID)
+		})
+	}
+}
+
+func withSearchWorkers(workers, chunkSize int, f func()) {
+	oldWorkers, oldChunkSize := *searchWorkers, searchChunkSize
+	defer func() {
+		*searchWorkers, searchChunkSize = oldWorkers, oldChunkSize
+	}()
+	*searchWorkers, searchChunkSize = workers, chunkSize
+	f()
+}
+
+func TestSearchStreamsParallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	converters := map[string]ConverterAccess{}
+	indexes := []*Reader(nil)
+	for i := 0; i < 3; i++ {
+		streamsMap := make(map[uint64]streamInfo)
+		for j := 0; j < 300; j++ {
+			// the last 50 streams of an index are superseded by the next index
+			id := uint64(i*250 + j)
+			client := fmt.Sprintf("10.0.0.%d:%d", id%5+1, 1024+id)
+			server := fmt.Sprintf("10.1.0.%d:%d", id%3+1, 8000+id%7)
+			data := []string{fmt.Sprintf("req%d", id%11), fmt.Sprintf("resp%d-%d", id%4, i)}
+			streamsMap[id] = makeStream(client, server, t1.Add(time.Second*time.Duration(id%97)), data)
+		}
+		r, err := makeIndex(tmpDir, streamsMap, &converters)
+		if err != nil {
+			t.Fatalf("Error creating index: %v", err)
+		}
+		defer r.Close()
+		indexes = append(indexes, r)
+	}
+	for _, qs := range []string{
+		"",
+		"sport:8003",
+		"sport:8003 limit:10",
+		"cdata:req3 sort:ftime limit:7",
+		"sdata:resp1 sort:-sport,id limit:20",
+		"sdata:resp2-1 or cdata:req5 sort:ftime",
+		"cdata:\"req(?P<x>[0-9]+)\" group:x sort:-id",
+		"chost:10.0.0.2 group:sport sort:ftime limit:3",
+		"group:\"@chost@\" sort:-ftime limit:20,3",
+		"cdata:\"req(?P<x>[0-9])\" group:\"@x@,@sport@\" sort:ftime limit:,2",
+		"@a:cdata:req7 sport:@a:sport@ sort:id limit:25",
+		"@a:cdata:req7 -sport:@a:sport@ sort:-ftime limit:25",
+		"ftime:@a:ftime@ @a:sdata:resp3-2",
+	} {
+		q, err := query.Parse(qs)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", qs, err)
+		}
+		l := uint(0)
+		if q.Limit != nil {
+			l = *q.Limit
+		}
+		search := func(workers, chunkSize int) []uint64 {
+			ids := []uint64(nil)
+			withSearchWorkers(workers, chunkSize, func() {
+				results, _, _, err := SearchStreams(context.Background(), indexes, q.Conditions, SearchOptions{
+					ReferenceTime: q.ReferenceTime,
+					Grouping:      q.Grouping,
+					Sorting:       q.Sorting,
+					Sampling:      q.Sampling,
+					Limit:         l,
+					Converters:    converters,
+				})
+				if err != nil {
+					t.Fatalf("Error searching streams for %q with %d workers: %v", qs, workers, err)
+				}
+				for _, s := range results {
+					ids = append(ids, s.StreamID)
+				}
+			})
+			return ids
+		}
+		want := search(1, 1024)
+		if len(want) == 0 {
+			t.Errorf("Query %q returned no results", qs)
+		}
+		for _, chunkSize := range []int{1, 7, 64} {
+			if got := search(4, chunkSize); !slices.Equal(got, want) {
+				t.Errorf("Unexpected streams for %q with chunk size %d: %v, want: %v", qs, chunkSize, got, want)
 			}
-			if !slices.Equal(got, tc.expected) {
-				t.Errorf("Unexpected streams: %v, want: %v", got, tc.expected)
+		}
+	}
+}
+
+func TestSearchStreamsParallelCanceled(t *testing.T) {
+	streamsMap := make(map[uint64]streamInfo)
+	for i := 0; i < 100; i++ {
+		streamsMap[uint64(i)] = makeStream("10.0.0.1:1234", "10.0.0.2:80", t1.Add(time.Second*time.Duration(i)), []string{"foo", "bar"})
+	}
+	converters := map[string]ConverterAccess{}
+	r, err := makeIndex(t.TempDir(), streamsMap, &converters)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	q, err := query.Parse("cdata:foo")
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	withSearchWorkers(4, 1, func() {
+		if _, _, _, err := SearchStreams(ctx, []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			Converters:    converters,
+		}); err != context.Canceled {
+			t.Errorf("Unexpected error: %v, want: %v", err, context.Canceled)
+		}
+	})
+}
+
+func TestSearchStreamsTicks(t *testing.T) {
+	streamsMap := map[uint64]streamInfo{
+		0: makeStream("10.0.0.1:1234", "10.0.0.2:80", t1.Add(10*time.Second), []string{"foo", "bar"}),
+		1: makeStream("10.0.0.1:1235", "10.0.0.2:80", t1.Add(70*time.Second), []string{"foo", "bar"}),
+		// during the pause, still belongs to tick 2
+		2: makeStream("10.0.0.1:1236", "10.0.0.2:80", t1.Add(3*time.Minute), []string{"foo", "bar"}),
+		3: makeStream("10.0.0.1:1237", "10.0.0.2:80", t1.Add(3*time.Minute+45*time.Second), []string{"foo", "bar"}),
+		4: makeStream("10.0.0.1:1238", "10.0.0.2:80", t1.Add(4*time.Minute), []string{"foo", "bar"}),
+	}
+	converters := map[string]ConverterAccess{}
+	r, err := makeIndex(t.TempDir(), streamsMap, &converters)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	ticks := &query.TickSchedule{
+		Start:  t1,
+		Length: time.Minute,
+		Pauses: []query.TickPause{{
+			Start:    t1.Add(150 * time.Second),
+			Duration: time.Minute,
+		}},
+	}
+	// the current tick is tick 3
+	refTime := t1.Add(4*time.Minute + 30*time.Second)
+	for _, tc := range []struct {
+		query    string
+		expected []uint64
+	}{
+		{"tick:2 sort:id", []uint64{2, 3}},
+		{"tick:1:2 sort:id", []uint64{1, 2, 3}},
+		{"-tick:1:2 sort:id", []uint64{0, 4}},
+		{"tick:3: sort:id", []uint64{4}},
+		{"tick:-1 sort:id", []uint64{2, 3}},
+		{"tick:-0", []uint64{4}},
+		{"tick:-2:-1 tick:2: sort:id", []uint64{2, 3}},
+		{"tick:0,3 sort:id", []uint64{0, 4}},
+		{"sort:tick,-id", []uint64{0, 1, 3, 2, 4}},
+	} {
+		q, err := query.Parse(tc.query)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", tc.query, err)
+		}
+		results, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: refTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			Ticks:         ticks,
+			Converters:    converters,
+		})
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", tc.query, err)
+		}
+		got := []uint64{}
+		for _, s := range results {
+			got = append(got, s.StreamID)
+		}
+		if !slices.Equal(got, tc.expected) {
+			t.Errorf("Unexpected streams for %q: %v, want: %v", tc.query, got, tc.expected)
+		}
+	}
+	q, err := query.Parse("tick:1")
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	if _, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+		ReferenceTime: refTime,
+		Grouping:      q.Grouping,
+		Sorting:       q.Sorting,
+		Sampling:      q.Sampling,
+		Converters:    converters,
+	}); err == nil {
+		t.Errorf("Searching for ticks without a tick schedule succeeded")
+	}
+}
+
+func TestSearchStreamsTagReferenceTime(t *testing.T) {
+	streamsMap := map[uint64]streamInfo{
+		0: makeStream("10.0.0.1:1234", "10.0.0.2:80", t1, []string{"foo"}),
+		1: makeStream("10.0.0.1:1235", "10.0.0.2:80", t1.Add(10*time.Minute), []string{"foo"}),
+		2: makeStream("10.0.0.1:1236", "10.0.0.2:80", t1.Add(20*time.Minute), []string{"foo"}),
+	}
+	converters := map[string]ConverterAccess{}
+	r, err := makeIndex(t.TempDir(), streamsMap, &converters)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	tagDetails := map[string]query.TagDetails{}
+	for name, qs := range map[string]string{
+		"tag/old":   "ftime::-15m",
+		"tag/early": fmt.Sprintf(`ftime:":%s"`, t1.Add(15*time.Minute).Local().Format("2006-01-02 1504")),
+	} {
+		q, err := query.Parse(qs)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", qs, err)
+		}
+		// the tags are evaluated against a time after all streams
+		tagReferenceTime := t1.Add(30 * time.Minute)
+		q.Conditions.UpdateReferenceTime(q.ReferenceTime, tagReferenceTime)
+		uncertain := bitmask.LongBitmask{}
+		for id := range streamsMap {
+			uncertain.Set(uint(id))
+		}
+		tagDetails[name] = query.TagDetails{
+			Uncertain:     uncertain,
+			Conditions:    q.Conditions,
+			ReferenceTime: tagReferenceTime,
+		}
+	}
+	for _, qs := range []string{"tag:old sort:id", "tag:early sort:id"} {
+		q, err := query.Parse(qs)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", qs, err)
+		}
+		results, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			TagDetails:    tagDetails,
+			Converters:    converters,
+		})
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", qs, err)
+		}
+		got := []uint64{}
+		for _, s := range results {
+			got = append(got, s.StreamID)
+		}
+		if want := []uint64{0, 1}; !slices.Equal(got, want) {
+			t.Errorf("Unexpected streams for %q: %v, want: %v", qs, got, want)
+		}
+	}
+}
+
+func TestSearchStreamsTiming(t *testing.T) {
+	// timedStream alternates between client and server chunks, the delays
+	// are the times between consecutive chunks
+	timedStream := func(client string, delays ...time.Duration) streamInfo {
+		data := []string{"req"}
+		for range delays {
+			data = append(data, map[bool]string{false: "req", true: "resp"}[len(data)%2 == 1])
+		}
+		si := makeStream(client, "10.0.0.2:80", t1, data)
+		ts := si.s.Packets[1].Timestamp
+		for i, d := range delays {
+			ts = ts.Add(d)
+			si.s.Packets[i+2].Timestamp = ts
+		}
+		return si
+	}
+	streamsMap := map[uint64]streamInfo{
+		0: timedStream("10.0.0.1:1234", 50*time.Millisecond),
+		1: timedStream("10.0.0.1:1235", 3*time.Second),
+		2: timedStream("10.0.0.1:1236", 3*time.Second, time.Second, 50*time.Millisecond),
+	}
+	converters := map[string]ConverterAccess{
+		"dummy": &fakeConverter{},
+	}
+	r, err := makeIndex(t.TempDir(), streamsMap, &converters)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	for _, tc := range []struct {
+		query    string
+		expected []uint64
+	}{
+		{"cdata:req then sdata:resp sort:id", []uint64{0, 1, 2}},
+		{"cdata:req then[<100ms] sdata:resp sort:id", []uint64{0, 2}},
+		{"cdata:req then[>=1s] sdata:resp sort:id", []uint64{1, 2}},
+		{"cdata:req then[>1s,<5s] sdata:resp sort:id", []uint64{1, 2}},
+		{"cdata:req then[<100ms] -sdata:resp sort:id", []uint64{1, 2}},
+		{"cdata:req then[<1s] sdata:resp then[<1s] cdata:req sort:id", []uint64{}},
+		{"cdata:req then sdata:resp then[<=1s] cdata:req then[<100ms] sdata:resp sort:id", []uint64{2}},
+	} {
+		q, err := query.Parse(tc.query)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", tc.query, err)
+		}
+		results, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			Converters:    converters,
+		})
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", tc.query, err)
+		}
+		got := []uint64{}
+		for _, s := range results {
+			got = append(got, s.StreamID)
+		}
+		if !slices.Equal(got, tc.expected) {
+			t.Errorf("Unexpected streams for %q: %v, want: %v", tc.query, got, tc.expected)
+		}
+	}
+	for _, qs := range []string{"cdata:req then[] sdata:resp", "cdata:req then[<1x] sdata:resp", "cdata:req then[>2s,<1s] sdata:resp", "sport:80 then[<1s] sdata:resp", "-cdata:req then[<1s] sdata:resp", "cdata:req then[<1s] sport:80"} {
+		if _, err := query.Parse(qs); err == nil {
+			t.Errorf("Parsing %q succeeded, want error", qs)
+		}
+	}
+	q, err := query.Parse("cdata.dummy:req then[<1s] sdata.dummy:resp")
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	if _, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+		ReferenceTime: q.ReferenceTime,
+		Grouping:      q.Grouping,
+		Sorting:       q.Sorting,
+		Sampling:      q.Sampling,
+		Converters:    converters,
+	}); err == nil {
+		t.Errorf("Searching converted data with timing constraints succeeded")
+	}
+}
+
+func TestSearchStreamsPcaps(t *testing.T) {
+	streamsMap := map[uint64]streamInfo{
+		0: makeStream("10.0.0.1:1234", "10.0.0.2:80", t1, []string{"foo"}),
+		1: makeStream("10.0.0.1:1235", "10.0.0.2:80", t1.Add(time.Second), []string{"foo"}),
+		2: makeStream("10.0.0.3:1236", "10.0.0.2:80", t1.Add(2*time.Second), []string{"foo"}),
+	}
+	pcapFilename := func(id uint64) string {
+		return streamsMap[id].s.Packets[0].AncillaryData[0].(*pcapmetadata.PcapMetadata).PcapInfo.Filename
+	}
+	// the pcaps were imported in a different order than the streams were created
+	imports := []string{pcapFilename(2), pcapFilename(0), pcapFilename(1)}
+	r, err := makeIndex(t.TempDir(), streamsMap, nil)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	for _, tc := range []struct {
+		query    string
+		expected []uint64
+	}{
+		{`pcap:"10.0.0.1:*" sort:id`, []uint64{0, 1}},
+		{`pcap:"10.0.0.3:*,10.0.0.1:1234_*" sort:id`, []uint64{0, 2}},
+		{`-pcap:"10.0.0.3:*" sort:id`, []uint64{0, 1}},
+		{`pcap:"*.pcapng" sort:id`, []uint64{}},
+		{`import:0 sort:id`, []uint64{2}},
+		{`import:-1 sort:id`, []uint64{1}},
+		{`import:-2: sort:id`, []uint64{0, 1}},
+		{`import::1 sort:id`, []uint64{0, 2}},
+		{`-import:-1 sort:id`, []uint64{0, 2}},
+		{`import:5: sort:id`, []uint64{}},
+		{`import:1: pcap:"10.0.0.1:1235_*" sort:id`, []uint64{1}},
+	} {
+		q, err := query.Parse(tc.query)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", tc.query, err)
+		}
+		results, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			Imports:       imports,
+		})
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", tc.query, err)
+		}
+		got := []uint64{}
+		for _, s := range results {
+			got = append(got, s.StreamID)
+		}
+		if !slices.Equal(got, tc.expected) {
+			t.Errorf("Unexpected streams for %q: %v, want: %v", tc.query, got, tc.expected)
+		}
+	}
+	for _, qs := range []string{`pcap:"foo["`, "import:+1", "import:@x@"} {
+		if _, err := query.Parse(qs); err == nil {
+			t.Errorf("Parsing %q succeeded, want error", qs)
+		}
+	}
+	s, err := r.StreamByID(0)
+	if err != nil {
+		t.Fatalf("Error getting stream: %v", err)
+	}
+	pcaps, err := s.PcapFilenames()
+	if err != nil {
+		t.Fatalf("Error getting pcaps of stream: %v", err)
+	}
+	if want := []string{pcapFilename(0)}; !slices.Equal(pcaps, want) {
+		t.Errorf("Unexpected pcaps %v, want: %v", pcaps, want)
+	}
+}
+
+func TestSearchStreamsSampling(t *testing.T) {
+	streamsMap := map[uint64]streamInfo{}
+	for i := uint64(0); i < 50; i++ {
+		data := map[bool]string{false: "odd", true: "even"}[i%2 == 0]
+		streamsMap[i] = makeStream(fmt.Sprintf("10.0.0.1:%d", 1000+i), "10.0.0.2:80", t1.Add(time.Duration(i)*time.Second), []string{data})
+	}
+	r, err := makeIndex(t.TempDir(), streamsMap, nil)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	search := func(qs string, limit, skip uint) ([]uint64, bool) {
+		t.Helper()
+		q, err := query.Parse(qs)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", qs, err)
+		}
+		results, hasMore, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			Limit:         limit,
+			Skip:          skip,
+		})
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", qs, err)
+		}
+		got := []uint64{}
+		for _, s := range results {
+			got = append(got, s.StreamID)
+		}
+		return got, hasMore
+	}
+
+	sample, hasMore := search("sample:10,42 sort:id", 0, 0)
+	if len(sample) != 10 || hasMore || !slices.IsSorted(sample) {
+		t.Fatalf("Unexpected sample %v, hasMore: %v", sample, hasMore)
+	}
+	if again, _ := search("sample:10,42 sort:id", 0, 0); !slices.Equal(again, sample) {
+		t.Errorf("Sample with the same seed changed: %v, want: %v", again, sample)
+	}
+	if other, _ := search("sample:10,43 sort:id", 0, 0); slices.Equal(other, sample) {
+		t.Errorf("Sample with a different seed did not change: %v", other)
+	}
+	reversed, _ := search("sample:10,42 sort:-id", 0, 0)
+	slices.Reverse(reversed)
+	if !slices.Equal(reversed, sample) {
+		t.Errorf("Sorting changed the sample: %v, want: %v", reversed, sample)
+	}
+	if page, hasMore := search("sample:10,42 sort:id", 4, 4); !slices.Equal(page, sample[4:8]) || !hasMore {
+		t.Errorf("Unexpected page %v, hasMore: %v, want: %v, true", page, hasMore, sample[4:8])
+	}
+	if page, hasMore := search("sample:10,42 sort:id", 4, 8); !slices.Equal(page, sample[8:]) || hasMore {
+		t.Errorf("Unexpected page %v, hasMore: %v, want: %v, false", page, hasMore, sample[8:])
+	}
+	filtered, _ := search("cdata:even sample:5", 0, 0)
+	if len(filtered) != 5 {
+		t.Errorf("Unexpected sample size %d, want 5", len(filtered))
+	}
+	for _, id := range filtered {
+		if id%2 != 0 {
+			t.Errorf("Sample contains stream %d not matching the filter", id)
+		}
+	}
+	if all, _ := search("sample:100 sort:id", 0, 0); len(all) != 50 {
+		t.Errorf("Unexpected sample size %d, want 50", len(all))
+	}
+	for _, qs := range []string{"sample:0", "sample:x", "sample:1,x", "sample:1 sample:2"} {
+		if _, err := query.Parse(qs); err == nil {
+			t.Errorf("Parsing %q succeeded, want error", qs)
+		}
+	}
+}
+
+func TestExplainSearchStreams(t *testing.T) {
+	streamsMap := map[uint64]streamInfo{
+		0: makeStream("10.0.0.1:1234", "10.0.0.2:80", t1, []string{"foo", "bar"}),
+		1: makeStream("10.0.0.1:1235", "10.0.0.2:443", t1.Add(time.Second), []string{"foo", "baz"}),
+		2: makeStream("10.0.0.1:1236", "10.0.0.2:443", t1.Add(2*time.Second), []string{"qux", "bar"}),
+		3: makeStream("10.0.0.1:1237", "10.0.0.2:8080", t1.Add(3*time.Second), []string{"foo", "bar"}),
+	}
+	converters := map[string]ConverterAccess{}
+	r, err := makeIndex(t.TempDir(), streamsMap, &converters)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	q, err := query.Parse("sport:443 cdata:foo")
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	e, err := ExplainSearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+		ReferenceTime: t1,
+		Grouping:      q.Grouping,
+		Sorting:       q.Sorting,
+		Sampling:      q.Sampling,
+		Converters:    converters,
+	})
+	if err != nil {
+		t.Fatalf("Error explaining search: %v", err)
+	}
+	if e.Results != 1 {
+		t.Errorf("Unexpected number of results: %d, want: 1", e.Results)
+	}
+	if len(e.SubQueries) != 1 || len(e.SubQueries[0].Indexes) != 1 {
+		t.Fatalf("Unexpected explanation structure: %+v", e)
+	}
+	ie := e.SubQueries[0].Indexes[0]
+	if ie.FullScan {
+		t.Errorf("Server port lookup not used")
+	}
+	if ie.EstimatedCandidates != 2 || ie.EvaluatedCandidates != 2 || ie.MatchingCandidates != 1 {
+		t.Errorf("Unexpected candidate counts: %+v", ie)
+	}
+	if len(ie.QueryParts) != 1 {
+		t.Fatalf("Unexpected number of query parts: %d", len(ie.QueryParts))
+	}
+	qp := ie.QueryParts[0]
+	if !slices.Equal(qp.Lookups, []string{"sport 443:443"}) {
+		t.Errorf("Unexpected lookups: %q", qp.Lookups)
+	}
+	if !slices.Contains(qp.Filters, "data") {
+		t.Errorf("Data filter missing: %q", qp.Filters)
+	}
+	if len(qp.RegexPrefilters) != 1 || !strings.Contains(qp.RegexPrefilters[0], `prefix "foo"`) {
+		t.Errorf("Unexpected regex prefilters: %q", qp.RegexPrefilters)
+	}
+}
+
+func TestSearchStreamsIncremental(t *testing.T) {
+	// the newer index only contains newer streams
+	readers := []*Reader(nil)
+	for i := uint64(0); i < 30; i += 15 {
+		streamsMap := map[uint64]streamInfo{}
+		for j := i; j < i+15; j++ {
+			data := map[bool]string{false: "odd", true: "even"}[j%2 == 0]
+			streamsMap[j] = makeStream(fmt.Sprintf("10.0.0.1:%d", 1000+j), "10.0.0.2:80", t1.Add(time.Duration(j)*time.Second), []string{data})
+		}
+		r, err := makeIndex(t.TempDir(), streamsMap, nil)
+		if err != nil {
+			t.Fatalf("Error creating index: %v", err)
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	for _, tc := range []struct {
+		query       string
+		limit, skip uint
+		// batches is the number of calls of emit
+		batches int
+	}{
+		{"", 0, 0, 2},
+		{"cdata:even", 5, 0, 1},
+		{"cdata:even", 5, 5, 2},
+		{"cdata:even", 5, 10, 1},
+		{"cdata:odd", 10, 10, 1},
+		{"sort:ftime", 0, 0, 1},
+		{"sort:id", 7, 3, 1},
+		{"cdata:odd sort:-id", 4, 0, 1},
+		{"group:sport", 0, 0, 1},
+		{"sample:4,1", 0, 0, 1},
+	} {
+		q, err := query.Parse(tc.query)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", tc.query, err)
+		}
+		want, wantMore, _, err := SearchStreams(context.Background(), readers, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			Limit:         tc.limit,
+			Skip:          tc.skip,
+		})
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", tc.query, err)
+		}
+		got := []*Stream(nil)
+		batches := 0
+		progress := []SearchProgress(nil)
+		gotMore, _, err := SearchStreamsIncremental(context.Background(), readers, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+			Limit:         tc.limit,
+			Skip:          tc.skip,
+		}, func(streams []*Stream) error {
+			got = append(got, streams...)
+			batches++
+			return nil
+		}, func(p SearchProgress) {
+			progress = append(progress, p)
+		})
+		if err != nil {
+			t.Fatalf("Error searching streams incrementally for %q: %v", tc.query, err)
+		}
+		if gotMore != wantMore || len(got) != len(want) {
+			t.Errorf("Unexpected results for %q: %d streams, hasMore: %v, want: %d streams, hasMore: %v", tc.query, len(got), gotMore, len(want), wantMore)
+			continue
+		}
+		if len(progress) != 2 || progress[1].IndexesSearched != 2 || progress[1].Indexes != 2 {
+			t.Errorf("Unexpected progress for %q: %+v", tc.query, progress)
+		}
+		if batches != tc.batches {
+			t.Errorf("Unexpected number of batches for %q: %d, want: %d", tc.query, batches, tc.batches)
+		}
+		// the streams are emitted in the order of the result
+		for i := range got {
+			if got[i].StreamID != want[i].StreamID {
+				t.Errorf("Unexpected result %d for %q: %d, want: %d", i, tc.query, got[i].StreamID, want[i].StreamID)
 			}
+		}
+	}
+
+	// errors of emit abort the search
+	q, err := query.Parse("cdata:even")
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	errEmit := errors.New("emit failed")
+	calls := 0
+	if _, _, err := SearchStreamsIncremental(context.Background(), readers, q.Conditions, SearchOptions{
+		ReferenceTime: q.ReferenceTime,
+	}, func([]*Stream) error {
+		calls++
+		return errEmit
+	}, nil); err != errEmit || calls != 1 {
+		t.Errorf("Unexpected error %v after %d calls, want: %v after 1 call", err, calls, errEmit)
+	}
+}
+
+func TestSearchStreamsResultDetails(t *testing.T) {
+	streamsMap := map[uint64]streamInfo{}
+	for i := uint64(0); i < 9; i++ {
+		data := fmt.Sprintf("exploit tok%d", i)
+		if i >= 4 {
+			data = fmt.Sprintf("get tok%d", i-4)
+		}
+		streamsMap[i] = makeStream(fmt.Sprintf("10.0.0.1:%d", 1000+i), fmt.Sprintf("10.0.0.2:%d", 80+i%3), t1.Add(time.Duration(i)*time.Second), []string{data})
+	}
+	r, err := makeIndex(t.TempDir(), streamsMap, nil)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	search := func(qs string, alternatives bool) ([]*Stream, *ResultDetails) {
+		t.Helper()
+		q, err := query.Parse(qs)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", qs, err)
+		}
+		results, details, _, _, err := SearchStreamsWithDetails(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+		}, alternatives)
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", qs, err)
+		}
+		return results, details
+	}
+	ids := func(streams []*Stream) []uint64 {
+		res := []uint64{}
+		for _, s := range streams {
+			res = append(res, s.StreamID)
+		}
+		return res
+	}
+
+	// every flag retrieval stream is bound to the exploit stream with the same token
+	results, details := search(`@sub:cdata:"exploit (?P<var>tok[0-9])" cdata:"get @sub:var@" sort:id`, false)
+	if got := ids(results); !slices.Equal(got, []uint64{4, 5, 6, 7}) {
+		t.Fatalf("Unexpected results %v, want: [4 5 6 7]", got)
+	}
+	for _, s := range results {
+		sq := details.SubQueryStreams[s.StreamID]
+		if len(sq) != 1 || !slices.Equal(ids(sq["sub"]), []uint64{s.StreamID - 4}) {
+			t.Errorf("Unexpected sub query streams of stream %d: %v", s.StreamID, sq)
+		}
+	}
+	if details.GroupAlternatives != nil {
+		t.Errorf("Unexpected group alternatives: %v", details.GroupAlternatives)
+	}
+	// only the returned streams have details
+	q, err := query.Parse(`@sub:cdata:"exploit (?P<var>tok[0-9])" cdata:"get @sub:var@" sort:id`)
+	if err != nil {
+		t.Fatalf("Error parsing query: %v", err)
+	}
+	results, details, _, _, err = SearchStreamsWithDetails(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+		ReferenceTime: q.ReferenceTime,
+		Sorting:       q.Sorting,
+		Limit:         1,
+		Skip:          1,
+	}, false)
+	if err != nil || !slices.Equal(ids(results), []uint64{5}) || len(details.SubQueryStreams) != 1 || details.SubQueryStreams[5] == nil {
+		t.Errorf("Unexpected results %v with details %v, error: %v", ids(results), details, err)
+	}
+	if _, details := search("cdata:exploit", false); details.SubQueryStreams != nil {
+		t.Errorf("Unexpected sub query streams without sub query")
+	}
+
+	if _, details := search(`group:"@sport@" sort:id`, false); details.GroupAlternatives != nil {
+		t.Errorf("Group alternatives collected without requesting them")
+	}
+	want := map[uint64][]uint64{
+		0: {3, 6},
+		1: {4, 7},
+		2: {5, 8},
+	}
+	results, details = search(`group:"@sport@" sort:id`, true)
+	if got := ids(results); !slices.Equal(got, []uint64{0, 1, 2}) {
+		t.Fatalf("Unexpected results %v, want: [0 1 2]", got)
+	}
+	for _, s := range results {
+		if got := ids(details.GroupAlternatives[s.StreamID]); !slices.Equal(got, want[s.StreamID]) {
+			t.Errorf("Unexpected group alternatives of stream %d: %v, want: %v", s.StreamID, got, want[s.StreamID])
+		}
+	}
+	// the best streams are returned, the replaced ones become alternatives
+	results, details = search(`group:"@sport@" sort:-id`, true)
+	if got := ids(results); !slices.Equal(got, []uint64{8, 7, 6}) {
+		t.Fatalf("Unexpected results %v, want: [8 7 6]", got)
+	}
+	if got := ids(details.GroupAlternatives[8]); !slices.Equal(got, []uint64{5, 2}) {
+		t.Errorf("Unexpected group alternatives of stream 8: %v, want: [5 2]", got)
+	}
+}
+
+func TestAggregateStreams(t *testing.T) {
+	streamsMap := map[uint64]streamInfo{}
+	for i := uint64(0); i < 20; i++ {
+		data := map[bool][]string{false: {"odd", "reply"}, true: {"even"}}[i%2 == 0]
+		streamsMap[i] = makeStream(fmt.Sprintf("10.0.0.1:%d", 1000+i), fmt.Sprintf("10.0.0.2:%d", 80+i%3), t1.Add(time.Duration(i)*time.Minute), data)
+	}
+	r, err := makeIndex(t.TempDir(), streamsMap, nil)
+	if err != nil {
+		t.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	for _, tc := range []struct {
+		query  string
+		bucket time.Duration
+	}{
+		{"", 0},
+		{"cdata:odd", 0},
+		{"cdata:even", 5 * time.Minute},
+		{"id:3:12", time.Hour},
+		{"group:sport", 0},
+		{"sample:7,1", 10 * time.Minute},
+		{"cdata:nothing", time.Minute},
+	} {
+		q, err := query.Parse(tc.query)
+		if err != nil {
+			t.Fatalf("Error parsing query %q: %v", tc.query, err)
+		}
+		results, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
 		})
+		if err != nil {
+			t.Fatalf("Error searching streams for %q: %v", tc.query, err)
+		}
+		want := SearchAggregation{}
+		buckets := map[time.Time]*AggregationBucket{}
+		for _, s := range results {
+			want.Streams++
+			want.ClientBytes += s.ClientBytes
+			want.ServerBytes += s.ServerBytes
+			if want.FirstPacket.IsZero() || s.FirstPacket().Before(want.FirstPacket) {
+				want.FirstPacket = s.FirstPacket()
+			}
+			if s.LastPacket().After(want.LastPacket) {
+				want.LastPacket = s.LastPacket()
+			}
+			if tc.bucket == 0 {
+				continue
+			}
+			start := s.FirstPacket().Truncate(tc.bucket)
+			b := buckets[start]
+			if b == nil {
+				b = &AggregationBucket{Start: start}
+				buckets[start] = b
+			}
+			b.Streams++
+			b.ClientBytes += s.ClientBytes
+			b.ServerBytes += s.ServerBytes
+		}
+		got, err := AggregateStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+			ReferenceTime: q.ReferenceTime,
+			Grouping:      q.Grouping,
+			Sorting:       q.Sorting,
+			Sampling:      q.Sampling,
+		}, tc.bucket)
+		if err != nil {
+			t.Fatalf("Error aggregating streams for %q: %v", tc.query, err)
+		}
+		if got.Streams != want.Streams || got.ClientBytes != want.ClientBytes || got.ServerBytes != want.ServerBytes || !got.FirstPacket.Equal(want.FirstPacket) || !got.LastPacket.Equal(want.LastPacket) {
+			t.Errorf("Unexpected aggregation for %q: %+v, want: %+v", tc.query, got, want)
+		}
+		if tc.bucket == 0 {
+			if got.Buckets != nil {
+				t.Errorf("Unexpected buckets for %q: %+v", tc.query, got.Buckets)
+			}
+			continue
+		}
+		if len(got.Buckets) != len(buckets) {
+			t.Errorf("Unexpected number of buckets for %q: %d, want: %d", tc.query, len(got.Buckets), len(buckets))
+			continue
+		}
+		for i, b := range got.Buckets {
+			if i != 0 && !got.Buckets[i-1].Start.Before(b.Start) {
+				t.Errorf("Buckets for %q not sorted: %+v", tc.query, got.Buckets)
+			}
+			if w := buckets[b.Start]; w == nil || *w != b {
+				t.Errorf("Unexpected bucket for %q: %+v, want: %+v", tc.query, b, w)
+			}
+		}
 	}
 }
 
+func fnvHash(data string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(data))
+	return h.Sum64()
+}
+
 func TestSearch(t *testing.T) {
 
 }
+
+func BenchmarkSearchMetadata(b *testing.B) {
+	streamsMap := make(map[uint64]streamInfo)
+	for i := 0; i < 10000; i++ {
+		client := fmt.Sprintf("10.0.%d.%d:%d", i/250%4, i%250+1, 1024+i)
+		server := fmt.Sprintf("10.1.0.%d:%d", i%8+1, 8000+i%100)
+		streamsMap[uint64(i)] = makeStream(client, server, t1.Add(time.Second*time.Duration(i)), []string{"foo", "bar"})
+	}
+	converters := map[string]ConverterAccess{}
+	r, err := makeIndex(b.TempDir(), streamsMap, &converters)
+	if err != nil {
+		b.Fatalf("Error creating index: %v", err)
+	}
+	defer r.Close()
+	for _, qs := range []string{
+		"sport:8042",
+		"sport:8010:8019",
+		"chost:10.0.1.17",
+		"shost:10.1.0.3",
+		"chost:10.0.1.17 sport:8000:8049",
+	} {
+		q, err := query.Parse(qs)
+		if err != nil {
+			b.Fatalf("Error parsing query %q: %v", qs, err)
+		}
+		b.Run(qs, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := SearchStreams(context.Background(), []*Reader{r}, q.Conditions, SearchOptions{
+					ReferenceTime: q.ReferenceTime,
+					Grouping:      q.Grouping,
+					Sorting:       q.Sorting,
+					Sampling:      q.Sampling,
+					Limit:         100,
+					Converters:    converters,
+				}); err != nil {
+					b.Fatalf("Error searching streams: %v", err)
+				}
+			}
+		})
+	}
+}
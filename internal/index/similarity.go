@@ -0,0 +1,139 @@
+package index
+
+import (
+	"math"
+
+	"github.com/spq/pkappa2/internal/query"
+)
+
+type (
+	// similaritySketch is a b-bit MinHash sketch of the 4 byte shingles
+	// of the data of one direction of a stream.
+	similaritySketch [similaritySketchSize]uint16
+
+	similaritySketcher struct {
+		mins   [similaritySketchSize]uint64
+		window uint32
+		n      int
+	}
+)
+
+const (
+	// number of hash functions used for the similarity sketches
+	similaritySketchSize = 32
+	// number of bytes in a shingle
+	similarityShingleSize = 4
+)
+
+func newSimilaritySketcher() *similaritySketcher {
+	s := &similaritySketcher{}
+	for i := range s.mins {
+		s.mins[i] = math.MaxUint64
+	}
+	return s
+}
+
+func similarityMix(h uint64) uint64 {
+	// finalizer of murmur3
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func (s *similaritySketcher) addShingle(shingle uint64) {
+	// derive the hash functions from two independent hashes of the shingle
+	h1 := similarityMix(shingle)
+	h2 := similarityMix(shingle^0x9e3779b97f4a7c15) | 1
+	for i := range s.mins {
+		if h1 < s.mins[i] {
+			s.mins[i] = h1
+		}
+		h1 += h2
+	}
+}
+
+func (s *similaritySketcher) Write(data []byte) {
+	for _, b := range data {
+		s.window = s.window<<8 | uint32(b)
+		s.n++
+		if s.n >= similarityShingleSize {
+			s.addShingle(uint64(s.window))
+		}
+	}
+}
+
+func (s *similaritySketcher) Sum() similaritySketch {
+	if s.n != 0 && s.n < similarityShingleSize {
+		// data shorter than a shingle is a single shingle including its length
+		s.addShingle(uint64(s.window) | uint64(s.n)<<32)
+		s.n = similarityShingleSize
+	}
+	res := similaritySketch{}
+	for i, m := range s.mins {
+		res[i] = uint16(m)
+	}
+	return res
+}
+
+// matches returns the number of equal hash values in both sketches.
+func (a *similaritySketch) matches(b *similaritySketch) int {
+	n := 0
+	for i := range a {
+		if a[i] == b[i] {
+			n++
+		}
+	}
+	return n
+}
+
+// similarity returns the estimated similarity of the data of the given type
+// of both streams, from 0 for no similarity to 1 for identical streams.
+func (s *streamSketches) similarity(o *streamSketches, t query.SimilarityConditionType) float64 {
+	switch t {
+	case query.SimilarityConditionTypeClient:
+		return float64(s.Client.matches(&o.Client)) / similaritySketchSize
+	case query.SimilarityConditionTypeServer:
+		return float64(s.Server.matches(&o.Server)) / similaritySketchSize
+	default:
+		return float64(s.Client.matches(&o.Client)+s.Server.matches(&o.Server)) / (2 * similaritySketchSize)
+	}
+}
+
+// findSimilarityReferences looks up the sketches of the streams used as
+// reference in similarity conditions, preferring newer indexes.
+func findSimilarityReferences(indexes []*Reader, qs query.ConditionsSet) (map[uint64]*streamSketches, error) {
+	res := map[uint64]*streamSketches(nil)
+	for _, c := range qs {
+		for _, cc := range c {
+			sc, ok := cc.(*query.SimilarityCondition)
+			if !ok {
+				continue
+			}
+			if _, ok := res[sc.StreamID]; ok {
+				continue
+			}
+			if res == nil {
+				res = map[uint64]*streamSketches{}
+			}
+			res[sc.StreamID] = nil
+			for i := len(indexes) - 1; i >= 0; i-- {
+				streamIndex, ok := indexes[i].containedStreamIds[sc.StreamID]
+				if !ok {
+					continue
+				}
+				sketches, err := indexes[i].sketchesByIndex(streamIndex)
+				if err != nil {
+					return nil, err
+				}
+				// copy the sketches as they might point into the mapping
+				ref := *sketches
+				res[sc.StreamID] = &ref
+				break
+			}
+		}
+	}
+	return res, nil
+}
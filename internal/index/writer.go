@@ -4,12 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"hash/fnv"
 	"io"
 	"math"
 	"os"
 	"runtime/debug"
 	"sort"
 	"time"
+	"unsafe"
 
 	"github.com/gopacket/gopacket/reassembly"
 	"github.com/spq/pkappa2/internal/index/streams"
@@ -34,7 +36,9 @@ type (
 		imports    map[writerImportEntry]uint32
 		packets    []packet
 		streams    []stream
+		sketches   []streamSketches
 		header     fileHeader
+		maxSize    int64
 	}
 )
 
@@ -117,6 +121,19 @@ func (w *Writer) pad(n uint64) error {
 	return nil
 }
 
+// estimatedSize returns the size of the index if it would be finalized now,
+// not accounting for hosts and imports.
+func (w *Writer) estimatedSize() (int64, error) {
+	pos, err := w.pos()
+	if err != nil {
+		return 0, err
+	}
+	nLookups := sectionsCount - int(sectionStreamsByStreamID)
+	streamSize := int64(unsafe.Sizeof(stream{})) + int64(unsafe.Sizeof(streamSketches{})) + int64(4*nLookups)
+	packetSize := int64(unsafe.Sizeof(packet{}))
+	return int64(pos) + int64(len(w.streams))*streamSize + int64(len(w.packets))*packetSize, nil
+}
+
 func NewWriter(filename string) (*Writer, error) {
 	file, err := os.Create(filename)
 	if err != nil {
@@ -185,6 +202,7 @@ func (w *Writer) AddStream(s *streams.Stream, streamID uint64) (bool, error) {
 		LastPacketTimeNS:  uint64(lastPacketTs.Sub(referenceTime).Nanoseconds()),
 		Flags:             flagsStreamSegmentationNone,
 	}
+	sketches := streamSketches{}
 	switch s.Flags & streams.StreamFlagsProtocol {
 	case streams.StreamFlagsProtocolTCP:
 		stream.Flags |= flagsStreamProtocolTCP
@@ -285,6 +303,12 @@ func (w *Writer) AddStream(s *streams.Stream, streamID uint64) (bool, error) {
 	lastPacketWithData := len(w.packets)
 	for pIndex, p := range s.Packets {
 		dir := s.PacketDirections[pIndex]
+		switch dir {
+		case reassembly.TCPDirClientToServer:
+			stream.ClientPackets++
+		case reassembly.TCPDirServerToClient:
+			stream.ServerPackets++
+		}
 		pmds := pcapmetadata.AllFromPacketMetadata(&p)
 		for _, pmd := range pmds {
 			flags := uint8(flagsPacketHasNext)
@@ -342,6 +366,9 @@ func (w *Writer) AddStream(s *streams.Stream, streamID uint64) (bool, error) {
 
 	for _, wantDir := range []reassembly.TCPFlowDirection{reassembly.TCPDirClientToServer, reassembly.TCPDirServerToClient} {
 		nWritten := 0
+		hash, prefixHash := fnv.New64a(), fnv.New64a()
+		sketch := newSimilaritySketcher()
+		histogram := byteHistogram{}
 		for dIndex := range s.Data {
 			d := &s.Data[dIndex]
 			if dir := s.PacketDirections[d.PacketIndex]; dir != wantDir {
@@ -351,13 +378,29 @@ func (w *Writer) AddStream(s *streams.Stream, streamID uint64) (bool, error) {
 				undo()
 				return false, err
 			}
+			hash.Write(d.Bytes)
+			sketch.Write(d.Bytes)
+			histogram.Write(d.Bytes)
+			if nWritten < fingerprintPrefixSize {
+				prefixHash.Write(d.Bytes[:min(len(d.Bytes), fingerprintPrefixSize-nWritten)])
+			}
 			nWritten += len(d.Bytes)
 		}
 		switch wantDir {
 		case reassembly.TCPDirClientToServer:
 			stream.ClientBytes += uint64(nWritten)
+			stream.ClientHash = hash.Sum64()
+			stream.ClientPrefixHash = prefixHash.Sum64()
+			sketches.Client = sketch.Sum()
+			stream.ClientEntropy = histogram.entropy()
+			stream.ClientPrintable = histogram.printable()
 		case reassembly.TCPDirServerToClient:
 			stream.ServerBytes += uint64(nWritten)
+			stream.ServerHash = hash.Sum64()
+			stream.ServerPrefixHash = prefixHash.Sum64()
+			sketches.Server = sketch.Sum()
+			stream.ServerEntropy = histogram.entropy()
+			stream.ServerPrintable = histogram.printable()
 		}
 	}
 	segmentation := []byte(nil)
@@ -377,6 +420,12 @@ func (w *Writer) AddStream(s *streams.Stream, streamID uint64) (bool, error) {
 			}
 			sz += len(d2.Bytes)
 		}
+		switch dir {
+		case reassembly.TCPDirClientToServer:
+			stream.ClientChunks++
+		case reassembly.TCPDirServerToClient:
+			stream.ServerChunks++
+		}
 		if dir != wantDir {
 			segmentation = append(segmentation, 0)
 			wantDir = wantDir.Reverse()
@@ -401,6 +450,7 @@ func (w *Writer) AddStream(s *streams.Stream, streamID uint64) (bool, error) {
 	}
 
 	w.streams = append(w.streams, stream)
+	w.sketches = append(w.sketches, sketches)
 	return true, nil
 }
 
@@ -547,6 +597,13 @@ func (w *Writer) Finalize() (*Reader, error) {
 		return nil, err
 	}
 
+	// write sketches
+	if err := writeSection(sectionStreamSketches, func() error {
+		return w.write(w.sketches)
+	}); err != nil {
+		return nil, err
+	}
+
 	//write lookups
 	writeLookup := func(section section, less func(a, b *stream) bool) error {
 		l := make([]uint32, len(w.streams))
@@ -592,6 +649,27 @@ func (w *Writer) Finalize() (*Reader, error) {
 	}); err != nil {
 		return nil, err
 	}
+	if err := writeLookup(sectionStreamsByClientHost, func(a, b *stream) bool {
+		if a.HostGroup != b.HostGroup {
+			return a.HostGroup < b.HostGroup
+		}
+		return a.ClientHost < b.ClientHost
+	}); err != nil {
+		return nil, err
+	}
+	if err := writeLookup(sectionStreamsByServerHost, func(a, b *stream) bool {
+		if a.HostGroup != b.HostGroup {
+			return a.HostGroup < b.HostGroup
+		}
+		return a.ServerHost < b.ServerHost
+	}); err != nil {
+		return nil, err
+	}
+	if err := writeLookup(sectionStreamsByServerPort, func(a, b *stream) bool {
+		return a.ServerPort < b.ServerPort
+	}); err != nil {
+		return nil, err
+	}
 
 	if err := w.buffer.Flush(); err != nil {
 		w.Close()
@@ -634,6 +712,17 @@ func (w *Writer) AddIndex(r *Reader) (bool, error) {
 		undos = append(undos, f)
 	}
 
+	// don't grow beyond the maximum size, but always accept the first index
+	if w.maxSize != 0 && len(w.streams) != 0 {
+		sz, err := w.estimatedSize()
+		if err != nil {
+			return false, err
+		}
+		if sz+r.Size() > w.maxSize {
+			return false, nil
+		}
+	}
+
 	// merge imports
 	importRemap := []uint32{}
 	importCountBefore := len(w.imports)
@@ -734,12 +823,13 @@ func (w *Writer) AddIndex(r *Reader) (bool, error) {
 	}
 	undoable(func() {
 		w.streams = w.streams[:streamCountBefore]
+		w.sketches = w.sketches[:streamCountBefore]
 		w.packets = w.packets[:packetCountBefore]
 		w.buffer.Flush()
 		//nolint:errcheck
 		w.file.Seek(int64(dataPosBefore), io.SeekStart)
 	})
-	sr := io.NewSectionReader(r.file, int64(r.header.Sections[sectionData].Begin), r.header.Sections[sectionData].size())
+	sr := r.sectionReader(sectionData)
 	br := seekbufio.NewSeekableBufferReader(sr)
 	minFirstPacketTimeNS := uint64(math.MaxUint64)
 	for sIdx, sCount := 0, r.StreamCount(); sIdx < sCount; sIdx++ {
@@ -821,10 +911,17 @@ func (w *Writer) AddIndex(r *Reader) (bool, error) {
 			}
 		}
 
+		sketches, err := r.sketchesByIndex(uint32(sIdx))
+		if err != nil {
+			undo()
+			return false, err
+		}
+
 		if minFirstPacketTimeNS > newStream.FirstPacketTimeNS {
 			minFirstPacketTimeNS = newStream.FirstPacketTimeNS
 		}
 		w.streams = append(w.streams, newStream)
+		w.sketches = append(w.sketches, *sketches)
 	}
 
 	if len(w.streams) == streamCountBefore {
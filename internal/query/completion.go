@@ -0,0 +1,288 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type (
+	CompletionValueType uint8
+
+	// Completions contains the suggested replacements for the part of the
+	// query between Start and End, both counted in characters.
+	Completions struct {
+		Start, End  int
+		Suggestions []string
+	}
+)
+
+const (
+	// CompletionValueTags are the full names of all tags, e.g. `service/foo`.
+	CompletionValueTags CompletionValueType = iota
+	CompletionValueConverters
+	CompletionValueHosts
+	CompletionValuePorts
+	CompletionValueHostSets
+
+	// maximum number of suggestions returned by Complete
+	maxCompletions = 100
+)
+
+var (
+	completionSubQueryRegex = regexp.MustCompile(`^@[a-zA-Z0-9]+:`)
+
+	// keywords followed by a value, as defined by the lexer
+	completionKeys = completionKeywords("Key", "SortKey", "LimitKey", "GroupKey", "SampleKey")
+	// keywords connecting terms, as defined by the lexer
+	completionOperators = completionKeywords("OperatorOr", "OperatorAnd", "OperatorThen")
+)
+
+// completionKeywords returns all words matched by the lexer rules with the
+// given names, the rules have to match a finite set of words.
+func completionKeywords(rules ...string) []string {
+	res := []string(nil)
+	for _, r := range queryLexerRules {
+		if !slices.Contains(rules, r.Name) {
+			continue
+		}
+		re, err := syntax.Parse(r.Pattern, syntax.Perl)
+		if err != nil {
+			panic(err)
+		}
+		words, ok := finiteRegexWords(re.Simplify())
+		if !ok {
+			panic(fmt.Sprintf("lexer rule %q matches infinite words", r.Name))
+		}
+		for _, w := range words {
+			// skip the non ascii variants added by case folding
+			if w = strings.ToLower(w); strings.IndexFunc(w, func(r rune) bool {
+				return r > unicode.MaxASCII
+			}) < 0 {
+				res = append(res, w)
+			}
+		}
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// finiteRegexWords returns all words matched by the regex, false is returned
+// if the regex uses unsupported constructs like repetitions.
+func finiteRegexWords(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}, true
+	case syntax.OpCharClass:
+		res := []string(nil)
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i+1]-re.Rune[i] > 255 {
+				return nil, false
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				res = append(res, string(r))
+			}
+		}
+		return res, true
+	case syntax.OpCapture:
+		return finiteRegexWords(re.Sub[0])
+	case syntax.OpQuest:
+		sub, ok := finiteRegexWords(re.Sub[0])
+		return append([]string{""}, sub...), ok
+	case syntax.OpAlternate:
+		res := []string(nil)
+		for _, s := range re.Sub {
+			sub, ok := finiteRegexWords(s)
+			if !ok {
+				return nil, false
+			}
+			res = append(res, sub...)
+		}
+		return res, true
+	case syntax.OpConcat:
+		res := []string{""}
+		for _, s := range re.Sub {
+			sub, ok := finiteRegexWords(s)
+			if !ok {
+				return nil, false
+			}
+			prod := make([]string, 0, len(res)*len(sub))
+			for _, a := range res {
+				for _, b := range sub {
+					prod = append(prod, a+b)
+				}
+			}
+			res = prod
+		}
+		return res, true
+	}
+	return nil, false
+}
+
+// Complete returns suggestions for the token of the query q ending at the
+// character position cursor. values is called to look up the suggested
+// values of the different keys, they are suggested in the returned order.
+func Complete(q string, cursor int, values func(CompletionValueType) ([]string, error)) (*Completions, error) {
+	if cursor < 0 || cursor > utf8.RuneCountInString(q) {
+		return nil, fmt.Errorf("cursor %d outside of the query", cursor)
+	}
+	prefix := string([]rune(q)[:cursor])
+	res := &Completions{
+		Start:       cursor,
+		End:         cursor,
+		Suggestions: []string{},
+	}
+	if strings.Count(prefix, `"`)%2 != 0 {
+		// no suggestions within quoted values
+		return res, nil
+	}
+	start := strings.LastIndexAny(prefix, " \t\n\r(") + 1
+	for start < len(prefix) && strings.ContainsRune("!-", rune(prefix[start])) {
+		start++
+	}
+	if m := completionSubQueryRegex.FindString(prefix[start:]); m != "" {
+		start += len(m)
+	}
+	word := prefix[start:]
+	previousTerm := strings.TrimRight(prefix[:start], " \t\n\r(!-") != ""
+
+	suggestions := []string(nil)
+	add := func(word string, candidates []string, suffix string, ignoreCase bool) {
+		for _, c := range candidates {
+			if ignoreCase && strings.HasPrefix(strings.ToLower(c), strings.ToLower(word)) || !ignoreCase && strings.HasPrefix(c, word) {
+				suggestions = append(suggestions, c+suffix)
+			}
+		}
+	}
+
+	sep := strings.IndexAny(word, ":=")
+	if sep < 0 {
+		if dot := strings.IndexRune(word, '.'); dot >= 0 {
+			// converter name of a data filter
+			key := strings.ToLower(word[:dot])
+			if key != "data" && key != "cdata" && key != "sdata" {
+				return res, nil
+			}
+			converters, err := values(CompletionValueConverters)
+			if err != nil {
+				return nil, err
+			}
+			converters = append(slices.Clip(converters), "none")
+			start += dot + 1
+			name := word[dot+1:]
+			modeSet := false
+			for {
+				modifier, rest, ok := strings.Cut(name, ".")
+				if !ok {
+					break
+				}
+				modifier = strings.ToLower(modifier)
+				if _, isMode := dataValueModes[modifier]; isMode {
+					modeSet = true
+				} else if _, _, isAnchor := dataAnchorModifier(modifier); !isAnchor {
+					break
+				}
+				name = rest
+				start += len(modifier) + 1
+			}
+			// only one mode may be used per data filter
+			if !modeSet && !strings.Contains(name, ".") {
+				for m := range dataValueModes {
+					converters = append(converters, m)
+				}
+				slices.Sort(converters[len(converters)-len(dataValueModes):])
+			}
+			add(name, converters, ":", false)
+		} else {
+			add(word, completionKeys, ":", true)
+			if previousTerm {
+				add(word, completionOperators, "", true)
+			}
+			slices.Sort(suggestions)
+		}
+	} else {
+		key := strings.ToLower(word[:sep])
+		if dot := strings.IndexRune(key, '.'); dot >= 0 {
+			key = key[:dot]
+		}
+		value := word[sep+1:]
+		elementStart := strings.LastIndexAny(value, ",") + 1
+		switch key {
+		case "cport", "sport", "port":
+			elementStart = strings.LastIndexAny(value, ",:") + 1
+		case "sort":
+			for elementStart < len(value) && strings.ContainsRune(" -", rune(value[elementStart])) {
+				elementStart++
+			}
+		}
+		element := value[elementStart:]
+		start += sep + 1 + elementStart
+		switch key {
+		case "tag", "service", "mark", "generated":
+			tags, err := values(CompletionValueTags)
+			if err != nil {
+				return nil, err
+			}
+			names := []string(nil)
+			for _, t := range tags {
+				if n, ok := strings.CutPrefix(t, key+"/"); ok {
+					names = append(names, n)
+				}
+			}
+			add(element, names, "", false)
+		case "cport", "sport", "port":
+			ports, err := values(CompletionValuePorts)
+			if err != nil {
+				return nil, err
+			}
+			add(element, ports, "", false)
+		case "chost", "shost", "host":
+			hosts, err := values(CompletionValueHosts)
+			if err != nil {
+				return nil, err
+			}
+			hostSets, err := values(CompletionValueHostSets)
+			if err != nil {
+				return nil, err
+			}
+			for _, hs := range hostSets {
+				hosts = append(slices.Clip(hosts), "@"+hs)
+			}
+			add(element, hosts, "", true)
+		case "cteam", "steam", "team":
+			hostSets, err := values(CompletionValueHostSets)
+			if err != nil {
+				return nil, err
+			}
+			teams := []string(nil)
+			for _, hs := range hostSets {
+				if t, ok := strings.CutPrefix(hs, TeamHostSetName("")); ok && t != "" {
+					teams = append(teams, t)
+				}
+			}
+			add(element, teams, "", false)
+		case "protocol":
+			add(element, []string{"tcp", "udp", "sctp", "other"}, "", true)
+		case "sort":
+			keys := []string(nil)
+			for k := range sortingKeys {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			add(element, keys, "", true)
+		}
+	}
+
+	if len(suggestions) > maxCompletions {
+		suggestions = suggestions[:maxCompletions]
+	}
+	res.Start = utf8.RuneCountInString(prefix[:start])
+	res.Suggestions = append(res.Suggestions, suggestions...)
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package query
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	values := func(vt CompletionValueType) ([]string, error) {
+		return map[CompletionValueType][]string{
+			CompletionValueTags:       {"service/web", "service/wiki", "tag/flag", "tag/ünicode"},
+			CompletionValueConverters: {"http", "websocket"},
+			CompletionValueHosts:      {"10.0.0.1", "10.0.0.2"},
+			CompletionValuePorts:      {"80", "8080", "443"},
+			CompletionValueHostSets:   {"team1", "team2", "gameserver"},
+		}[vt], nil
+	}
+	for _, tc := range []struct {
+		query       string
+		cursor      int
+		start, end  int
+		suggestions []string
+	}{
+		{"sp", 2, 0, 2, []string{"spackets:", "sport:", "sprefixhash:", "sprintable:"}},
+		{"service:web", 3, 0, 3, []string{"service:"}},
+		// operators are only suggested after a term
+		{"th", 2, 0, 2, []string{}},
+		{"sport:80 t", 10, 9, 10, []string{"tag:", "team:", "then", "tick:", "time:"}},
+		{"sport:80 OR", 11, 9, 11, []string{"or"}},
+		// positions are counted in characters
+		{"ä sport:8", 9, 8, 9, []string{"80", "8080"}},
+		{"äö service:w", 12, 11, 12, []string{"web", "wiki"}},
+		{"tag:ü", 5, 4, 5, []string{"ünicode"}},
+		// no suggestions within quoted values
+		{`cdata:"foo ser`, 14, 14, 14, []string{}},
+		{`cdata:"a "" b" ser`, 18, 15, 18, []string{"service:"}},
+		// sub query prefixes and negations are skipped
+		{"@sub:ser", 8, 5, 8, []string{"service:"}},
+		{"-@sub:service:w", 15, 14, 15, []string{"web", "wiki"}},
+		{"(!tag:f", 7, 6, 7, []string{"flag"}},
+		// converters and modifiers of data filters
+		{"data.", 5, 5, 5, []string{"http:", "websocket:", "none:", "hex:", "i:", "lit:"}},
+		{"cdata.h", 7, 6, 7, []string{"http:", "hex:"}},
+		{"cdata.hex.", 10, 10, 10, []string{"http:", "websocket:", "none:"}},
+		{"sdata.chunk1.at2.", 17, 17, 17, []string{"http:", "websocket:", "none:", "hex:", "i:", "lit:"}},
+		{"sdata.HEX.chunk1.w", 18, 17, 18, []string{"websocket:"}},
+		{"sdata.http.x", 12, 6, 12, []string{}},
+		{"foo.x", 5, 5, 5, []string{}},
+		// values of the different keys
+		{"host:@", 6, 5, 6, []string{"@team1", "@team2", "@gameserver"}},
+		{"chost:10.0.0.1,10", 17, 15, 17, []string{"10.0.0.1", "10.0.0.2"}},
+		{"team:", 5, 5, 5, []string{"1", "2"}},
+		{"cport:1:8", 9, 8, 9, []string{"80", "8080"}},
+		{"sort:-c", 7, 6, 7, []string{"cbytes", "cchunks", "centropy", "chash", "chost", "chunks", "cpackets", "cport", "cprefixhash", "cprintable"}},
+		{"sort:id,f", 9, 8, 9, []string{"ftime"}},
+		{"protocol:T", 10, 9, 10, []string{"tcp"}},
+		{"cdata:foo", 9, 6, 9, []string{}},
+	} {
+		got, err := Complete(tc.query, tc.cursor, values)
+		if err != nil {
+			t.Errorf("Complete(%q, %d) failed with error: %v", tc.query, tc.cursor, err)
+			continue
+		}
+		if got.Start != tc.start || got.End != tc.end || !slices.Equal(got.Suggestions, tc.suggestions) {
+			t.Errorf("Complete(%q, %d) = %+v, want {Start:%d End:%d Suggestions:%v}", tc.query, tc.cursor, *got, tc.start, tc.end, tc.suggestions)
+		}
+	}
+	for _, cursor := range []int{-1, 3} {
+		if _, err := Complete("äö", cursor, values); err == nil {
+			t.Errorf("Complete(\"äö\", %d) succeeded, want error", cursor)
+		}
+	}
+	if _, err := Complete("tag:", 4, func(CompletionValueType) ([]string, error) {
+		return nil, errors.New("failed")
+	}); err == nil {
+		t.Errorf("Complete with failing values succeeded, want error")
+	}
+}
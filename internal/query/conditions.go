@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"path"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -17,15 +19,27 @@ import (
 type (
 	NumberConditionSummandType uint8
 	HostConditionSourceType    bool
+	HashConditionSourceType    uint8
+	SimilarityConditionType    uint8
 	TagConditionAccept         uint8
 )
 
 const (
-	NumberConditionSummandTypeID          NumberConditionSummandType = iota
-	NumberConditionSummandTypeClientBytes NumberConditionSummandType = iota
-	NumberConditionSummandTypeServerBytes NumberConditionSummandType = iota
-	NumberConditionSummandTypeClientPort  NumberConditionSummandType = iota
-	NumberConditionSummandTypeServerPort  NumberConditionSummandType = iota
+	NumberConditionSummandTypeID            NumberConditionSummandType = iota
+	NumberConditionSummandTypeClientBytes   NumberConditionSummandType = iota
+	NumberConditionSummandTypeServerBytes   NumberConditionSummandType = iota
+	NumberConditionSummandTypeClientPort    NumberConditionSummandType = iota
+	NumberConditionSummandTypeServerPort    NumberConditionSummandType = iota
+	NumberConditionSummandTypeClientPackets NumberConditionSummandType = iota
+	NumberConditionSummandTypeServerPackets NumberConditionSummandType = iota
+	NumberConditionSummandTypeClientChunks  NumberConditionSummandType = iota
+	NumberConditionSummandTypeServerChunks  NumberConditionSummandType = iota
+	// entropy in thousandths of a bit per byte
+	NumberConditionSummandTypeClientEntropy NumberConditionSummandType = iota
+	NumberConditionSummandTypeServerEntropy NumberConditionSummandType = iota
+	// ratio of printable bytes in thousandths
+	NumberConditionSummandTypeClientPrintable NumberConditionSummandType = iota
+	NumberConditionSummandTypeServerPrintable NumberConditionSummandType = iota
 
 	HostConditionSourceTypeClient HostConditionSourceType = false
 	HostConditionSourceTypeServer HostConditionSourceType = true
@@ -45,6 +59,24 @@ const (
 	TagConditionAcceptUncertainFailing  TagConditionAccept = 0b1000
 )
 
+const (
+	HashConditionSourceTypeClient HashConditionSourceType = iota
+	HashConditionSourceTypeServer
+	HashConditionSourceTypeClientPrefix
+	HashConditionSourceTypeServerPrefix
+)
+
+const (
+	SimilarityConditionTypeClient SimilarityConditionType = iota
+	SimilarityConditionTypeServer
+	SimilarityConditionTypeBoth
+)
+
+const (
+	// threshold used when the similarity filter does not specify one
+	DefaultSimilarityThreshold = 0.8
+)
+
 type (
 	HostConditionSource struct {
 		SubQuery string
@@ -57,6 +89,32 @@ type (
 		Mask6                net.IP
 		Invert               bool
 	}
+	HostSetCondition struct {
+		// this is fulfilled, when the Type host is contained in the host set Name, inverted if Invert is set
+		SubQuery string
+		Type     HostConditionSourceType
+		Name     string
+		Invert   bool
+	}
+	HashConditionSource struct {
+		SubQuery string
+		Type     HashConditionSourceType
+	}
+	HashCondition struct {
+		// this is fulfilled, when xored(i.Hash for i in HashConditionSources) ^ Hash == 0, inverted if Invert is set
+		HashConditionSources []HashConditionSource
+		Hash                 uint64
+		Invert               bool
+	}
+	SimilarityCondition struct {
+		// this is fulfilled, when the estimated similarity of the Type data of the
+		// stream and the stream with ID StreamID is at least Threshold, inverted if Invert is set
+		SubQuery  string
+		Type      SimilarityConditionType
+		StreamID  uint64
+		Threshold float64
+		Invert    bool
+	}
 	TagCondition struct {
 		// this is fulfilled, when
 		SubQuery string
@@ -85,6 +143,30 @@ type (
 		Duration            time.Duration
 		ReferenceTimeFactor int
 	}
+	TickCondition struct {
+		// this is fulfilled, when the tick of the first packet of the stream is >= Tick, <= Tick if Upper is set.
+		// Relative ticks are counted from the tick containing the reference time.
+		SubQuery string
+		Tick     int
+		Relative bool
+		Upper    bool
+	}
+	PcapCondition struct {
+		// this is fulfilled, when the name of the pcap containing the first packet of the stream
+		// matches one of the glob Patterns, inverted if Invert is set
+		SubQuery string
+		Patterns []string
+		Invert   bool
+	}
+	ImportCondition struct {
+		// this is fulfilled, when the pcap containing the first packet of the stream was imported
+		// as pcap number >= Import, <= Import if Upper is set. Pcaps are numbered in the order of
+		// their import starting at 0, relative numbers are counted from the end of the list.
+		SubQuery string
+		Import   int
+		Relative bool
+		Upper    bool
+	}
 	NumberCondition struct {
 		// this is fulfilled, when Number+X >= 0
 		Summands []NumberConditionSummand
@@ -95,12 +177,33 @@ type (
 		SubQuery string
 		Name     string
 	}
+	// DataConditionTiming restricts the time between the chunk containing
+	// the end of the match of the previous element and the chunk containing
+	// the start of the match of the element, both bounds are inclusive.
+	DataConditionTiming struct {
+		Min time.Duration
+		// Max is math.MaxInt64 if there is no upper bound
+		Max time.Duration
+	}
+	// DataConditionAnchor restricts where the regex of an element may match.
+	DataConditionAnchor struct {
+		// Chunk is the index of the chunk of the direction the match has
+		// to be in, -1 if the match may be in any chunk
+		Chunk int
+		// Offset is the position at which the match has to start, relative
+		// to the chunk if Chunk is set, -1 if the match may start anywhere
+		Offset int
+	}
 	DataConditionElement struct {
 		SubQuery      string
 		Regex         string
 		Variables     []DataConditionElementVariable
 		Flags         uint8
 		ConverterName string
+		// Timing is nil if the time since the previous element is not restricted
+		Timing *DataConditionTiming
+		// Anchor is nil if the element may match anywhere
+		Anchor *DataConditionAnchor
 	}
 	DataCondition struct {
 		Elements []DataConditionElement
@@ -119,6 +222,16 @@ type (
 
 var (
 	impossibleCondition = ImpossibleCondition{}
+
+	errTimingWithoutData = errors.New("`then` timing constraints require data filters on both sides")
+
+	// number keys stored in thousandths
+	numberRatioKeys = map[string]NumberConditionSummandType{
+		"centropy":   NumberConditionSummandTypeClientEntropy,
+		"sentropy":   NumberConditionSummandTypeServerEntropy,
+		"cprintable": NumberConditionSummandTypeClientPrintable,
+		"sprintable": NumberConditionSummandTypeServerPrintable,
+	}
 )
 
 func (qcs ConditionsSet) String() string {
@@ -196,6 +309,49 @@ func (c *HostCondition) String() string {
 	return fmt.Sprintf("%s %s %s/%s or %s", strings.Join(res, " ^ "), equals, c.Host.String(), c.Mask4.String(), c.Mask6.String())
 }
 
+func (c *HostSetCondition) String() string {
+	colon := map[bool]string{false: ":", true: ""}[c.SubQuery == ""]
+	host := map[HostConditionSourceType]string{
+		HostConditionSourceTypeClient: "chost",
+		HostConditionSourceTypeServer: "shost",
+	}[c.Type]
+	in := map[bool]string{false: "in", true: "not in"}[c.Invert]
+	return fmt.Sprintf("%s%s%s %s @%s", c.SubQuery, colon, host, in, c.Name)
+}
+
+func (t HashConditionSourceType) String() string {
+	for k, v := range hashConditionSourceTypes {
+		if v == t {
+			return k
+		}
+	}
+	return ""
+}
+
+func (t SimilarityConditionType) String() string {
+	return map[SimilarityConditionType]string{
+		SimilarityConditionTypeClient: "csimilar",
+		SimilarityConditionTypeServer: "ssimilar",
+		SimilarityConditionTypeBoth:   "similar",
+	}[t]
+}
+
+func (c *SimilarityCondition) String() string {
+	colon := map[bool]string{false: ":", true: ""}[c.SubQuery == ""]
+	cmp := map[bool]string{false: ">=", true: "<"}[c.Invert]
+	return fmt.Sprintf("%s%s%s(id:%d) %s %g", c.SubQuery, colon, c.Type, c.StreamID, cmp, c.Threshold)
+}
+
+func (c *HashCondition) String() string {
+	res := []string(nil)
+	for _, hcs := range c.HashConditionSources {
+		colon := map[bool]string{false: ":", true: ""}[hcs.SubQuery == ""]
+		res = append(res, fmt.Sprintf("%s%s%s", hcs.SubQuery, colon, hcs.Type))
+	}
+	equals := map[bool]string{false: "==", true: "!="}[c.Invert]
+	return fmt.Sprintf("%s %s %016x", strings.Join(res, " ^ "), equals, c.Hash)
+}
+
 func (c *TimeCondition) String() string {
 	res := []string(nil)
 	for _, s := range c.Summands {
@@ -249,6 +405,32 @@ func (c *TimeCondition) String() string {
 	return fmt.Sprintf("%s >= 0", strings.Join(res, ""))
 }
 
+func (c *TickCondition) String() string {
+	colon := map[bool]string{false: ":", true: ""}[c.SubQuery == ""]
+	op := map[bool]string{false: ">=", true: "<="}[c.Upper]
+	tick := fmt.Sprintf("%d", c.Tick)
+	if c.Relative {
+		tick = fmt.Sprintf("now%+d", c.Tick)
+	}
+	return fmt.Sprintf("%s%stick %s %s", c.SubQuery, colon, op, tick)
+}
+
+func (c *PcapCondition) String() string {
+	colon := map[bool]string{false: ":", true: ""}[c.SubQuery == ""]
+	in := map[bool]string{false: "in", true: "not in"}[c.Invert]
+	return fmt.Sprintf("%s%spcap %s [%s]", c.SubQuery, colon, in, strings.Join(c.Patterns, ","))
+}
+
+func (c *ImportCondition) String() string {
+	colon := map[bool]string{false: ":", true: ""}[c.SubQuery == ""]
+	op := map[bool]string{false: ">=", true: "<="}[c.Upper]
+	imp := fmt.Sprintf("%d", c.Import)
+	if c.Relative {
+		imp = fmt.Sprintf("end%+d", c.Import)
+	}
+	return fmt.Sprintf("%s%simport %s %s", c.SubQuery, colon, op, imp)
+}
+
 func (c *NumberCondition) String() string {
 	res := []string(nil)
 	for _, s := range c.Summands {
@@ -271,11 +453,19 @@ func (c *NumberCondition) String() string {
 			prefix = "+"
 		}
 		name := map[NumberConditionSummandType]string{
-			NumberConditionSummandTypeID:          "id",
-			NumberConditionSummandTypeClientPort:  "cport",
-			NumberConditionSummandTypeServerPort:  "sport",
-			NumberConditionSummandTypeClientBytes: "cbytes",
-			NumberConditionSummandTypeServerBytes: "sbytes",
+			NumberConditionSummandTypeID:              "id",
+			NumberConditionSummandTypeClientPort:      "cport",
+			NumberConditionSummandTypeServerPort:      "sport",
+			NumberConditionSummandTypeClientBytes:     "cbytes",
+			NumberConditionSummandTypeServerBytes:     "sbytes",
+			NumberConditionSummandTypeClientPackets:   "cpackets",
+			NumberConditionSummandTypeServerPackets:   "spackets",
+			NumberConditionSummandTypeClientChunks:    "cchunks",
+			NumberConditionSummandTypeServerChunks:    "schunks",
+			NumberConditionSummandTypeClientEntropy:   "centropy",
+			NumberConditionSummandTypeServerEntropy:   "sentropy",
+			NumberConditionSummandTypeClientPrintable: "cprintable",
+			NumberConditionSummandTypeServerPrintable: "sprintable",
 		}[s.Type]
 		res = append(res, fmt.Sprintf("%s%s%s%s", prefix, sq, name, suffix))
 	}
@@ -291,8 +481,30 @@ func (c *NumberCondition) String() string {
 	return fmt.Sprintf("%s >= 0", strings.Join(res, ""))
 }
 
-func (c *DataCondition) String() string {
+func (t *DataConditionTiming) String() string {
 	res := []string(nil)
+	if t.Min != 0 {
+		res = append(res, fmt.Sprintf(">=%s", t.Min))
+	}
+	if t.Max != math.MaxInt64 {
+		res = append(res, fmt.Sprintf("<=%s", t.Max))
+	}
+	return strings.Join(res, ",")
+}
+
+func (a *DataConditionAnchor) String() string {
+	res := ""
+	if a.Chunk != -1 {
+		res += fmt.Sprintf(".chunk%d", a.Chunk)
+	}
+	if a.Offset != -1 {
+		res += fmt.Sprintf(".at%d", a.Offset)
+	}
+	return res
+}
+
+func (c *DataCondition) String() string {
+	res := ""
 
 	for i, e := range c.Elements {
 		inv := map[bool]string{false: "", true: "-"}[c.Inverted && (i == len(c.Elements)-1)]
@@ -308,10 +520,19 @@ func (c *DataCondition) String() string {
 		if fltr != "" {
 			fltr = "." + fltr
 		}
+		if e.Anchor != nil {
+			fltr = e.Anchor.String() + fltr
+		}
 
-		res = append(res, fmt.Sprintf("%s%s%s%s:%q", inv, sq, who, fltr, e.Regex))
+		if i != 0 {
+			res += " > "
+			if e.Timing != nil {
+				res = fmt.Sprintf("%s[%s] ", res[:len(res)-1], e.Timing)
+			}
+		}
+		res += fmt.Sprintf("%s%s%s%s:%q", inv, sq, who, fltr, e.Regex)
 	}
-	return strings.Join(res, " > ")
+	return res
 }
 
 func (c *ImpossibleCondition) String() string {
@@ -330,10 +551,34 @@ func (c *HostCondition) impossible() bool {
 	return false
 }
 
+func (c *HostSetCondition) impossible() bool {
+	return false
+}
+
+func (c *HashCondition) impossible() bool {
+	return false
+}
+
+func (c *SimilarityCondition) impossible() bool {
+	return false
+}
+
 func (c *TimeCondition) impossible() bool {
 	return false
 }
 
+func (c *TickCondition) impossible() bool {
+	return false
+}
+
+func (c *PcapCondition) impossible() bool {
+	return false
+}
+
+func (c *ImportCondition) impossible() bool {
+	return false
+}
+
 func (c *NumberCondition) impossible() bool {
 	return false
 }
@@ -379,6 +624,21 @@ func (c *HostCondition) equal(d Condition) bool {
 	return true
 }
 
+func (c *HostSetCondition) equal(d Condition) bool {
+	o, ok := d.(*HostSetCondition)
+	return ok && *c == *o
+}
+
+func (c *HashCondition) equal(d Condition) bool {
+	o, ok := d.(*HashCondition)
+	return ok && c.Hash == o.Hash && c.Invert == o.Invert && slices.Equal(c.HashConditionSources, o.HashConditionSources)
+}
+
+func (c *SimilarityCondition) equal(d Condition) bool {
+	o, ok := d.(*SimilarityCondition)
+	return ok && *c == *o
+}
+
 func (c *TimeCondition) equal(d Condition) bool {
 	o, ok := d.(*TimeCondition)
 	if !(ok && c.Duration == o.Duration && c.ReferenceTimeFactor == o.ReferenceTimeFactor && len(c.Summands) == len(o.Summands)) {
@@ -392,6 +652,21 @@ func (c *TimeCondition) equal(d Condition) bool {
 	return true
 }
 
+func (c *TickCondition) equal(d Condition) bool {
+	o, ok := d.(*TickCondition)
+	return ok && *c == *o
+}
+
+func (c *PcapCondition) equal(d Condition) bool {
+	o, ok := d.(*PcapCondition)
+	return ok && c.SubQuery == o.SubQuery && c.Invert == o.Invert && slices.Equal(c.Patterns, o.Patterns)
+}
+
+func (c *ImportCondition) equal(d Condition) bool {
+	o, ok := d.(*ImportCondition)
+	return ok && *c == *o
+}
+
 func (c *NumberCondition) equal(d Condition) bool {
 	o, ok := d.(*NumberCondition)
 	if !(ok && c.Number == o.Number && len(c.Summands) == len(o.Summands)) {
@@ -421,6 +696,12 @@ func (c *DataCondition) equal(d Condition) bool {
 		if !(ce.Flags == oe.Flags && ce.ConverterName == oe.ConverterName && ce.Regex == oe.Regex && ce.SubQuery == oe.SubQuery && len(ce.Variables) == len(oe.Variables)) {
 			return false
 		}
+		if (ce.Timing == nil) != (oe.Timing == nil) || ce.Timing != nil && *ce.Timing != *oe.Timing {
+			return false
+		}
+		if (ce.Anchor == nil) != (oe.Anchor == nil) || ce.Anchor != nil && *ce.Anchor != *oe.Anchor {
+			return false
+		}
 		for j := 0; j < len(ce.Variables); j++ {
 			if ce.Variables[j] != oe.Variables[j] {
 				return false
@@ -476,6 +757,27 @@ func (c *HostCondition) invert() ConditionsSet {
 	}}}
 }
 
+func (c *HostSetCondition) invert() ConditionsSet {
+	res := *c
+	res.Invert = !res.Invert
+	return ConditionsSet{Conditions{&res}}
+}
+
+func (c *HashCondition) invert() ConditionsSet {
+	return ConditionsSet{Conditions{&HashCondition{
+		// the sources are modified in place when cleaning the conditions
+		HashConditionSources: slices.Clone(c.HashConditionSources),
+		Hash:                 c.Hash,
+		Invert:               !c.Invert,
+	}}}
+}
+
+func (c *SimilarityCondition) invert() ConditionsSet {
+	res := *c
+	res.Invert = !res.Invert
+	return ConditionsSet{Conditions{&res}}
+}
+
 func (c *TimeCondition) invert() ConditionsSet {
 	// !(n >= 0) -> -n-1 >= 0
 	cond := TimeCondition{
@@ -493,6 +795,36 @@ func (c *TimeCondition) invert() ConditionsSet {
 	return ConditionsSet{Conditions{&cond}}
 }
 
+func (c *TickCondition) invert() ConditionsSet {
+	// !(t >= n) -> t <= n-1, !(t <= n) -> t >= n+1
+	res := *c
+	res.Upper = !res.Upper
+	if c.Upper {
+		res.Tick++
+	} else {
+		res.Tick--
+	}
+	return ConditionsSet{Conditions{&res}}
+}
+
+func (c *PcapCondition) invert() ConditionsSet {
+	res := *c
+	res.Invert = !res.Invert
+	return ConditionsSet{Conditions{&res}}
+}
+
+func (c *ImportCondition) invert() ConditionsSet {
+	// !(i >= n) -> i <= n-1, !(i <= n) -> i >= n+1
+	res := *c
+	res.Upper = !res.Upper
+	if c.Upper {
+		res.Import++
+	} else {
+		res.Import--
+	}
+	return ConditionsSet{Conditions{&res}}
+}
+
 func (c *NumberCondition) invert() ConditionsSet {
 	// !(n >= 0) -> -n-1 >= 0
 	cond := NumberCondition{
@@ -594,6 +926,17 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 		}[t.Key]
 		for _, fType := range fTypes {
 			for _, e := range val.List {
+				if e.HostSet != nil {
+					if e.Masks != nil {
+						return nil, fmt.Errorf("masks not supported for host set %q", e.HostSet.Name)
+					}
+					conds = append(conds, Conditions{&HostSetCondition{
+						SubQuery: t.SubQuery,
+						Type:     fType,
+						Name:     e.HostSet.Name,
+					}})
+					continue
+				}
 				cond := &HostCondition{
 					HostConditionSources: []HostConditionSource{{
 						Type:     fType,
@@ -615,26 +958,88 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 						Type:     vType,
 					})
 				}
-				if e.Masks != nil {
-					cond.Mask4 = e.Masks.V4Mask
-					cond.Mask6 = e.Masks.V6Mask
-				} else {
-					cond.Mask4 = net.IP{
-						255, 255, 255, 255,
-					}
-					cond.Mask6 = net.IP{
-						255, 255, 255, 255, 255, 255, 255, 255,
-						255, 255, 255, 255, 255, 255, 255, 255,
-					}
-				}
+				cond.setMasks(e.Masks)
 				conds = append(conds, Conditions{cond})
 			}
 		}
-	case "id", "cport", "sport", "port", "cbytes", "sbytes", "bytes":
+	case "cteam", "steam", "team":
+		fTypes := map[string][]HostConditionSourceType{
+			"cteam": {HostConditionSourceTypeClient},
+			"steam": {HostConditionSourceTypeServer},
+			"team":  {HostConditionSourceTypeClient, HostConditionSourceTypeServer},
+		}[t.Key]
+		for _, fType := range fTypes {
+			for _, team := range strings.Split(t.Value, ",") {
+				name := TeamHostSetName(strings.TrimSpace(team))
+				if !ValidHostSetName(name) {
+					return nil, fmt.Errorf("invalid team %q", team)
+				}
+				conds = append(conds, Conditions{&HostSetCondition{
+					SubQuery: t.SubQuery,
+					Type:     fType,
+					Name:     name,
+				}})
+			}
+		}
+	case "chash", "shash", "cprefixhash", "sprefixhash":
+		val, err := valueHashListParser.ParseString("", t.Value)
+		if err != nil {
+			return nil, err
+		}
+		fType := hashConditionSourceTypes[t.Key]
+		for _, e := range val.List {
+			cond := &HashCondition{
+				HashConditionSources: []HashConditionSource{{
+					SubQuery: t.SubQuery,
+					Type:     fType,
+				}},
+			}
+			if e.Hash != nil {
+				cond.Hash = e.Hash.Hash
+			} else {
+				vType, ok := hashConditionSourceTypes[e.Variable.Name]
+				if !ok {
+					return nil, fmt.Errorf("unsupported variable type in hash filter: %q", e.Variable.Name)
+				}
+				cond.HashConditionSources = append(cond.HashConditionSources, HashConditionSource{
+					SubQuery: e.Variable.Sub,
+					Type:     vType,
+				})
+			}
+			conds = append(conds, Conditions{cond})
+		}
+	case "csimilar", "ssimilar", "similar":
+		val, err := valueSimilarityListParser.ParseString("", t.Value)
+		if err != nil {
+			return nil, err
+		}
+		fType := map[string]SimilarityConditionType{
+			"csimilar": SimilarityConditionTypeClient,
+			"ssimilar": SimilarityConditionTypeServer,
+			"similar":  SimilarityConditionTypeBoth,
+		}[t.Key]
+		for _, e := range val.List {
+			threshold := DefaultSimilarityThreshold
+			if e.Threshold != nil {
+				threshold = *e.Threshold
+				if threshold < 0 || threshold > 1 {
+					return nil, fmt.Errorf("similarity threshold %v not between 0 and 1", threshold)
+				}
+			}
+			conds = append(conds, Conditions{&SimilarityCondition{
+				SubQuery:  t.SubQuery,
+				Type:      fType,
+				StreamID:  e.StreamID,
+				Threshold: threshold,
+			}})
+		}
+	case "id", "cport", "sport", "port", "cbytes", "sbytes", "bytes", "cpackets", "spackets", "packets", "cchunks", "schunks", "chunks", "centropy", "sentropy", "cprintable", "sprintable":
 		val, err := valueNumberRangeListParser.ParseString("", t.Value)
 		if err != nil {
 			return nil, err
 		}
+		// entropy and printable ratio are stored in thousandths, their values may have decimals
+		_, isRatio := numberRatioKeys[t.Key]
 		for _, e := range val.List {
 			ncs := [2]*NumberCondition{{}, {}}
 			empty := [2]bool{false, false}
@@ -644,18 +1049,37 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 				for _, p := range r.Parts {
 					factor := 1 - (2 * (strings.Count(p.Operators, "-") % 2))
 					if p.Variable == nil {
-						nc.Number += factor * p.Number
+						switch {
+						case !isRatio && p.Decimal != nil:
+							return nil, fmt.Errorf("decimal number not supported in %s filter", t.Key)
+						case !isRatio:
+							nc.Number += factor * p.Number
+						case p.Decimal != nil:
+							nc.Number += factor * int(math.Round(*p.Decimal*1000))
+						default:
+							nc.Number += factor * p.Number * 1000
+						}
 						continue
 					}
 					vType, ok := map[string]NumberConditionSummandType{
-						"id":     NumberConditionSummandTypeID,
-						"cport":  NumberConditionSummandTypeClientPort,
-						"sport":  NumberConditionSummandTypeServerPort,
-						"cbytes": NumberConditionSummandTypeClientBytes,
-						"sbytes": NumberConditionSummandTypeServerBytes,
+						"id":       NumberConditionSummandTypeID,
+						"cport":    NumberConditionSummandTypeClientPort,
+						"sport":    NumberConditionSummandTypeServerPort,
+						"cbytes":   NumberConditionSummandTypeClientBytes,
+						"sbytes":   NumberConditionSummandTypeServerBytes,
+						"cpackets": NumberConditionSummandTypeClientPackets,
+						"spackets": NumberConditionSummandTypeServerPackets,
+						"cchunks":  NumberConditionSummandTypeClientChunks,
+						"schunks":  NumberConditionSummandTypeServerChunks,
 					}[p.Variable.Name]
+					if isRatio {
+						vType, ok = numberRatioKeys[p.Variable.Name]
+					}
+					if !ok && isRatio {
+						return nil, errors.New("only [cs]entropy, [cs]printable variables supported in filter of the same types")
+					}
 					if !ok {
-						return nil, errors.New("only id, [cs]port, [cs]bytes variables supported in filter of the same types")
+						return nil, errors.New("only id, [cs]port, [cs]bytes, [cs]packets, [cs]chunks variables supported in filter of the same types")
 					}
 					for i, sc := 0, len(nc.Summands); i <= sc; i++ {
 						if i == sc {
@@ -680,14 +1104,23 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 				}
 			}
 			fTypes := map[string][]NumberConditionSummandType{
-				"id":     {NumberConditionSummandTypeID},
-				"cport":  {NumberConditionSummandTypeClientPort},
-				"sport":  {NumberConditionSummandTypeServerPort},
-				"port":   {NumberConditionSummandTypeClientPort, NumberConditionSummandTypeServerPort},
-				"cbytes": {NumberConditionSummandTypeClientBytes},
-				"sbytes": {NumberConditionSummandTypeServerBytes},
-				"bytes":  {NumberConditionSummandTypeClientBytes, NumberConditionSummandTypeServerBytes},
+				"id":       {NumberConditionSummandTypeID},
+				"cport":    {NumberConditionSummandTypeClientPort},
+				"sport":    {NumberConditionSummandTypeServerPort},
+				"port":     {NumberConditionSummandTypeClientPort, NumberConditionSummandTypeServerPort},
+				"cbytes":   {NumberConditionSummandTypeClientBytes},
+				"sbytes":   {NumberConditionSummandTypeServerBytes},
+				"bytes":    {NumberConditionSummandTypeClientBytes, NumberConditionSummandTypeServerBytes},
+				"cpackets": {NumberConditionSummandTypeClientPackets},
+				"spackets": {NumberConditionSummandTypeServerPackets},
+				"packets":  {NumberConditionSummandTypeClientPackets, NumberConditionSummandTypeServerPackets},
+				"cchunks":  {NumberConditionSummandTypeClientChunks},
+				"schunks":  {NumberConditionSummandTypeServerChunks},
+				"chunks":   {NumberConditionSummandTypeClientChunks, NumberConditionSummandTypeServerChunks},
 			}[t.Key]
+			if isRatio {
+				fTypes = []NumberConditionSummandType{numberRatioKeys[t.Key]}
+			}
 			ncsCopy := [2]*NumberCondition{
 				ncs[0],
 				ncs[1],
@@ -734,7 +1167,7 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 				conds = append(conds, cond)
 			}
 		}
-	case "ftime", "ltime", "time":
+	case "ftime", "ltime", "time", "duration":
 		val, err := valueTimeRangeListParser.ParseString("", t.Value)
 		if err != nil {
 			return nil, err
@@ -750,6 +1183,9 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 					if p.Duration != nil {
 						tc.Duration += time.Duration(factor) * p.Duration.Duration
 					} else if p.Time != nil {
+						if t.Key == "duration" {
+							return nil, errors.New("only durations and variables supported in duration filters")
+						}
 						t := p.Time.Time
 						d := &t
 						if !p.Time.HasDate {
@@ -783,8 +1219,11 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 								s.FTimeFactor += factor
 							case "ltime":
 								s.LTimeFactor += factor
+							case "duration":
+								s.FTimeFactor -= factor
+								s.LTimeFactor += factor
 							default:
-								return nil, errors.New("only [fl]time variables supported in [fl]?time filters")
+								return nil, errors.New("only [fl]time and duration variables supported in [fl]?time and duration filters")
 							}
 							break
 						}
@@ -814,6 +1253,9 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 						case "time":
 							s.FTimeFactor -= tci
 							s.LTimeFactor -= 1 - tci
+						case "duration":
+							s.FTimeFactor++
+							s.LTimeFactor--
 						}
 						sc--
 					}
@@ -841,7 +1283,107 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 			}
 			conds = append(conds, cond)
 		}
+	case "tick":
+		val, err := valueNumberRangeListParser.ParseString("", t.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range val.List {
+			tcs := [2]*TickCondition{
+				{SubQuery: t.SubQuery},
+				{SubQuery: t.SubQuery, Upper: true},
+			}
+			empty := [2]bool{false, false}
+			for ir, r := range e.Range {
+				empty[ir] = len(r.Parts) == 0
+				if len(r.Parts) == 0 {
+					continue
+				}
+				if len(r.Parts) != 1 || r.Parts[0].Variable != nil || len(r.Parts[0].Operators) > 1 {
+					return nil, errors.New("only absolute and relative tick numbers supported in tick filters")
+				}
+				p := r.Parts[0]
+				tcs[ir].Tick = p.Number
+				// ticks prefixed with a sign are relative to the current tick
+				tcs[ir].Relative = p.Operators != ""
+				if p.Operators == "-" {
+					tcs[ir].Tick *= -1
+				}
+			}
+			if len(e.Range) == 1 {
+				tcs[1].Tick = tcs[0].Tick
+				tcs[1].Relative = tcs[0].Relative
+				empty[1] = empty[0]
+			}
+			cond := Conditions{}
+			if !empty[0] {
+				cond = append(cond, tcs[0])
+			}
+			if !empty[1] {
+				cond = append(cond, tcs[1])
+			}
+			conds = append(conds, cond)
+		}
+	case "pcap":
+		cond := &PcapCondition{
+			SubQuery: t.SubQuery,
+		}
+		for _, v := range strings.Split(t.Value, ",") {
+			v = strings.TrimSpace(v)
+			if _, err := path.Match(v, ""); err != nil {
+				return nil, fmt.Errorf("invalid pcap pattern %q: %w", v, err)
+			}
+			cond.Patterns = append(cond.Patterns, v)
+		}
+		conds = append(conds, Conditions{cond})
+	case "import":
+		val, err := valueNumberRangeListParser.ParseString("", t.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range val.List {
+			ics := [2]*ImportCondition{
+				{SubQuery: t.SubQuery},
+				{SubQuery: t.SubQuery, Upper: true},
+			}
+			empty := [2]bool{false, false}
+			for ir, r := range e.Range {
+				empty[ir] = len(r.Parts) == 0
+				if len(r.Parts) == 0 {
+					continue
+				}
+				if len(r.Parts) != 1 || r.Parts[0].Variable != nil || len(r.Parts[0].Operators) > 1 || r.Parts[0].Operators == "+" {
+					return nil, errors.New("only absolute and negative relative import numbers supported in import filters")
+				}
+				p := r.Parts[0]
+				ics[ir].Import = p.Number
+				// negative imports are counted from the last import
+				if p.Operators == "-" {
+					ics[ir].Import *= -1
+					ics[ir].Relative = true
+				}
+			}
+			if len(e.Range) == 1 {
+				ics[1].Import = ics[0].Import
+				ics[1].Relative = ics[0].Relative
+				empty[1] = empty[0]
+			}
+			cond := Conditions{}
+			if !empty[0] {
+				cond = append(cond, ics[0])
+			}
+			if !empty[1] {
+				cond = append(cond, ics[1])
+			}
+			conds = append(conds, cond)
+		}
 	case "cdata", "sdata", "data":
+		// the converter name may be prefixed by modifiers changing how the value
+		// is interpreted and where it has to match
+		mode, anchor, converterName, err := cutDataModifiers(t.ConverterName)
+		if err != nil {
+			return nil, err
+		}
 		val, err := valueStringParser.ParseString("", t.Value)
 		if err != nil {
 			return nil, err
@@ -851,8 +1393,12 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 		variables := []DataConditionElementVariable(nil)
 		for _, e := range val.Elements {
 			if e.Variable == nil {
-				content += e.Content
-				testContent += e.Content
+				c, err := mode.regex(e.Content)
+				if err != nil {
+					return nil, err
+				}
+				content += c
+				testContent += c
 				continue
 			}
 			testContent += "(?:test)"
@@ -882,7 +1428,8 @@ func (t *queryTerm) QueryConditions(pc *parserContext) (ConditionsSet, error) {
 							Variables:     variables,
 							SubQuery:      t.SubQuery,
 							Flags:         f,
-							ConverterName: t.ConverterName,
+							ConverterName: converterName,
+							Anchor:        anchor,
 						},
 					},
 				},
@@ -910,7 +1457,11 @@ func (c ConditionsSet) invert() ConditionsSet {
 	return conds
 }
 
-func (a Conditions) then(b Conditions) Conditions {
+// then returns the conditions requiring the data conditions of b to match
+// after the ones of a, timing restricts the time between both if not nil.
+// Timing constraints need data conditions on both sides, they are only
+// evaluated on the captured data, also for data conditions without converter.
+func (a Conditions) then(b Conditions, timing *DataConditionTiming) (Conditions, error) {
 	res := Conditions(nil)
 	adcs, bdcs := []Condition(nil), []Condition(nil)
 	for _, cc := range a {
@@ -928,9 +1479,12 @@ func (a Conditions) then(b Conditions) Conditions {
 		}
 	}
 	if len(adcs) == 0 || len(bdcs) == 0 {
+		if timing != nil {
+			return nil, errTimingWithoutData
+		}
 		res = append(res, adcs...)
 		res = append(res, bdcs...)
-		return res
+		return res, nil
 	}
 	for _, acc := range adcs {
 		adc := acc.(*DataCondition)
@@ -941,29 +1495,43 @@ func (a Conditions) then(b Conditions) Conditions {
 		}
 		for _, bcc := range bdcs {
 			bdc := bcc.(*DataCondition)
+			if timing != nil && l == 0 {
+				return nil, errTimingWithoutData
+			}
+			elements := append(append([]DataConditionElement(nil), adc.Elements[:l]...), bdc.Elements...)
+			if timing != nil {
+				elements[l].Timing = timing
+			}
 			res = append(res, &DataCondition{
 				Inverted: bdc.Inverted,
-				Elements: append(append([]DataConditionElement(nil), adc.Elements[:l]...), bdc.Elements...),
+				Elements: elements,
 			})
 		}
 	}
-	return res
+	return res, nil
 }
 
-func (a ConditionsSet) then(b ConditionsSet) ConditionsSet {
-	if len(a) == 0 {
-		return b
-	}
-	if len(b) == 0 {
-		return a
+func (a ConditionsSet) then(b ConditionsSet, timing *DataConditionTiming) (ConditionsSet, error) {
+	if len(a) == 0 || len(b) == 0 {
+		if timing != nil {
+			return nil, errTimingWithoutData
+		}
+		if len(a) == 0 {
+			return b, nil
+		}
+		return a, nil
 	}
 	res := ConditionsSet(nil)
 	for _, c1 := range a {
 		for _, c2 := range b {
-			res = res.Or(ConditionsSet{c1.then(c2)})
+			c, err := c1.then(c2, timing)
+			if err != nil {
+				return nil, err
+			}
+			res = res.Or(ConditionsSet{c})
 		}
 	}
-	return res
+	return res, nil
 }
 
 func (a Conditions) and(b Conditions) Conditions {
@@ -1395,6 +1963,182 @@ outer:
 	return true
 }
 
+func cleanHostSetConditions(hscs *[]HostSetCondition) bool {
+	slices.SortFunc(*hscs, func(a, b HostSetCondition) int {
+		if a.SubQuery != b.SubQuery {
+			return strings.Compare(a.SubQuery, b.SubQuery)
+		}
+		if a.Type != b.Type {
+			if b.Type == HostConditionSourceTypeServer {
+				return -1
+			}
+			return 1
+		}
+		if a.Name != b.Name {
+			return strings.Compare(a.Name, b.Name)
+		}
+		if a.Invert == b.Invert {
+			return 0
+		}
+		if b.Invert {
+			return -1
+		}
+		return 1
+	})
+	for i := 1; i < len(*hscs); i++ {
+		a, b := (*hscs)[i-1], (*hscs)[i]
+		if a.SubQuery != b.SubQuery || a.Type != b.Type || a.Name != b.Name {
+			continue
+		}
+		if a.Invert != b.Invert {
+			return false
+		}
+		copy((*hscs)[i-1:], (*hscs)[i:])
+		*hscs = (*hscs)[:len(*hscs)-1]
+		i--
+	}
+	return true
+}
+
+func cleanHashConditions(hcs *[]HashCondition) bool {
+	hcsCompare := func(a, b HashConditionSource) int {
+		if a.SubQuery != b.SubQuery {
+			return strings.Compare(a.SubQuery, b.SubQuery)
+		}
+		return int(a.Type) - int(b.Type)
+	}
+	for i := 0; i < len(*hcs); i++ {
+		hcsi := &(*hcs)[i]
+		slices.SortFunc(hcsi.HashConditionSources, hcsCompare)
+		// the same source xored twice cancels out
+		for j := 1; j < len(hcsi.HashConditionSources); j++ {
+			if hcsCompare(hcsi.HashConditionSources[j-1], hcsi.HashConditionSources[j]) != 0 {
+				continue
+			}
+			hcsi.HashConditionSources = append(hcsi.HashConditionSources[:j-1], hcsi.HashConditionSources[j+1:]...)
+			j--
+		}
+		if len(hcsi.HashConditionSources) != 0 {
+			continue
+		}
+		if (hcsi.Hash == 0) == hcsi.Invert {
+			return false
+		}
+		*hcs = append((*hcs)[:i], (*hcs)[i+1:]...)
+		i--
+	}
+	slices.SortFunc(*hcs, func(a, b HashCondition) int {
+		if c := slices.CompareFunc(a.HashConditionSources, b.HashConditionSources, hcsCompare); c != 0 {
+			return c
+		}
+		if a.Hash != b.Hash {
+			if a.Hash < b.Hash {
+				return -1
+			}
+			return 1
+		}
+		if a.Invert == b.Invert {
+			return 0
+		}
+		if b.Invert {
+			return -1
+		}
+		return 1
+	})
+	for i := 1; i < len(*hcs); i++ {
+		a, b := (*hcs)[i-1], (*hcs)[i]
+		if slices.CompareFunc(a.HashConditionSources, b.HashConditionSources, hcsCompare) != 0 {
+			continue
+		}
+		if a.Hash != b.Hash {
+			// the same sources can't be equal to two different hashes
+			if !a.Invert && !b.Invert {
+				return false
+			}
+			continue
+		}
+		if a.Invert != b.Invert {
+			return false
+		}
+		copy((*hcs)[i-1:], (*hcs)[i:])
+		*hcs = (*hcs)[:len(*hcs)-1]
+		i--
+	}
+	return true
+}
+
+func cleanSimilarityConditions(scs *[]SimilarityCondition) bool {
+	type key struct {
+		subQuery string
+		t        SimilarityConditionType
+		streamID uint64
+	}
+	// for each reference, the similarity has to be >= min and < max
+	type bounds struct {
+		min, max       float64
+		hasMin, hasMax bool
+	}
+	keys := []key(nil)
+	boundsByKey := map[key]*bounds{}
+	for _, sc := range *scs {
+		k := key{sc.SubQuery, sc.Type, sc.StreamID}
+		b := boundsByKey[k]
+		if b == nil {
+			b = &bounds{}
+			boundsByKey[k] = b
+			keys = append(keys, k)
+		}
+		if sc.Invert {
+			if !b.hasMax || sc.Threshold < b.max {
+				b.max, b.hasMax = sc.Threshold, true
+			}
+		} else if !b.hasMin || sc.Threshold > b.min {
+			b.min, b.hasMin = sc.Threshold, true
+		}
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		if a.subQuery != b.subQuery {
+			return strings.Compare(a.subQuery, b.subQuery)
+		}
+		if a.t != b.t {
+			return int(a.t) - int(b.t)
+		}
+		if a.streamID != b.streamID {
+			if a.streamID < b.streamID {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	})
+	*scs = (*scs)[:0]
+	for _, k := range keys {
+		b := boundsByKey[k]
+		if b.hasMax && (b.max <= 0 || (b.hasMin && b.min >= b.max)) {
+			return false
+		}
+		// every stream is at least 0% similar
+		if b.hasMin && b.min > 0 {
+			*scs = append(*scs, SimilarityCondition{
+				SubQuery:  k.subQuery,
+				Type:      k.t,
+				StreamID:  k.streamID,
+				Threshold: b.min,
+			})
+		}
+		if b.hasMax {
+			*scs = append(*scs, SimilarityCondition{
+				SubQuery:  k.subQuery,
+				Type:      k.t,
+				StreamID:  k.streamID,
+				Threshold: b.max,
+				Invert:    true,
+			})
+		}
+	}
+	return true
+}
+
 func cleanNumberConditions(ncs *[]NumberCondition) bool {
 	for i := 0; i < len(*ncs); i++ {
 		nc := &(*ncs)[i]
@@ -1642,6 +2386,119 @@ outer:
 	return true
 }
 
+func cleanTickConditions(tcs *[]TickCondition) bool {
+	slices.SortFunc(*tcs, func(a, b TickCondition) int {
+		if a.SubQuery != b.SubQuery {
+			return strings.Compare(a.SubQuery, b.SubQuery)
+		}
+		if a.Relative != b.Relative {
+			if b.Relative {
+				return -1
+			}
+			return 1
+		}
+		if a.Upper != b.Upper {
+			if b.Upper {
+				return -1
+			}
+			return 1
+		}
+		return a.Tick - b.Tick
+	})
+	// only keep the tightest lower and upper bound
+	for i := 1; i < len(*tcs); i++ {
+		a, b := &(*tcs)[i-1], (*tcs)[i]
+		if a.SubQuery != b.SubQuery || a.Relative != b.Relative || a.Upper != b.Upper {
+			continue
+		}
+		if !a.Upper {
+			a.Tick = b.Tick
+		}
+		copy((*tcs)[i:], (*tcs)[i+1:])
+		*tcs = (*tcs)[:len(*tcs)-1]
+		i--
+	}
+	for i := 1; i < len(*tcs); i++ {
+		a, b := (*tcs)[i-1], (*tcs)[i]
+		if a.SubQuery == b.SubQuery && a.Relative == b.Relative && !a.Upper && b.Upper && a.Tick > b.Tick {
+			return false
+		}
+	}
+	return true
+}
+
+func cleanPcapConditions(pcs *[]PcapCondition) bool {
+	slices.SortFunc(*pcs, func(a, b PcapCondition) int {
+		if a.SubQuery != b.SubQuery {
+			return strings.Compare(a.SubQuery, b.SubQuery)
+		}
+		if c := slices.Compare(a.Patterns, b.Patterns); c != 0 {
+			return c
+		}
+		if a.Invert == b.Invert {
+			return 0
+		}
+		if b.Invert {
+			return -1
+		}
+		return 1
+	})
+	for i := 1; i < len(*pcs); i++ {
+		a, b := (*pcs)[i-1], (*pcs)[i]
+		if a.SubQuery != b.SubQuery || !slices.Equal(a.Patterns, b.Patterns) {
+			continue
+		}
+		if a.Invert != b.Invert {
+			return false
+		}
+		copy((*pcs)[i-1:], (*pcs)[i:])
+		*pcs = (*pcs)[:len(*pcs)-1]
+		i--
+	}
+	return true
+}
+
+func cleanImportConditions(ics *[]ImportCondition) bool {
+	slices.SortFunc(*ics, func(a, b ImportCondition) int {
+		if a.SubQuery != b.SubQuery {
+			return strings.Compare(a.SubQuery, b.SubQuery)
+		}
+		if a.Relative != b.Relative {
+			if b.Relative {
+				return -1
+			}
+			return 1
+		}
+		if a.Upper != b.Upper {
+			if b.Upper {
+				return -1
+			}
+			return 1
+		}
+		return a.Import - b.Import
+	})
+	// only keep the tightest lower and upper bound
+	for i := 1; i < len(*ics); i++ {
+		a, b := &(*ics)[i-1], (*ics)[i]
+		if a.SubQuery != b.SubQuery || a.Relative != b.Relative || a.Upper != b.Upper {
+			continue
+		}
+		if !a.Upper {
+			a.Import = b.Import
+		}
+		copy((*ics)[i:], (*ics)[i+1:])
+		*ics = (*ics)[:len(*ics)-1]
+		i--
+	}
+	for i := 1; i < len(*ics); i++ {
+		a, b := (*ics)[i-1], (*ics)[i]
+		if a.SubQuery == b.SubQuery && a.Relative == b.Relative && !a.Upper && b.Upper && a.Import > b.Import {
+			return false
+		}
+	}
+	return true
+}
+
 func cleanDataConditions(dcs *[]DataCondition) bool {
 	sort.Slice(*dcs, func(i, j int) bool {
 		a, b := (*dcs)[i], (*dcs)[j]
@@ -1720,8 +2577,14 @@ func (c Conditions) clean() Conditions {
 	lcs := []TagCondition(nil)
 	fcs := []FlagCondition(nil)
 	hcs := []HostCondition(nil)
+	hscs := []HostSetCondition(nil)
+	xcs := []HashCondition(nil)
+	scs := []SimilarityCondition(nil)
 	ncs := []NumberCondition(nil)
 	tcs := []TimeCondition(nil)
+	kcs := []TickCondition(nil)
+	pcs := []PcapCondition(nil)
+	ics := []ImportCondition(nil)
 	dcs := []DataCondition(nil)
 	for _, cc := range c {
 		switch ccc := cc.(type) {
@@ -1731,10 +2594,22 @@ func (c Conditions) clean() Conditions {
 			fcs = append(fcs, *ccc)
 		case *HostCondition:
 			hcs = append(hcs, *ccc)
+		case *HostSetCondition:
+			hscs = append(hscs, *ccc)
+		case *HashCondition:
+			xcs = append(xcs, *ccc)
+		case *SimilarityCondition:
+			scs = append(scs, *ccc)
 		case *NumberCondition:
 			ncs = append(ncs, *ccc)
 		case *TimeCondition:
 			tcs = append(tcs, *ccc)
+		case *TickCondition:
+			kcs = append(kcs, *ccc)
+		case *PcapCondition:
+			pcs = append(pcs, *ccc)
+		case *ImportCondition:
+			ics = append(ics, *ccc)
 		case *DataCondition:
 			dcs = append(dcs, *ccc)
 		case *ImpossibleCondition:
@@ -1747,8 +2622,14 @@ func (c Conditions) clean() Conditions {
 	possible = possible && cleanTagConditions(&lcs)
 	possible = possible && cleanFlagConditions(&fcs)
 	possible = possible && cleanHostConditions(&hcs)
+	possible = possible && cleanHostSetConditions(&hscs)
+	possible = possible && cleanHashConditions(&xcs)
+	possible = possible && cleanSimilarityConditions(&scs)
 	possible = possible && cleanNumberConditions(&ncs)
 	possible = possible && cleanTimeConditions(&tcs)
+	possible = possible && cleanTickConditions(&kcs)
+	possible = possible && cleanPcapConditions(&pcs)
+	possible = possible && cleanImportConditions(&ics)
 	possible = possible && cleanDataConditions(&dcs)
 	if !possible {
 		return Conditions{&impossibleCondition}
@@ -1763,12 +2644,30 @@ func (c Conditions) clean() Conditions {
 	for i := range hcs {
 		res = append(res, &hcs[i])
 	}
+	for i := range hscs {
+		res = append(res, &hscs[i])
+	}
+	for i := range xcs {
+		res = append(res, &xcs[i])
+	}
+	for i := range scs {
+		res = append(res, &scs[i])
+	}
 	for i := range ncs {
 		res = append(res, &ncs[i])
 	}
 	for i := range tcs {
 		res = append(res, &tcs[i])
 	}
+	for i := range kcs {
+		res = append(res, &kcs[i])
+	}
+	for i := range pcs {
+		res = append(res, &pcs[i])
+	}
+	for i := range ics {
+		res = append(res, &ics[i])
+	}
 	for i := range dcs {
 		res = append(res, &dcs[i])
 	}
@@ -1804,6 +2703,11 @@ func (c *queryCondition) QueryConditions(pc *parserContext) (ConditionsSet, erro
 			return nil, errors.New("only one group `filter` is allowed")
 		}
 		pc.groupTerm = c.GroupTerm
+	case c.SampleTerm != nil:
+		if pc.sampleTerm != nil {
+			return nil, errors.New("only one sample `filter` is allowed")
+		}
+		pc.sampleTerm = c.SampleTerm
 	default:
 		return nil, fmt.Errorf("queryCondition is empty")
 	}
@@ -1811,14 +2715,20 @@ func (c *queryCondition) QueryConditions(pc *parserContext) (ConditionsSet, erro
 }
 
 func (c *queryThenCondition) QueryConditions(pc *parserContext) (ConditionsSet, error) {
-	conds := ConditionsSet(nil)
+	conds, err := c.First.QueryConditions(pc)
+	if err != nil {
+		return nil, err
+	}
 	for _, a := range c.Then {
-		cond, err := a.QueryConditions(pc)
+		cond, err := a.Condition.QueryConditions(pc)
 		if err != nil {
 			return nil, err
 		}
-		if cond != nil {
-			conds = conds.then(cond)
+		if cond != nil || a.Timing != nil {
+			conds, err = conds.then(cond, (*DataConditionTiming)(a.Timing))
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	return conds, nil
@@ -1896,6 +2806,20 @@ func (cs *ConditionsSet) SubQueries() []string {
 			for _, s := range ccc.HostConditionSources {
 				add(s.SubQuery)
 			}
+		case *HashCondition:
+			for _, s := range ccc.HashConditionSources {
+				add(s.SubQuery)
+			}
+		case *SimilarityCondition:
+			add(ccc.SubQuery)
+		case *HostSetCondition:
+			add(ccc.SubQuery)
+		case *TickCondition:
+			add(ccc.SubQuery)
+		case *PcapCondition:
+			add(ccc.SubQuery)
+		case *ImportCondition:
+			add(ccc.SubQuery)
 		case *DataCondition:
 			for _, e := range ccc.Elements {
 				add(e.SubQuery)
@@ -1985,8 +2909,51 @@ func (cs *ConditionsSet) StreamIDs(nextStreamID uint64) (bitmask.LongBitmask, bo
 	return res, true
 }
 
+// Clone returns a copy of the conditions that can be modified using
+// UpdateReferenceTime or MoveReferenceTime without changing cs. Only the
+// conditions modified by those are copied.
+func (cs ConditionsSet) Clone() ConditionsSet {
+	res := make(ConditionsSet, len(cs))
+	for i, ccs := range cs {
+		res[i] = make(Conditions, len(ccs))
+		for j, cc := range ccs {
+			if c, ok := cc.(*TimeCondition); ok {
+				tmp := *c
+				tmp.Summands = slices.Clone(c.Summands)
+				cc = &tmp
+			}
+			res[i][j] = cc
+		}
+	}
+	return res
+}
+
+// MoveReferenceTime changes the conditions to match the same streams when
+// evaluated against newReferenceTime as they did when evaluated against
+// oldReferenceTime. Unlike UpdateReferenceTime, relative times are kept.
+func (cs *ConditionsSet) MoveReferenceTime(oldReferenceTime, newReferenceTime time.Time) {
+	delta := newReferenceTime.Sub(oldReferenceTime)
+	if delta == 0 {
+		return
+	}
+	for i := range *cs {
+		ccs := &(*cs)[i]
+		for j := range *ccs {
+			c, ok := (*ccs)[j].(*TimeCondition)
+			if !ok {
+				continue
+			}
+			factor := 0
+			for _, s := range c.Summands {
+				factor += s.FTimeFactor + s.LTimeFactor
+			}
+			c.Duration += delta * time.Duration(factor)
+		}
+	}
+}
+
 func (cs *ConditionsSet) UpdateReferenceTime(oldReferenceTime, newReferenceTime time.Time) {
-	delta := oldReferenceTime.Sub(newReferenceTime)
+	delta := newReferenceTime.Sub(oldReferenceTime)
 	if delta == 0 {
 		return
 	}
@@ -2001,11 +2968,64 @@ func (cs *ConditionsSet) UpdateReferenceTime(oldReferenceTime, newReferenceTime
 	}
 }
 
+// RelativeTimeChanges returns conditions matching all streams that might
+// change their matching state, when the conditions are evaluated against
+// newReferenceTime instead of oldReferenceTime. The conditions have to be
+// relative to newReferenceTime. If the streams can't be described, e.g.
+// because sub queries use relative times, false is returned.
+func (cs ConditionsSet) RelativeTimeChanges(oldReferenceTime, newReferenceTime time.Time) (ConditionsSet, bool) {
+	delta := newReferenceTime.Sub(oldReferenceTime)
+	res := ConditionsSet(nil)
+	for _, ccs := range cs {
+		for _, cc := range ccs {
+			c, ok := cc.(*TimeCondition)
+			if !ok {
+				continue
+			}
+			nowFactor, subQuery := -c.ReferenceTimeFactor, false
+			for _, s := range c.Summands {
+				nowFactor += s.FTimeFactor + s.LTimeFactor
+				subQuery = subQuery || s.SubQuery != ""
+			}
+			if nowFactor == 0 || delta == 0 {
+				continue
+			}
+			if subQuery {
+				return nil, false
+			}
+			// the condition as it was when evaluated against oldReferenceTime
+			old := *c
+			old.Duration += time.Duration(nowFactor) * delta
+			for _, inv := range old.invert() {
+				res = append(res, append(Conditions{c}, inv...))
+			}
+			for _, inv := range c.invert() {
+				res = append(res, append(Conditions{&old}, inv...))
+			}
+		}
+	}
+	if len(res) == 0 {
+		return res, true
+	}
+	res = res.Clean()
+	if res.impossible() {
+		return nil, true
+	}
+	return res, true
+}
+
 type (
-	Feature    uint8
+	Feature    uint16
 	FeatureSet struct {
 		MainFeatures, SubQueryFeatures Feature
 		MainTags, SubQueryTags         []string
+		HostSets                       []string
+		// Ticks is set if tick filters are used, RelativeTicks if
+		// some of them are relative to the current tick
+		Ticks, RelativeTicks bool
+		// RelativeImports is set if import filters relative to the
+		// last import are used
+		RelativeImports bool
 	}
 )
 
@@ -2018,12 +3038,18 @@ const (
 	FeatureFilterTimeRelative
 	FeatureFilterTags
 	FeatureFilterData
+	FeatureFilterPcap
+	// FeatureFilterMetadata is used by filters on stored values derived
+	// from the stream data, they change when packets are added to a stream
+	// but not when the data is converted
+	FeatureFilterMetadata
 )
 
 func (cs *ConditionsSet) Features() FeatureSet {
 	fs := FeatureSet{}
 	mainTags := map[string]struct{}{}
 	subQueryTags := map[string]struct{}{}
+	hostSets := map[string]struct{}{}
 	for _, ccs := range *cs {
 		for _, cc := range ccs {
 			mq, sq := false, false
@@ -2062,6 +3088,27 @@ func (cs *ConditionsSet) Features() FeatureSet {
 						sq = true
 					}
 				}
+			case *HostSetCondition:
+				f = FeatureFilterHost
+				mq = ccc.SubQuery == ""
+				sq = ccc.SubQuery != ""
+				if _, ok := hostSets[ccc.Name]; !ok {
+					hostSets[ccc.Name] = struct{}{}
+					fs.HostSets = append(fs.HostSets, ccc.Name)
+				}
+			case *SimilarityCondition:
+				f = FeatureFilterData
+				mq = ccc.SubQuery == ""
+				sq = ccc.SubQuery != ""
+			case *HashCondition:
+				f = FeatureFilterMetadata
+				for _, s := range ccc.HashConditionSources {
+					if s.SubQuery == "" {
+						mq = true
+					} else {
+						sq = true
+					}
+				}
 			case *NumberCondition:
 				for _, s := range ccc.Summands {
 					if s.SubQuery == "" {
@@ -2074,7 +3121,11 @@ func (cs *ConditionsSet) Features() FeatureSet {
 						f |= FeatureFilterID
 					case NumberConditionSummandTypeClientPort, NumberConditionSummandTypeServerPort:
 						f |= FeatureFilterPort
-					case NumberConditionSummandTypeClientBytes, NumberConditionSummandTypeServerBytes:
+					case NumberConditionSummandTypeClientBytes, NumberConditionSummandTypeServerBytes,
+						NumberConditionSummandTypeClientPackets, NumberConditionSummandTypeServerPackets,
+						NumberConditionSummandTypeClientChunks, NumberConditionSummandTypeServerChunks,
+						NumberConditionSummandTypeClientEntropy, NumberConditionSummandTypeServerEntropy,
+						NumberConditionSummandTypeClientPrintable, NumberConditionSummandTypeServerPrintable:
 						f |= FeatureFilterData
 					}
 				}
@@ -2097,6 +3148,27 @@ func (cs *ConditionsSet) Features() FeatureSet {
 				} else {
 					f = FeatureFilterTimeAbsolute
 				}
+			case *TickCondition:
+				mq = ccc.SubQuery == ""
+				sq = ccc.SubQuery != ""
+				fs.Ticks = true
+				if ccc.Relative {
+					fs.RelativeTicks = true
+					f = FeatureFilterTimeRelative
+				} else {
+					f = FeatureFilterTimeAbsolute
+				}
+			case *PcapCondition:
+				mq = ccc.SubQuery == ""
+				sq = ccc.SubQuery != ""
+				f = FeatureFilterPcap
+			case *ImportCondition:
+				mq = ccc.SubQuery == ""
+				sq = ccc.SubQuery != ""
+				f = FeatureFilterPcap
+				if ccc.Relative {
+					fs.RelativeImports = true
+				}
 			case *DataCondition:
 				for _, e := range ccc.Elements {
 					if e.SubQuery == "" {
@@ -2122,10 +3194,13 @@ type (
 	TagDetails struct {
 		Matches, Uncertain bitmask.LongBitmask
 		Conditions         ConditionsSet
+		// ReferenceTime is the time the Conditions are evaluated against,
+		// it is zero if the Conditions don't depend on it
+		ReferenceTime time.Time
 	}
 )
 
-func (cs Conditions) inlineTagFilter(tags map[string]TagDetails) ConditionsSet {
+func (cs Conditions) inlineTagFilter(tags map[string]TagDetails, referenceTime time.Time) ConditionsSet {
 	const (
 		uncertain = TagConditionAcceptUncertainFailing | TagConditionAcceptUncertainMatching
 		certain   = TagConditionAcceptFailing | TagConditionAcceptMatching
@@ -2148,7 +3223,12 @@ func (cs Conditions) inlineTagFilter(tags map[string]TagDetails) ConditionsSet {
 			}
 			continue
 		}
-		tagConditionsSet := td.Conditions.InlineTagFilters(tags)
+		tagConditionsSet := td.Conditions.InlineTagFilters(tags, td.ReferenceTime)
+		if !(td.ReferenceTime.IsZero() || referenceTime.IsZero()) {
+			// the tag is evaluated against its own reference time
+			tagConditionsSet = tagConditionsSet.Clone()
+			tagConditionsSet.MoveReferenceTime(td.ReferenceTime, referenceTime)
+		}
 		//TODO: rename subqueries in tagConditionsSet to not collide with the normal query
 		if c.Accept&uncertain == TagConditionAcceptUncertainFailing {
 			tagConditionsSet = tagConditionsSet.invert()
@@ -2175,10 +3255,13 @@ func (cs Conditions) inlineTagFilter(tags map[string]TagDetails) ConditionsSet {
 	return csNew
 }
 
-func (cs ConditionsSet) InlineTagFilters(tags map[string]TagDetails) ConditionsSet {
+// InlineTagFilters replaces the tag filters accepting uncertain streams with
+// the conditions of the tags. The conditions of cs are evaluated against
+// referenceTime, it may be zero if the times of the conditions don't matter.
+func (cs ConditionsSet) InlineTagFilters(tags map[string]TagDetails, referenceTime time.Time) ConditionsSet {
 	csNew := ConditionsSet{}
 	for _, c := range cs {
-		csNew = append(csNew, c.inlineTagFilter(tags)...)
+		csNew = append(csNew, c.inlineTagFilter(tags, referenceTime)...)
 	}
 	return csNew.Clean()
 }
@@ -0,0 +1,164 @@
+package query
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+)
+
+type (
+	// HostSet is a named list of hosts and networks, e.g. the network of a
+	// team, that can be used as `@name` in host filters.
+	HostSet struct {
+		Hosts      []string
+		conditions []HostCondition
+	}
+	HostSets map[string]*HostSet
+)
+
+const (
+	hostSetNamePattern = `[a-zA-Z0-9_-]+`
+)
+
+var (
+	hostSetNameRegex = regexp.MustCompile(`^` + hostSetNamePattern + `$`)
+)
+
+// ValidHostSetName returns whether the name can be used for a host set.
+func ValidHostSetName(name string) bool {
+	return hostSetNameRegex.MatchString(name)
+}
+
+// TeamHostSetName returns the name of the host set used for `team:<team>`.
+func TeamHostSetName(team string) string {
+	return "team" + team
+}
+
+func (c *HostCondition) setMasks(masks *maskParser) {
+	if masks != nil {
+		c.Mask4 = masks.V4Mask
+		c.Mask6 = masks.V6Mask
+		return
+	}
+	c.Mask4 = net.IP{
+		255, 255, 255, 255,
+	}
+	c.Mask6 = net.IP{
+		255, 255, 255, 255, 255, 255, 255, 255,
+		255, 255, 255, 255, 255, 255, 255, 255,
+	}
+}
+
+// NewHostSet creates a host set from a list of hosts using the syntax of the
+// host filter, e.g. `10.0.0.1` or `10.0.1.0/24`.
+func NewHostSet(hosts []string) (*HostSet, error) {
+	hs := &HostSet{
+		Hosts: hosts,
+	}
+	for _, h := range hosts {
+		val, err := valueHostListParser.ParseString("", h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %q: %w", h, err)
+		}
+		for _, e := range val.List {
+			if e.Host == nil {
+				return nil, fmt.Errorf("invalid host %q: only hosts and networks are allowed", h)
+			}
+			cond := HostCondition{
+				Host: e.Host.Host,
+			}
+			cond.setMasks(e.Masks)
+			hs.conditions = append(hs.conditions, cond)
+		}
+	}
+	return hs, nil
+}
+
+// Contains returns whether the host is contained in the host set.
+func (hs *HostSet) Contains(host net.IP) bool {
+	if h := host.To4(); h != nil {
+		host = h
+	}
+	for _, c := range hs.conditions {
+		// an IPv4 network never contains an IPv6 host and vice versa
+		if len(c.Host) != len(host) {
+			continue
+		}
+		mask := c.Mask4
+		if len(host) == net.IPv6len {
+			mask = c.Mask6
+		}
+		matching := true
+		for i := range host {
+			if (c.Host[i]^host[i])&mask[i] != 0 {
+				matching = false
+				break
+			}
+		}
+		if matching {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the sorted names of all host sets containing the host.
+func (hss HostSets) Names(host net.IP) []string {
+	res := []string{}
+	for n, hs := range hss {
+		if hs.Contains(host) {
+			res = append(res, n)
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// InlineHostSets replaces all host set conditions with conditions on the
+// hosts of the host set.
+func (cs ConditionsSet) InlineHostSets(hostSets HostSets) (ConditionsSet, error) {
+	csNew := ConditionsSet{}
+	for _, c := range cs {
+		expanded := ConditionsSet{Conditions{}}
+		for _, cc := range c {
+			hsc, ok := cc.(*HostSetCondition)
+			if !ok {
+				for i := range expanded {
+					expanded[i] = append(expanded[i], cc)
+				}
+				continue
+			}
+			hs, ok := hostSets[hsc.Name]
+			if !ok {
+				return nil, fmt.Errorf("host set %q does not exist", hsc.Name)
+			}
+			hcs := []Condition(nil)
+			for _, hc := range hs.conditions {
+				hc.HostConditionSources = []HostConditionSource{{
+					SubQuery: hsc.SubQuery,
+					Type:     hsc.Type,
+				}}
+				hc.Invert = hsc.Invert
+				hcs = append(hcs, &hc)
+			}
+			if hsc.Invert {
+				// the host has to be outside of all networks
+				for i := range expanded {
+					expanded[i] = append(expanded[i], hcs...)
+				}
+				continue
+			}
+			// the host has to be inside of one of the networks
+			expandedNew := ConditionsSet(nil)
+			for _, e := range expanded {
+				for _, hc := range hcs {
+					expandedNew = append(expandedNew, append(slices.Clip(e), hc))
+				}
+			}
+			expanded = expandedNew
+		}
+		csNew = append(csNew, expanded...)
+	}
+	return csNew.Clean(), nil
+}
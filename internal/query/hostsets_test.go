@@ -0,0 +1,30 @@
+package query
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHostSetContains(t *testing.T) {
+	hs, err := NewHostSet([]string{"10.0.1.0/24", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("NewHostSet failed with error: %v", err)
+	}
+	for _, tc := range []struct {
+		host string
+		want bool
+	}{
+		{"10.0.1.2", true},
+		{"10.0.2.1", false},
+		{"fd12::1", true},
+		{"fe00::1", false},
+		// the families must not be mixed up
+		{"a00:1ff::", false},
+		{"253.0.0.1", false},
+		{"::ffff:10.0.1.2", true},
+	} {
+		if got := hs.Contains(net.ParseIP(tc.host)); got != tc.want {
+			t.Errorf("HostSet.Contains(%s) = %v, want %v", tc.host, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package query
+
+import (
+	"strings"
+)
+
+// escapePcapPattern returns a glob pattern only matching the filename.
+func escapePcapPattern(filename string) string {
+	b := strings.Builder{}
+	for _, c := range filename {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// InlineImports replaces all import conditions with conditions on the pcap
+// of the first packet of the streams, imports contains the names of all
+// known pcaps in the order of their import.
+func (cs ConditionsSet) InlineImports(imports []string) ConditionsSet {
+	csNew := ConditionsSet{}
+	changed := false
+	for _, c := range cs {
+		cNew := Conditions{}
+		for _, cc := range c {
+			ic, ok := cc.(*ImportCondition)
+			if !ok {
+				cNew = append(cNew, cc)
+				continue
+			}
+			changed = true
+			n := ic.Import
+			if ic.Relative {
+				n += len(imports)
+			}
+			selected := []string(nil)
+			if !ic.Upper {
+				// import >= n
+				selected = imports[min(max(n, 0), len(imports)):]
+			} else {
+				// import <= n
+				selected = imports[:min(max(n+1, 0), len(imports))]
+			}
+			pc := &PcapCondition{
+				SubQuery: ic.SubQuery,
+				Patterns: make([]string, 0, len(selected)),
+			}
+			for _, fn := range selected {
+				pc.Patterns = append(pc.Patterns, escapePcapPattern(fn))
+			}
+			cNew = append(cNew, pc)
+		}
+		csNew = append(csNew, cNew)
+	}
+	if !changed {
+		return cs
+	}
+	return csNew.Clean()
+}
@@ -1,7 +1,9 @@
 package query
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +27,7 @@ type (
 		sortTerm      *sortTerm
 		limitTerm     *limitTerm
 		groupTerm     *groupTerm
+		sampleTerm    *sampleTerm
 	}
 	queryRoot struct {
 		Term *queryOrCondition `parser:"@@?"`
@@ -36,15 +39,21 @@ type (
 		And []*queryThenCondition `parser:"@@ ( OperatorAnd? @@ )*"`
 	}
 	queryThenCondition struct {
-		Then []*queryCondition `parser:"@@ ( OperatorThen @@ )*"`
+		First *queryCondition  `parser:"@@"`
+		Then  []*queryThenStep `parser:"( OperatorThen @@ )*"`
+	}
+	queryThenStep struct {
+		Timing    *thenTiming     `parser:"@ThenTiming?"`
+		Condition *queryCondition `parser:"@@"`
 	}
 	queryCondition struct {
-		Negated   *queryCondition   `parser:"  Negation @@"`
-		Grouped   *queryOrCondition `parser:"| '(' @@ ')'"`
-		Term      *queryTerm        `parser:"| @( SubQuery? Key ConverterName? ( UnquotedValue | QuotedValue ) )"`
-		SortTerm  *sortTerm         `parser:"| ( SortKey @( UnquotedValue | QuotedValue ) )"`
-		LimitTerm *limitTerm        `parser:"| ( LimitKey @( UnquotedValue | QuotedValue ) )"`
-		GroupTerm *groupTerm        `parser:"| ( GroupKey @( UnquotedValue | QuotedValue ) )"`
+		Negated    *queryCondition   `parser:"  Negation @@"`
+		Grouped    *queryOrCondition `parser:"| '(' @@ ')'"`
+		Term       *queryTerm        `parser:"| @( SubQuery? Key ConverterName? ( UnquotedValue | QuotedValue ) )"`
+		SortTerm   *sortTerm         `parser:"| ( SortKey @( UnquotedValue | QuotedValue ) )"`
+		LimitTerm  *limitTerm        `parser:"| ( LimitKey @( UnquotedValue | QuotedValue ) )"`
+		GroupTerm  *groupTerm        `parser:"| ( GroupKey @( UnquotedValue | QuotedValue ) )"`
+		SampleTerm *sampleTerm       `parser:"| ( SampleKey @( UnquotedValue | QuotedValue ) )"`
 	}
 	queryTerm struct {
 		SubQuery      string
@@ -55,12 +64,25 @@ type (
 	sortTerm struct {
 		sorting []Sorting
 	}
-	limitTerm uint
-	groupTerm string
+	thenTiming DataConditionTiming
+	limitTerm  struct {
+		limit, groupLimit *uint
+	}
+	groupTerm  string
+	sampleTerm Sampling
 
 	Grouping struct {
 		Constant  string
 		Variables []DataConditionElementVariable
+		// Limit is the maximum number of streams per group, 0 is treated as 1
+		Limit uint
+	}
+
+	Sampling struct {
+		// Size is the number of streams in the sample
+		Size uint
+		// Seed makes the sample reproducible, a random seed is used if it is nil
+		Seed *uint64
 	}
 
 	Query struct {
@@ -69,60 +91,101 @@ type (
 		Sorting       []Sorting
 		Limit         *uint
 		Grouping      *Grouping
+		Sampling      *Sampling
 		ReferenceTime time.Time
 	}
 )
 
 var (
+	queryLexerRules = []lexer.SimpleRule{
+		{
+			Name:    "whitespace",
+			Pattern: `[ \t\n\r]+`,
+		}, {
+			Name:    "Negation",
+			Pattern: `[!-]`,
+		}, {
+			Name:    "SubQuery",
+			Pattern: `(?i)@([a-z0-9]+):`,
+		}, {
+			Name:    "Key",
+			Pattern: `(?i)(id|tag|service|mark|protocol|generated|[fl]?time|duration|tick|pcap|import|[cs]?(data|port|host|bytes|packets|chunks)|[cs](prefix)?hash|[cs]?similar|[cs]?team|[cs](entropy|printable))`,
+		}, {
+			Name:    "ConverterName",
+			Pattern: `\.([^:=]+)`,
+		}, {
+			Name:    "SortKey",
+			Pattern: `(?i)sort`,
+		}, {
+			Name:    "LimitKey",
+			Pattern: `(?i)limit`,
+		}, {
+			Name:    "GroupKey",
+			Pattern: `(?i)group`,
+		}, {
+			Name:    "SampleKey",
+			Pattern: `(?i)sample`,
+		}, {
+			Name:    "OperatorOr",
+			Pattern: `(?i)or`,
+		}, {
+			Name:    "OperatorAnd",
+			Pattern: `(?i)and`,
+		}, {
+			Name:    "OperatorThen",
+			Pattern: `(?i)then`,
+		}, {
+			Name:    "ThenTiming",
+			Pattern: `\[[^\]]*\]`,
+		}, {
+			Name:    "BracketOpen",
+			Pattern: `[(]`,
+		}, {
+			Name:    "BracketClose",
+			Pattern: `[)]`,
+		}, {
+			Name:    "QuotedValue",
+			Pattern: `[:=]"(?:[^"]*|"")*"`,
+		}, {
+			Name:    "UnquotedValue",
+			Pattern: `[:=](?:(?:[^"\\ \t\n\r]|\\.)(?:[^\\ \t\n\r]|\\.)*)?(?:[^)\\ \t\n\r]|\\.)`,
+		},
+	}
+	hashConditionSourceTypes = map[string]HashConditionSourceType{
+		"chash":       HashConditionSourceTypeClient,
+		"shash":       HashConditionSourceTypeServer,
+		"cprefixhash": HashConditionSourceTypeClientPrefix,
+		"sprefixhash": HashConditionSourceTypeServerPrefix,
+	}
+	sortingKeys = map[string]SortingKey{
+		"id":          SortingKeyID,
+		"ftime":       SortingKeyFirstPacketTime,
+		"ltime":       SortingKeyLastPacketTime,
+		"cbytes":      SortingKeyClientBytes,
+		"sbytes":      SortingKeyServerBytes,
+		"chost":       SortingKeyClientHost,
+		"shost":       SortingKeyServerHost,
+		"cport":       SortingKeyClientPort,
+		"sport":       SortingKeyServerPort,
+		"chash":       SortingKeyClientHash,
+		"shash":       SortingKeyServerHash,
+		"cprefixhash": SortingKeyClientPrefixHash,
+		"sprefixhash": SortingKeyServerPrefixHash,
+		"duration":    SortingKeyDuration,
+		"packets":     SortingKeyPackets,
+		"cpackets":    SortingKeyClientPackets,
+		"spackets":    SortingKeyServerPackets,
+		"chunks":      SortingKeyChunks,
+		"cchunks":     SortingKeyClientChunks,
+		"schunks":     SortingKeyServerChunks,
+		"tick":        SortingKeyTick,
+		"centropy":    SortingKeyClientEntropy,
+		"sentropy":    SortingKeyServerEntropy,
+		"cprintable":  SortingKeyClientPrintable,
+		"sprintable":  SortingKeyServerPrintable,
+	}
 	parser = participle.MustBuild[queryRoot](
-		participle.Lexer(lexer.MustSimple([]lexer.SimpleRule{
-			{
-				Name:    "whitespace",
-				Pattern: `[ \t\n\r]+`,
-			}, {
-				Name:    "Negation",
-				Pattern: `[!-]`,
-			}, {
-				Name:    "SubQuery",
-				Pattern: `(?i)@([a-z0-9]+):`,
-			}, {
-				Name:    "Key",
-				Pattern: `(?i)(id|tag|service|mark|protocol|generated|[fl]?time|[cs]?(data|port|host|bytes))`,
-			}, {
-				Name:    "ConverterName",
-				Pattern: `\.([^:=]+)`,
-			}, {
-				Name:    "SortKey",
-				Pattern: `(?i)sort`,
-			}, {
-				Name:    "LimitKey",
-				Pattern: `(?i)limit`,
-			}, {
-				Name:    "GroupKey",
-				Pattern: `(?i)group`,
-			}, {
-				Name:    "OperatorOr",
-				Pattern: `(?i)or`,
-			}, {
-				Name:    "OperatorAnd",
-				Pattern: `(?i)and`,
-			}, {
-				Name:    "OperatorThen",
-				Pattern: `(?i)then`,
-			}, {
-				Name:    "BracketOpen",
-				Pattern: `[(]`,
-			}, {
-				Name:    "BracketClose",
-				Pattern: `[)]`,
-			}, {
-				Name:    "QuotedValue",
-				Pattern: `[:=]"(?:[^"]*|"")*"`,
-			}, {
-				Name:    "UnquotedValue",
-				Pattern: `[:=](?:(?:[^"\\ \t\n\r]|\\.)(?:[^\\ \t\n\r]|\\.)*)?(?:[^)\\ \t\n\r]|\\.)`,
-			},
-		})),
+		participle.Lexer(lexer.MustSimple(queryLexerRules)),
 		participle.CaseInsensitive("Key"),
 		participle.CaseInsensitive("SortKey"),
 		participle.CaseInsensitive("OperatorOr"),
@@ -163,17 +226,7 @@ func (t *sortTerm) Capture(s []string) error {
 			dir = SortingDirDescending
 			v = strings.TrimSpace(strings.TrimPrefix(v, "-"))
 		}
-		key, ok := map[string]SortingKey{
-			"id":     SortingKeyID,
-			"ftime":  SortingKeyFirstPacketTime,
-			"ltime":  SortingKeyLastPacketTime,
-			"cbytes": SortingKeyClientBytes,
-			"sbytes": SortingKeyServerBytes,
-			"chost":  SortingKeyClientHost,
-			"shost":  SortingKeyServerHost,
-			"cport":  SortingKeyClientPort,
-			"sport":  SortingKeyServerPort,
-		}[v]
+		key, ok := sortingKeys[v]
 		if !ok {
 			return fmt.Errorf("invalid sort key %q", v)
 		}
@@ -185,13 +238,51 @@ func (t *sortTerm) Capture(s []string) error {
 	return nil
 }
 
+// Capture parses limits of the form `total`, `total,per-group` and `,per-group`.
 func (t *limitTerm) Capture(s []string) error {
 	v := strings.TrimSpace(parseValue(s[0]))
-	n, err := strconv.ParseUint(v, 10, 64)
+	total, group, hasGroup := strings.Cut(v, ",")
+	if total = strings.TrimSpace(total); total != "" || !hasGroup {
+		n, err := strconv.ParseUint(total, 10, 64)
+		if err != nil {
+			return err
+		}
+		l := uint(n)
+		t.limit = &l
+	}
+	if hasGroup {
+		n, err := strconv.ParseUint(strings.TrimSpace(group), 10, 64)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return errors.New("per group limit must be positive")
+		}
+		l := uint(n)
+		t.groupLimit = &l
+	}
+	return nil
+}
+
+// Capture parses samples of the form `size` and `size,seed`.
+func (t *sampleTerm) Capture(s []string) error {
+	v := strings.TrimSpace(parseValue(s[0]))
+	size, seed, hasSeed := strings.Cut(v, ",")
+	n, err := strconv.ParseUint(strings.TrimSpace(size), 10, 64)
 	if err != nil {
 		return err
 	}
-	*t = limitTerm(n)
+	if n == 0 {
+		return errors.New("sample size must be positive")
+	}
+	t.Size = uint(n)
+	if hasSeed {
+		n, err := strconv.ParseUint(strings.TrimSpace(seed), 10, 64)
+		if err != nil {
+			return err
+		}
+		t.Seed = &n
+	}
 	return nil
 }
 
@@ -215,11 +306,57 @@ func (c *queryCondition) String() string {
 	}
 }
 
+// Capture parses timing constraints like `[<100ms]` or `[>1s,<=2s]`.
+func (t *thenTiming) Capture(s []string) error {
+	v := strings.TrimSpace(s[0][1 : len(s[0])-1])
+	*t = thenTiming{
+		Max: math.MaxInt64,
+	}
+	if v == "" {
+		return errors.New("empty timing constraint")
+	}
+	for _, c := range strings.Split(v, ",") {
+		c = strings.TrimSpace(c)
+		op := strings.TrimRight(c[:min(len(c), 2)], "0123456789.")
+		if op != "<" && op != "<=" && op != ">" && op != ">=" {
+			return fmt.Errorf("invalid timing constraint %q, expected one of <, <=, > or >= followed by a duration", c)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(c[len(op):]))
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return fmt.Errorf("invalid timing constraint %q, the duration must not be negative", c)
+		}
+		switch op {
+		case "<":
+			t.Max = min(t.Max, d-1)
+		case "<=":
+			t.Max = min(t.Max, d)
+		case ">":
+			t.Min = max(t.Min, d+1)
+		case ">=":
+			t.Min = max(t.Min, d)
+		}
+	}
+	if t.Min > t.Max {
+		return fmt.Errorf("impossible timing constraint %q", v)
+	}
+	return nil
+}
+
+func (t *queryThenStep) String() string {
+	if t.Timing == nil {
+		return t.Condition.String()
+	}
+	return fmt.Sprintf("[%s]%s", (*DataConditionTiming)(t.Timing), t.Condition.String())
+}
+
 func (c *queryThenCondition) String() string {
-	if len(c.Then) == 1 {
-		return c.Then[0].String()
+	if len(c.Then) == 0 {
+		return c.First.String()
 	}
-	a := []string{}
+	a := []string{c.First.String()}
 	for _, i := range c.Then {
 		a = append(a, i.String())
 	}
@@ -286,7 +423,10 @@ func Parse(q string) (*Query, error) {
 	if pc.sortTerm != nil {
 		sorting = pc.sortTerm.sorting
 	}
-	limit := (*uint)(pc.limitTerm)
+	limit := (*uint)(nil)
+	if pc.limitTerm != nil {
+		limit = pc.limitTerm.limit
+	}
 	grouping := (*Grouping)(nil)
 	if pc.groupTerm != nil {
 		val, err := valueStringParser.ParseString("", string(*pc.groupTerm))
@@ -305,6 +445,15 @@ func Parse(q string) (*Query, error) {
 				Name:     e.Variable.Name,
 			})
 		}
+		if pc.limitTerm != nil && pc.limitTerm.groupLimit != nil {
+			grouping.Limit = *pc.limitTerm.groupLimit
+		}
+	} else if pc.limitTerm != nil && pc.limitTerm.groupLimit != nil {
+		return nil, errors.New("per group limit requires grouping")
+	}
+	sampling := (*Sampling)(nil)
+	if pc.sampleTerm != nil {
+		sampling = (*Sampling)(pc.sampleTerm)
 	}
 	return &Query{
 		Debug:         []string{root.String(), cond.String()},
@@ -313,5 +462,6 @@ func Parse(q string) (*Query, error) {
 		Limit:         limit,
 		ReferenceTime: pc.referenceTime,
 		Grouping:      grouping,
+		Sampling:      sampling,
 	}, nil
 }
@@ -20,6 +20,22 @@ const (
 	SortingKeyServerHost
 	SortingKeyClientPort
 	SortingKeyServerPort
+	SortingKeyClientHash
+	SortingKeyServerHash
+	SortingKeyClientPrefixHash
+	SortingKeyServerPrefixHash
+	SortingKeyDuration
+	SortingKeyPackets
+	SortingKeyClientPackets
+	SortingKeyServerPackets
+	SortingKeyChunks
+	SortingKeyClientChunks
+	SortingKeyServerChunks
+	SortingKeyTick
+	SortingKeyClientEntropy
+	SortingKeyServerEntropy
+	SortingKeyClientPrintable
+	SortingKeyServerPrintable
 
 	SortingDirAscending  SortingDir = false
 	SortingDirDescending SortingDir = true
@@ -0,0 +1,130 @@
+package query
+
+import (
+	"errors"
+	"time"
+)
+
+type (
+	// TickSchedule describes the ticks of an attack-defense CTF. Tick 0
+	// starts at Start and every tick lasts Length. The tick clock is
+	// stopped during pauses, the tick running when a pause starts is
+	// continued after the pause.
+	TickSchedule struct {
+		Start  time.Time
+		Length time.Duration
+		Pauses []TickPause `json:",omitempty"`
+	}
+	TickPause struct {
+		Start    time.Time
+		Duration time.Duration
+	}
+)
+
+func (ts *TickSchedule) Validate() error {
+	if ts.Start.IsZero() {
+		return errors.New("tick schedule start missing")
+	}
+	if ts.Length <= 0 {
+		return errors.New("tick length must be positive")
+	}
+	last := ts.Start
+	for _, p := range ts.Pauses {
+		if p.Duration <= 0 {
+			return errors.New("tick pause duration must be positive")
+		}
+		if p.Start.Before(last) {
+			return errors.New("tick pauses must be sorted, must not overlap and must not start before the first tick")
+		}
+		last = p.Start.Add(p.Duration)
+	}
+	return nil
+}
+
+// elapsed returns the tick clock time passed between the start of the
+// schedule and t.
+func (ts *TickSchedule) elapsed(t time.Time) time.Duration {
+	d := t.Sub(ts.Start)
+	for _, p := range ts.Pauses {
+		if !t.After(p.Start) {
+			break
+		}
+		d -= min(t.Sub(p.Start), p.Duration)
+	}
+	return d
+}
+
+// Tick returns the number of the tick running at t, times before the start
+// of the schedule belong to negative ticks.
+func (ts *TickSchedule) Tick(t time.Time) int {
+	d := ts.elapsed(t)
+	n := d / ts.Length
+	if d%ts.Length < 0 {
+		n--
+	}
+	return int(n)
+}
+
+// TickStart returns the time at which the tick with the given number starts.
+func (ts *TickSchedule) TickStart(tick int) time.Time {
+	t := ts.Start.Add(time.Duration(tick) * ts.Length)
+	for _, p := range ts.Pauses {
+		if !p.Start.Before(t) {
+			break
+		}
+		t = t.Add(p.Duration)
+	}
+	return t
+}
+
+// InlineTicks replaces all tick conditions with conditions on the first
+// packet time of the streams, relative ticks are resolved using the
+// referenceTime.
+func (cs ConditionsSet) InlineTicks(ticks *TickSchedule, referenceTime time.Time) (ConditionsSet, error) {
+	csNew := ConditionsSet{}
+	changed := false
+	for _, c := range cs {
+		cNew := Conditions{}
+		for _, cc := range c {
+			tc, ok := cc.(*TickCondition)
+			if !ok {
+				cNew = append(cNew, cc)
+				continue
+			}
+			if ticks == nil {
+				return nil, errors.New("tick filters require a tick schedule")
+			}
+			changed = true
+			tick := tc.Tick
+			if tc.Relative {
+				tick += ticks.Tick(referenceTime)
+			}
+			if !tc.Upper {
+				// ftime >= start
+				cNew = append(cNew, &TimeCondition{
+					Summands: []TimeConditionSummand{{
+						SubQuery:    tc.SubQuery,
+						FTimeFactor: 1,
+					}},
+					Duration:            referenceTime.Sub(ticks.TickStart(tick)),
+					ReferenceTimeFactor: 1,
+				})
+			} else {
+				// ftime < start of the next tick
+				cNew = append(cNew, &TimeCondition{
+					Summands: []TimeConditionSummand{{
+						SubQuery:    tc.SubQuery,
+						FTimeFactor: -1,
+					}},
+					Duration:            ticks.TickStart(tick+1).Sub(referenceTime) - time.Nanosecond,
+					ReferenceTimeFactor: -1,
+				})
+			}
+		}
+		csNew = append(csNew, cNew)
+	}
+	if !changed {
+		return cs, nil
+	}
+	return csNew.Clean(), nil
+}
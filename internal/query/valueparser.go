@@ -1,6 +1,7 @@
 package query
 
 import (
+	"encoding/hex"
 	"fmt"
 	"net"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
+	"rsc.io/binaryregexp"
 )
 
 type (
@@ -28,6 +30,12 @@ type (
 	maskParser struct {
 		V4Mask, V6Mask []byte
 	}
+	hostSetParser struct {
+		Name string
+	}
+	hashParser struct {
+		Hash uint64
+	}
 	stringParser struct {
 		Elements []struct {
 			Content  string          `parser:"( @Characters"`
@@ -45,7 +53,8 @@ type (
 			Range []struct {
 				Parts []struct {
 					Operators string          `parser:"@Operator*"`
-					Number    int             `parser:"( @Number"`
+					Decimal   *float64        `parser:"( @Decimal"`
+					Number    int             `parser:"| @Number"`
 					Variable  *variableParser `parser:"| @Variable )"`
 				} `parser:"@@*"`
 			} `parser:"@@ (RangeSeparator @@)?"`
@@ -66,13 +75,44 @@ type (
 	hostListParser struct {
 		List []struct {
 			Variable *variableParser `parser:"( @Variable"`
+			HostSet  *hostSetParser  `parser:"| @HostSet"`
 			Host     *hostParser     `parser:"| @( IP4 | IP6 ) )"`
 			Masks    *maskParser     `parser:"@(Mask+)?"`
 		} `parser:"@@ (GroupSeparator @@)*"`
 	}
+	hashListParser struct {
+		List []struct {
+			Variable *variableParser `parser:"( @Variable"`
+			Hash     *hashParser     `parser:"| @Hash )"`
+		} `parser:"@@ (GroupSeparator @@)*"`
+	}
+	dataValueMode        uint8
+	similarityListParser struct {
+		List []struct {
+			StreamID  uint64   `parser:"@Number"`
+			Threshold *float64 `parser:"(RangeSeparator @(Threshold | Number))?"`
+		} `parser:"@@ (GroupSeparator @@)*"`
+	}
+)
+
+const (
+	// the value of a data filter is a regex
+	dataValueModeRegex dataValueMode = iota
+	// the value of a data filter is matched literally
+	dataValueModeLiteral
+	// the value of a data filter is matched literally, ignoring the case of ascii letters
+	dataValueModeCaseInsensitive
+	// the value of a data filter are hex encoded bytes, whitespace is ignored
+	dataValueModeHex
 )
 
 var (
+	dataValueModes = map[string]dataValueMode{
+		"lit": dataValueModeLiteral,
+		"i":   dataValueModeCaseInsensitive,
+		"hex": dataValueModeHex,
+	}
+
 	stringLexerRules = lexer.Rules{
 		"Variable": {
 			{
@@ -139,6 +179,9 @@ var (
 			lexer.Include("RangeList"),
 			lexer.Include("Operator"),
 			{
+				Name:    "Decimal",
+				Pattern: `\d*[.]\d+`,
+			}, {
 				Name:    "Number",
 				Pattern: `\d+`,
 			},
@@ -177,6 +220,37 @@ var (
 			}, {
 				Name:    "Mask",
 				Pattern: `(?:/-?\d+)`,
+			}, {
+				Name:    "HostSet",
+				Pattern: `@` + hostSetNamePattern,
+			},
+		},
+	}
+	hashListLexerRules = lexer.Rules{
+		"Variable": tokenListLexerRules["Variable"],
+		"Global":   tokenListLexerRules["Global"],
+		"List":     tokenListLexerRules["List"],
+		"Root": []lexer.Rule{
+			lexer.Include("List"),
+			{
+				Name:    "Hash",
+				Pattern: `(?i)[0-9a-f]+`,
+			},
+		},
+	}
+	similarityListLexerRules = lexer.Rules{
+		"Variable":  tokenListLexerRules["Variable"],
+		"Global":    tokenListLexerRules["Global"],
+		"List":      tokenListLexerRules["List"],
+		"RangeList": rangeListLexerRules["RangeList"],
+		"Root": []lexer.Rule{
+			lexer.Include("RangeList"),
+			{
+				Name:    "Threshold",
+				Pattern: `\d*[.]\d+`,
+			}, {
+				Name:    "Number",
+				Pattern: `\d+`,
 			},
 		},
 	}
@@ -195,6 +269,12 @@ var (
 	valueHostListParser = participle.MustBuild[hostListParser](
 		participle.Lexer(lexer.MustStateful(hostListLexerRules)),
 	)
+	valueHashListParser = participle.MustBuild[hashListParser](
+		participle.Lexer(lexer.MustStateful(hashListLexerRules)),
+	)
+	valueSimilarityListParser = participle.MustBuild[similarityListParser](
+		participle.Lexer(lexer.MustStateful(similarityListLexerRules)),
+	)
 )
 
 //func (p *stringRoot) Parseable(lex *lexer.PeekingLexer) error {
@@ -287,6 +367,119 @@ func (p *maskParser) Capture(s []string) error {
 	return nil
 }
 
+func (p *hashParser) Capture(s []string) error {
+	h, err := strconv.ParseUint(s[0], 16, 64)
+	if err != nil {
+		return fmt.Errorf("bad hash %q", s[0])
+	}
+	p.Hash = h
+	return nil
+}
+
+func (p *hostSetParser) Capture(s []string) error {
+	p.Name = s[0][1:]
+	return nil
+}
+
+// cutDataModifiers splits the dot separated modifiers of a data filter from
+// the converter name, e.g. `chunk0.lit.converter` or `hex`.
+func cutDataModifiers(s string) (dataValueMode, *DataConditionAnchor, string, error) {
+	mode, modeSet := dataValueModeRegex, false
+	anchor := (*DataConditionAnchor)(nil)
+	for s != "" {
+		modifier, rest, _ := strings.Cut(s, ".")
+		modifier = strings.ToLower(modifier)
+		if m, ok := dataValueModes[modifier]; ok {
+			if modeSet {
+				return 0, nil, "", fmt.Errorf("duplicate data modifier %q", modifier)
+			}
+			mode, modeSet = m, true
+			s = rest
+			continue
+		}
+		name, n, ok := dataAnchorModifier(modifier)
+		if !ok {
+			break
+		}
+		if anchor == nil {
+			anchor = &DataConditionAnchor{
+				Chunk:  -1,
+				Offset: -1,
+			}
+		}
+		field := map[string]*int{
+			"chunk": &anchor.Chunk,
+			"at":    &anchor.Offset,
+		}[name]
+		if *field != -1 {
+			return 0, nil, "", fmt.Errorf("duplicate data modifier %q", name)
+		}
+		*field = n
+		s = rest
+	}
+	return mode, anchor, s, nil
+}
+
+// IsDataModifier returns whether the name is a modifier of data filters,
+// converters with such names could not be selected in data filters.
+func IsDataModifier(name string) bool {
+	name = strings.ToLower(name)
+	if _, ok := dataValueModes[name]; ok {
+		return true
+	}
+	_, _, ok := dataAnchorModifier(name)
+	return ok
+}
+
+// dataAnchorModifier parses the modifiers `chunkN` and `atN`.
+func dataAnchorModifier(modifier string) (string, int, bool) {
+	for _, name := range []string{"chunk", "at"} {
+		digits, ok := strings.CutPrefix(modifier, name)
+		if !ok || digits == "" || strings.Trim(digits, "0123456789") != "" {
+			continue
+		}
+		n, err := strconv.ParseUint(digits, 10, 31)
+		if err != nil {
+			return "", 0, false
+		}
+		return name, int(n), true
+	}
+	return "", 0, false
+}
+
+// regex converts the characters of a data filter value to a regex matching
+// the value in the given mode. Bytes are escaped as \x{XX} if needed.
+func (m dataValueMode) regex(content string) (string, error) {
+	if m == dataValueModeRegex {
+		return content, nil
+	}
+	data := []byte(strings.ReplaceAll(content, "@@", "@"))
+	if m == dataValueModeHex {
+		digits := strings.Join(strings.Fields(string(data)), "")
+		if len(digits)%2 != 0 {
+			return "", fmt.Errorf("odd number of hex digits in %q", content)
+		}
+		var err error
+		if data, err = hex.DecodeString(digits); err != nil {
+			return "", fmt.Errorf("bad hex value %q: %w", content, err)
+		}
+	}
+	res := strings.Builder{}
+	for _, b := range data {
+		switch {
+		case m == dataValueModeCaseInsensitive && b >= 'a' && b <= 'z':
+			fmt.Fprintf(&res, "[%c%c]", b, b-'a'+'A')
+		case m == dataValueModeCaseInsensitive && b >= 'A' && b <= 'Z':
+			fmt.Fprintf(&res, "[%c%c]", b-'A'+'a', b)
+		case m != dataValueModeHex && b >= 0x20 && b < 0x7f:
+			res.WriteString(binaryregexp.QuoteMeta(string(rune(b))))
+		default:
+			fmt.Fprintf(&res, `\x{%02x}`, b)
+		}
+	}
+	return res.String(), nil
+}
+
 func (p *variableParser) String() string {
 	if p.Sub != "" {
 		return fmt.Sprintf("@%s:%s@", p.Sub, p.Name)
@@ -305,6 +498,8 @@ func (p *numberRangeListParser) String() string {
 				tmp += map[bool]string{true: "-", false: "+"}[negative]
 				if p.Variable != nil {
 					tmp += p.Variable.String()
+				} else if p.Decimal != nil {
+					tmp += fmt.Sprintf("%g", *p.Decimal)
 				} else {
 					tmp += fmt.Sprintf("%d", p.Number)
 				}
@@ -346,6 +541,8 @@ func (p *hostListParser) String() string {
 		tmp := ""
 		if l.Variable != nil {
 			tmp = l.Variable.String()
+		} else if l.HostSet != nil {
+			tmp = "@" + l.HostSet.Name
 		} else {
 			tmp = l.Host.Host.String()
 		}
@@ -356,3 +553,27 @@ func (p *hostListParser) String() string {
 	}
 	return strings.Join(res, ",")
 }
+
+func (p *hashListParser) String() string {
+	res := []string(nil)
+	for _, l := range p.List {
+		if l.Variable != nil {
+			res = append(res, l.Variable.String())
+		} else {
+			res = append(res, fmt.Sprintf("%016x", l.Hash.Hash))
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+func (p *similarityListParser) String() string {
+	res := []string(nil)
+	for _, l := range p.List {
+		if l.Threshold != nil {
+			res = append(res, fmt.Sprintf("%d:%g", l.StreamID, *l.Threshold))
+		} else {
+			res = append(res, fmt.Sprintf("%d", l.StreamID))
+		}
+	}
+	return strings.Join(res, ",")
+}
@@ -2,7 +2,7 @@
  * Generated type guards for "apiClient.ts".
  * WARNING: Do not manually change this file.
  */
-import { Error, SearchResult, SearchResponse, StreamData, Statistics, MainStderr, Config, PcapsResponse, ConvertersResponse, ProcessStderr, PcapOverIPResponse, Webhooks, TagsResponse, GraphResponse } from "./apiClient";
+import { Error, SearchResult, SearchResponse, ExplainResult, ExplainResponse, AggregateResult, AggregateResponse, StreamData, Statistics, MainStderr, Config, PcapsResponse, ConvertersResponse, ProcessStderr, PcapOverIPResponse, Webhooks, HostSets, QueryCompletions, TagsResponse, GraphResponse } from "./apiClient";
 
 export function isError(obj: unknown): obj is Error {
     const typedObj = obj as Error
@@ -40,19 +40,120 @@ export function isSearchResult(obj: unknown): obj is SearchResult {
             typeof e["Stream"]["Client"]["Host"] === "string" &&
             typeof e["Stream"]["Client"]["Port"] === "number" &&
             typeof e["Stream"]["Client"]["Bytes"] === "number" &&
+            typeof e["Stream"]["Client"]["Hash"] === "string" &&
+            typeof e["Stream"]["Client"]["PrefixHash"] === "string" &&
+            typeof e["Stream"]["Client"]["Entropy"] === "number" &&
+            typeof e["Stream"]["Client"]["Printable"] === "number" &&
             (e["Stream"]["Server"] !== null &&
                 typeof e["Stream"]["Server"] === "object" ||
                 typeof e["Stream"]["Server"] === "function") &&
             typeof e["Stream"]["Server"]["Host"] === "string" &&
             typeof e["Stream"]["Server"]["Port"] === "number" &&
             typeof e["Stream"]["Server"]["Bytes"] === "number" &&
+            typeof e["Stream"]["Server"]["Hash"] === "string" &&
+            typeof e["Stream"]["Server"]["PrefixHash"] === "string" &&
+            typeof e["Stream"]["Server"]["Entropy"] === "number" &&
+            typeof e["Stream"]["Server"]["Printable"] === "number" &&
             typeof e["Stream"]["FirstPacket"] === "string" &&
             typeof e["Stream"]["LastPacket"] === "string" &&
             typeof e["Stream"]["Index"] === "string" &&
             Array.isArray(e["Tags"]) &&
             e["Tags"].every((e: any) =>
                 typeof e === "string"
-            )
+            ) &&
+            (e["HostSets"] !== null &&
+                typeof e["HostSets"] === "object" ||
+                typeof e["HostSets"] === "function") &&
+            Array.isArray(e["HostSets"]["Client"]) &&
+            e["HostSets"]["Client"].every((e: any) =>
+                typeof e === "string"
+            ) &&
+            Array.isArray(e["HostSets"]["Server"]) &&
+            e["HostSets"]["Server"].every((e: any) =>
+                typeof e === "string"
+            ) &&
+            (typeof e["Matches"] === "undefined" ||
+                Array.isArray(e["Matches"]) &&
+                e["Matches"].every((e: any) =>
+                    (e !== null &&
+                        typeof e === "object" ||
+                        typeof e === "function") &&
+                    typeof e["Converter"] === "string" &&
+                    typeof e["Direction"] === "number" &&
+                    typeof e["Start"] === "number" &&
+                    typeof e["End"] === "number" &&
+                    typeof e["Snippet"] === "string" &&
+                    typeof e["SnippetStart"] === "number"
+                )) &&
+            (typeof e["SubQueries"] === "undefined" ||
+                (e["SubQueries"] !== null &&
+                    typeof e["SubQueries"] === "object" ||
+                    typeof e["SubQueries"] === "function") &&
+                Object.entries<any>(e["SubQueries"])
+                    .every(([key, value]) => (Array.isArray(value) &&
+                        value.every((e: any) =>
+                            (e !== null &&
+                                typeof e === "object" ||
+                                typeof e === "function") &&
+                            typeof e["ID"] === "number" &&
+                            typeof e["Protocol"] === "string" &&
+                            (e["Client"] !== null &&
+                                typeof e["Client"] === "object" ||
+                                typeof e["Client"] === "function") &&
+                            typeof e["Client"]["Host"] === "string" &&
+                            typeof e["Client"]["Port"] === "number" &&
+                            typeof e["Client"]["Bytes"] === "number" &&
+                            typeof e["Client"]["Hash"] === "string" &&
+                            typeof e["Client"]["PrefixHash"] === "string" &&
+                            typeof e["Client"]["Entropy"] === "number" &&
+                            typeof e["Client"]["Printable"] === "number" &&
+                            (e["Server"] !== null &&
+                                typeof e["Server"] === "object" ||
+                                typeof e["Server"] === "function") &&
+                            typeof e["Server"]["Host"] === "string" &&
+                            typeof e["Server"]["Port"] === "number" &&
+                            typeof e["Server"]["Bytes"] === "number" &&
+                            typeof e["Server"]["Hash"] === "string" &&
+                            typeof e["Server"]["PrefixHash"] === "string" &&
+                            typeof e["Server"]["Entropy"] === "number" &&
+                            typeof e["Server"]["Printable"] === "number" &&
+                            typeof e["FirstPacket"] === "string" &&
+                            typeof e["LastPacket"] === "string" &&
+                            typeof e["Index"] === "string"
+                        ) &&
+                        typeof key === "string"))) &&
+            (typeof e["GroupAlternatives"] === "undefined" ||
+                Array.isArray(e["GroupAlternatives"]) &&
+                e["GroupAlternatives"].every((e: any) =>
+                    (e !== null &&
+                        typeof e === "object" ||
+                        typeof e === "function") &&
+                    typeof e["ID"] === "number" &&
+                    typeof e["Protocol"] === "string" &&
+                    (e["Client"] !== null &&
+                        typeof e["Client"] === "object" ||
+                        typeof e["Client"] === "function") &&
+                    typeof e["Client"]["Host"] === "string" &&
+                    typeof e["Client"]["Port"] === "number" &&
+                    typeof e["Client"]["Bytes"] === "number" &&
+                    typeof e["Client"]["Hash"] === "string" &&
+                    typeof e["Client"]["PrefixHash"] === "string" &&
+                    typeof e["Client"]["Entropy"] === "number" &&
+                    typeof e["Client"]["Printable"] === "number" &&
+                    (e["Server"] !== null &&
+                        typeof e["Server"] === "object" ||
+                        typeof e["Server"] === "function") &&
+                    typeof e["Server"]["Host"] === "string" &&
+                    typeof e["Server"]["Port"] === "number" &&
+                    typeof e["Server"]["Bytes"] === "number" &&
+                    typeof e["Server"]["Hash"] === "string" &&
+                    typeof e["Server"]["PrefixHash"] === "string" &&
+                    typeof e["Server"]["Entropy"] === "number" &&
+                    typeof e["Server"]["Printable"] === "number" &&
+                    typeof e["FirstPacket"] === "string" &&
+                    typeof e["LastPacket"] === "string" &&
+                    typeof e["Index"] === "string"
+                ))
         ) &&
         typeof typedObj["Elapsed"] === "number" &&
         typeof typedObj["Offset"] === "number" &&
@@ -69,7 +170,36 @@ export function isSearchResult(obj: unknown): obj is SearchResult {
             Array.isArray(typedObj["DataRegexes"]["Server"]) &&
             typedObj["DataRegexes"]["Server"].every((e: any) =>
                 typeof e === "string"
-            ))
+            )) &&
+        (typedObj["Facets"] === null ||
+            (typedObj["Facets"] !== null &&
+                typeof typedObj["Facets"] === "object" ||
+                typeof typedObj["Facets"] === "function") &&
+            (typedObj["Facets"]["Services"] !== null &&
+                typeof typedObj["Facets"]["Services"] === "object" ||
+                typeof typedObj["Facets"]["Services"] === "function") &&
+            Object.entries<any>(typedObj["Facets"]["Services"])
+                .every(([key, value]) => (typeof value === "number" &&
+                    typeof key === "string")) &&
+            (typedObj["Facets"]["Tags"] !== null &&
+                typeof typedObj["Facets"]["Tags"] === "object" ||
+                typeof typedObj["Facets"]["Tags"] === "function") &&
+            Object.entries<any>(typedObj["Facets"]["Tags"])
+                .every(([key, value]) => (typeof value === "number" &&
+                    typeof key === "string")) &&
+            (typedObj["Facets"]["ServerPorts"] !== null &&
+                typeof typedObj["Facets"]["ServerPorts"] === "object" ||
+                typeof typedObj["Facets"]["ServerPorts"] === "function") &&
+            Object.entries<any>(typedObj["Facets"]["ServerPorts"])
+                .every(([key, value]) => (typeof value === "number" &&
+                    typeof key === "string")) &&
+            (typedObj["Facets"]["ClientHosts"] !== null &&
+                typeof typedObj["Facets"]["ClientHosts"] === "object" ||
+                typeof typedObj["Facets"]["ClientHosts"] === "function") &&
+            Object.entries<any>(typedObj["Facets"]["ClientHosts"])
+                .every(([key, value]) => (typeof value === "number" &&
+                    typeof key === "string"))) &&
+        typeof typedObj["Cursor"] === "string"
     )
 }
 
@@ -81,6 +211,120 @@ export function isSearchResponse(obj: unknown): obj is SearchResponse {
     )
 }
 
+export function isExplainResult(obj: unknown): obj is ExplainResult {
+    const typedObj = obj as ExplainResult
+    return (
+        (typedObj !== null &&
+            typeof typedObj === "object" ||
+            typeof typedObj === "function") &&
+        Array.isArray(typedObj["Debug"]) &&
+        typedObj["Debug"].every((e: any) =>
+            typeof e === "string"
+        ) &&
+        (typedObj["Explanation"] !== null &&
+            typeof typedObj["Explanation"] === "object" ||
+            typeof typedObj["Explanation"] === "function") &&
+        typeof typedObj["Explanation"]["Conditions"] === "string" &&
+        Array.isArray(typedObj["Explanation"]["SubQueries"]) &&
+        typedObj["Explanation"]["SubQueries"].every((e: any) =>
+            (e !== null &&
+                typeof e === "object" ||
+                typeof e === "function") &&
+            typeof e["SubQuery"] === "string" &&
+            Array.isArray(e["Indexes"]) &&
+            e["Indexes"].every((e: any) =>
+                (e !== null &&
+                    typeof e === "object" ||
+                    typeof e === "function") &&
+                typeof e["Index"] === "string" &&
+                typeof e["Streams"] === "number" &&
+                Array.isArray(e["QueryParts"]) &&
+                e["QueryParts"].every((e: any) =>
+                    (e !== null &&
+                        typeof e === "object" ||
+                        typeof e === "function") &&
+                    typeof e["Conditions"] === "string" &&
+                    typeof e["Possible"] === "boolean" &&
+                    Array.isArray(e["Lookups"]) &&
+                    e["Lookups"].every((e: any) =>
+                        typeof e === "string"
+                    ) &&
+                    Array.isArray(e["Filters"]) &&
+                    e["Filters"].every((e: any) =>
+                        typeof e === "string"
+                    ) &&
+                    Array.isArray(e["RegexPrefilters"]) &&
+                    e["RegexPrefilters"].every((e: any) =>
+                        typeof e === "string"
+                    )
+                ) &&
+                typeof e["FullScan"] === "boolean" &&
+                typeof e["SortedCandidates"] === "boolean" &&
+                typeof e["EstimatedCandidates"] === "number" &&
+                typeof e["ConsideredCandidates"] === "number" &&
+                typeof e["EvaluatedCandidates"] === "number" &&
+                typeof e["MatchingCandidates"] === "number"
+            ) &&
+            typeof e["Results"] === "number" &&
+            typeof e["Build"] === "number" &&
+            typeof e["Candidates"] === "number" &&
+            typeof e["Evaluate"] === "number"
+        ) &&
+        typeof typedObj["Explanation"]["Results"] === "number" &&
+        typeof typedObj["Explanation"]["Prepare"] === "number" &&
+        typeof typedObj["Explanation"]["Total"] === "number"
+    )
+}
+
+export function isExplainResponse(obj: unknown): obj is ExplainResponse {
+    const typedObj = obj as ExplainResponse
+    return (
+        (isError(typedObj) as boolean ||
+            isExplainResult(typedObj) as boolean)
+    )
+}
+
+export function isAggregateResult(obj: unknown): obj is AggregateResult {
+    const typedObj = obj as AggregateResult
+    return (
+        (typedObj !== null &&
+            typeof typedObj === "object" ||
+            typeof typedObj === "function") &&
+        Array.isArray(typedObj["Debug"]) &&
+        typedObj["Debug"].every((e: any) =>
+            typeof e === "string"
+        ) &&
+        typeof typedObj["Elapsed"] === "number" &&
+        (typedObj["Aggregation"] !== null &&
+            typeof typedObj["Aggregation"] === "object" ||
+            typeof typedObj["Aggregation"] === "function") &&
+        typeof typedObj["Aggregation"]["Streams"] === "number" &&
+        typeof typedObj["Aggregation"]["ClientBytes"] === "number" &&
+        typeof typedObj["Aggregation"]["ServerBytes"] === "number" &&
+        typeof typedObj["Aggregation"]["FirstPacket"] === "string" &&
+        typeof typedObj["Aggregation"]["LastPacket"] === "string" &&
+        (typedObj["Aggregation"]["Buckets"] === null ||
+            Array.isArray(typedObj["Aggregation"]["Buckets"]) &&
+            typedObj["Aggregation"]["Buckets"].every((e: any) =>
+                (e !== null &&
+                    typeof e === "object" ||
+                    typeof e === "function") &&
+                typeof e["Start"] === "string" &&
+                typeof e["Streams"] === "number" &&
+                typeof e["ClientBytes"] === "number" &&
+                typeof e["ServerBytes"] === "number"
+            ))
+    )
+}
+
+export function isAggregateResponse(obj: unknown): obj is AggregateResponse {
+    const typedObj = obj as AggregateResponse
+    return (
+        (isError(typedObj) as boolean ||
+            isAggregateResult(typedObj) as boolean)
+    )
+}
+
 export function isStreamData(obj: unknown): obj is StreamData {
     const typedObj = obj as StreamData
     return (
@@ -98,12 +342,20 @@ export function isStreamData(obj: unknown): obj is StreamData {
         typeof typedObj["Stream"]["Client"]["Host"] === "string" &&
         typeof typedObj["Stream"]["Client"]["Port"] === "number" &&
         typeof typedObj["Stream"]["Client"]["Bytes"] === "number" &&
+        typeof typedObj["Stream"]["Client"]["Hash"] === "string" &&
+        typeof typedObj["Stream"]["Client"]["PrefixHash"] === "string" &&
+        typeof typedObj["Stream"]["Client"]["Entropy"] === "number" &&
+        typeof typedObj["Stream"]["Client"]["Printable"] === "number" &&
         (typedObj["Stream"]["Server"] !== null &&
             typeof typedObj["Stream"]["Server"] === "object" ||
             typeof typedObj["Stream"]["Server"] === "function") &&
         typeof typedObj["Stream"]["Server"]["Host"] === "string" &&
         typeof typedObj["Stream"]["Server"]["Port"] === "number" &&
         typeof typedObj["Stream"]["Server"]["Bytes"] === "number" &&
+        typeof typedObj["Stream"]["Server"]["Hash"] === "string" &&
+        typeof typedObj["Stream"]["Server"]["PrefixHash"] === "string" &&
+        typeof typedObj["Stream"]["Server"]["Entropy"] === "number" &&
+        typeof typedObj["Stream"]["Server"]["Printable"] === "number" &&
         typeof typedObj["Stream"]["FirstPacket"] === "string" &&
         typeof typedObj["Stream"]["LastPacket"] === "string" &&
         typeof typedObj["Stream"]["Index"] === "string" &&
@@ -121,11 +373,39 @@ export function isStreamData(obj: unknown): obj is StreamData {
         typedObj["Tags"].every((e: any) =>
             typeof e === "string"
         ) &&
+        (typedObj["HostSets"] !== null &&
+            typeof typedObj["HostSets"] === "object" ||
+            typeof typedObj["HostSets"] === "function") &&
+        Array.isArray(typedObj["HostSets"]["Client"]) &&
+        typedObj["HostSets"]["Client"].every((e: any) =>
+            typeof e === "string"
+        ) &&
+        Array.isArray(typedObj["HostSets"]["Server"]) &&
+        typedObj["HostSets"]["Server"].every((e: any) =>
+            typeof e === "string"
+        ) &&
         Array.isArray(typedObj["Converters"]) &&
         typedObj["Converters"].every((e: any) =>
             typeof e === "string"
         ) &&
-        typeof typedObj["ActiveConverter"] === "string"
+        typeof typedObj["ActiveConverter"] === "string" &&
+        Array.isArray(typedObj["Pcaps"]) &&
+        typedObj["Pcaps"].every((e: any) =>
+            typeof e === "string"
+        ) &&
+        (typeof typedObj["Matches"] === "undefined" ||
+            Array.isArray(typedObj["Matches"]) &&
+            typedObj["Matches"].every((e: any) =>
+                (e !== null &&
+                    typeof e === "object" ||
+                    typeof e === "function") &&
+                typeof e["Converter"] === "string" &&
+                typeof e["Direction"] === "number" &&
+                typeof e["Start"] === "number" &&
+                typeof e["End"] === "number" &&
+                typeof e["Snippet"] === "string" &&
+                typeof e["SnippetStart"] === "number"
+            ))
     )
 }
 
@@ -165,7 +445,22 @@ export function isConfig(obj: unknown): obj is Config {
         (typedObj !== null &&
             typeof typedObj === "object" ||
             typeof typedObj === "function") &&
-        typeof typedObj["AutoInsertLimitToQuery"] === "boolean"
+        typeof typedObj["AutoInsertLimitToQuery"] === "boolean" &&
+        (typeof typedObj["Ticks"] === "undefined" ||
+            (typedObj["Ticks"] !== null &&
+                typeof typedObj["Ticks"] === "object" ||
+                typeof typedObj["Ticks"] === "function") &&
+            typeof typedObj["Ticks"]["Start"] === "string" &&
+            typeof typedObj["Ticks"]["Length"] === "number" &&
+            (typeof typedObj["Ticks"]["Pauses"] === "undefined" ||
+                Array.isArray(typedObj["Ticks"]["Pauses"]) &&
+                typedObj["Ticks"]["Pauses"].every((e: any) =>
+                    (e !== null &&
+                        typeof e === "object" ||
+                        typeof e === "function") &&
+                    typeof e["Start"] === "string" &&
+                    typeof e["Duration"] === "number"
+                )))
     )
 }
 
@@ -251,6 +546,36 @@ export function isWebhooks(obj: unknown): obj is Webhooks {
     )
 }
 
+export function isHostSets(obj: unknown): obj is HostSets {
+    const typedObj = obj as HostSets
+    return (
+        (typedObj !== null &&
+            typeof typedObj === "object" ||
+            typeof typedObj === "function") &&
+        Object.entries<any>(typedObj)
+            .every(([key, value]) => (Array.isArray(value) &&
+                value.every((e: any) =>
+                    typeof e === "string"
+                ) &&
+                typeof key === "string"))
+    )
+}
+
+export function isQueryCompletions(obj: unknown): obj is QueryCompletions {
+    const typedObj = obj as QueryCompletions
+    return (
+        (typedObj !== null &&
+            typeof typedObj === "object" ||
+            typeof typedObj === "function") &&
+        typeof typedObj["Start"] === "number" &&
+        typeof typedObj["End"] === "number" &&
+        Array.isArray(typedObj["Suggestions"]) &&
+        typedObj["Suggestions"].every((e: any) =>
+            typeof e === "string"
+        )
+    )
+}
+
 export function isTagsResponse(obj: unknown): obj is TagsResponse {
     const typedObj = obj as TagsResponse
     return (
@@ -11,6 +11,10 @@ import {
   isSearchResponse,
   isStatistics,
   isStreamData,
+  isHostSets,
+  isQueryCompletions,
+  isExplainResponse,
+  isAggregateResponse,
   isTagsResponse,
   isWebhooks,
 } from "./apiClient.guard";
@@ -23,6 +27,10 @@ type SideInfo = {
   Host: string;
   Port: number;
   Bytes: number;
+  Hash: string;
+  PrefixHash: string;
+  Entropy: number;
+  Printable: number;
 };
 
 export type Stream = {
@@ -35,9 +43,27 @@ export type Stream = {
   Index: string;
 };
 
+type HostSetsInfo = {
+  Client: string[];
+  Server: string[];
+};
+
+export type DataMatch = {
+  Converter: string;
+  Direction: number;
+  Start: number;
+  End: number;
+  Snippet: Base64;
+  SnippetStart: number;
+};
+
 export type Result = {
   Stream: Stream;
   Tags: string[];
+  HostSets: HostSetsInfo;
+  Matches?: DataMatch[];
+  SubQueries?: { [name: string]: Stream[] };
+  GroupAlternatives?: Stream[];
 };
 
 /** @see {isError} ts-auto-guard:type-guard */
@@ -50,6 +76,13 @@ export type DataRegexes = {
   Server: string[] | null;
 };
 
+export type SearchFacets = {
+  Services: { [name: string]: number };
+  Tags: { [name: string]: number };
+  ServerPorts: { [port: string]: number };
+  ClientHosts: { [host: string]: number };
+};
+
 /** @see {isSearchResult} ts-auto-guard:type-guard */
 export type SearchResult = {
   Debug: string[];
@@ -58,11 +91,85 @@ export type SearchResult = {
   Offset: number;
   MoreResults: boolean;
   DataRegexes: DataRegexes;
+  Facets: SearchFacets | null;
+  Cursor: string;
 };
 
 /** @see {isSearchResponse} ts-auto-guard:type-guard */
 export type SearchResponse = SearchResult | Error;
 
+export type QueryPartExplanation = {
+  Conditions: string;
+  Possible: boolean;
+  Lookups: string[];
+  Filters: string[];
+  RegexPrefilters: string[];
+};
+
+export type IndexExplanation = {
+  Index: string;
+  Streams: number;
+  QueryParts: QueryPartExplanation[];
+  FullScan: boolean;
+  SortedCandidates: boolean;
+  EstimatedCandidates: number;
+  ConsideredCandidates: number;
+  EvaluatedCandidates: number;
+  MatchingCandidates: number;
+};
+
+export type SubQueryExplanation = {
+  SubQuery: string;
+  Indexes: IndexExplanation[];
+  Results: number;
+  Build: number;
+  Candidates: number;
+  Evaluate: number;
+};
+
+export type SearchExplanation = {
+  Conditions: string;
+  SubQueries: SubQueryExplanation[];
+  Results: number;
+  Prepare: number;
+  Total: number;
+};
+
+/** @see {isExplainResult} ts-auto-guard:type-guard */
+export type ExplainResult = {
+  Debug: string[];
+  Explanation: SearchExplanation;
+};
+
+/** @see {isExplainResponse} ts-auto-guard:type-guard */
+export type ExplainResponse = ExplainResult | Error;
+
+export type AggregationBucket = {
+  Start: DateTimeString;
+  Streams: number;
+  ClientBytes: number;
+  ServerBytes: number;
+};
+
+export type SearchAggregation = {
+  Streams: number;
+  ClientBytes: number;
+  ServerBytes: number;
+  FirstPacket: DateTimeString;
+  LastPacket: DateTimeString;
+  Buckets: AggregationBucket[] | null;
+};
+
+/** @see {isAggregateResult} ts-auto-guard:type-guard */
+export type AggregateResult = {
+  Debug: string[];
+  Elapsed: number;
+  Aggregation: SearchAggregation;
+};
+
+/** @see {isAggregateResponse} ts-auto-guard:type-guard */
+export type AggregateResponse = AggregateResult | Error;
+
 export type Data = {
   Direction: number;
   Content: Base64;
@@ -74,8 +181,11 @@ export type StreamData = {
   Stream: Stream;
   Data: Data[];
   Tags: string[];
+  HostSets: HostSetsInfo;
   Converters: string[];
   ActiveConverter: string;
+  Pcaps: string[];
+  Matches?: DataMatch[];
 };
 
 /** @see {isStatistics} ts-auto-guard:type-guard */
@@ -99,6 +209,16 @@ export type MainStderr = string[];
 /** @see {isConfig} ts-auto-guard:type-guard */
 export type Config = {
   AutoInsertLimitToQuery: boolean;
+  Ticks?: TickSchedule;
+};
+
+export type TickSchedule = {
+  Start: DateTimeString;
+  Length: number;
+  Pauses?: {
+    Start: DateTimeString;
+    Duration: number;
+  }[];
 };
 
 export type PcapInfo = {
@@ -148,6 +268,16 @@ export type PcapOverIPResponse = PcapOverIPEndpoint[];
 /** @see {isWebhooks} ts-auto-guard:type-guard */
 export type Webhooks = string[];
 
+/** @see {isHostSets} ts-auto-guard:type-guard */
+export type HostSets = { [name: string]: string[] };
+
+/** @see {isQueryCompletions} ts-auto-guard:type-guard */
+export type QueryCompletions = {
+  Start: number;
+  End: number;
+  Suggestions: string[];
+};
+
 export type TagInfo = {
   Name: string;
   Definition: string;
@@ -176,18 +306,64 @@ export type GraphResponse = {
 };
 
 const APIClient = {
-  async searchStreams(query: string, page: number) {
+  async searchStreams(
+    query: string,
+    page: number,
+    facets = false,
+    cursor?: string,
+    snippets = false,
+    alternatives = false,
+    releaseCursor?: string,
+  ) {
     return this.performGuarded(
       "post",
       "/search.json",
       isSearchResponse,
       query,
+      {
+        page,
+        facets,
+        cursor,
+        snippets,
+        alternatives,
+        releaseCursor,
+      },
+    );
+  },
+  async explainQuery(query: string, page: number) {
+    return this.performGuarded(
+      "post",
+      "/explain.json",
+      isExplainResponse,
+      query,
       {
         page,
       },
     );
   },
-  async getStream(streamId: number, converter: string) {
+  async aggregateStreams(query: string, bucket?: string) {
+    return this.performGuarded(
+      "post",
+      "/aggregate.json",
+      isAggregateResponse,
+      query,
+      {
+        bucket,
+      },
+    );
+  },
+  async completeQuery(query: string, cursor: number) {
+    return this.performGuarded(
+      "post",
+      "/query/complete",
+      isQueryCompletions,
+      query,
+      {
+        cursor,
+      },
+    );
+  },
+  async getStream(streamId: number, converter: string, query?: string) {
     return this.performGuarded(
       "get",
       `/stream/${streamId}.json`,
@@ -195,6 +371,7 @@ const APIClient = {
       null,
       {
         converter,
+        query,
       },
     );
   },
@@ -231,6 +408,15 @@ const APIClient = {
   async delWebhook(url: string) {
     return this.perform("delete", "/webhooks", null, { url });
   },
+  async getHostSets() {
+    return this.performGuarded("get", "/hostsets", isHostSets);
+  },
+  async setHostSet(name: string, hosts: string[]) {
+    return this.perform("put", "/hostsets", JSON.stringify(hosts), { name });
+  },
+  async delHostSet(name: string) {
+    return this.perform("delete", "/hostsets", null, { name });
+  },
   async getConverters() {
     return this.performGuarded("get", `/converters`, isConvertersResponse);
   },
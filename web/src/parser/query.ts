@@ -7,6 +7,7 @@ declare var ws: any;
 declare var kw_or: any;
 declare var kw_and: any;
 declare var kw_then: any;
+declare var timing: any;
 declare var negation: any;
 declare var lparen: any;
 declare var rparen: any;
@@ -30,11 +31,12 @@ const lexer = moo.compile({
     ],
     lparen: '(',
     rparen: ')',
+    timing: {match: /\[[^\]]*\]/, value: x => x.slice(1, -1)},
     subquery: {match: /@[a-z0-9]+:/, value: x => x.slice(1, -1)},
-    converter: {match: /\.[a-z0-9]*/, value: x => x.slice(1)},
+    converter: {match: /\.[a-z0-9]*(?:\.[a-z0-9]*)*/, value: x => x.slice(1)},
     negation: /[!-]/,
     keyword_or_error: {match: /[a-zA-Z]+/, error: true, type: moo.keywords({
-        kw: ['id', 'tag', 'service', 'mark', 'generated', 'protocol', 'ftime', 'ltime', 'time', 'cdata', 'sdata', 'data', 'cport', 'sport', 'port', 'chost', 'shost', 'host', 'cbytes', 'sbytes', 'bytes', 'sort', 'limit', 'group'],
+        kw: ['id', 'tag', 'service', 'mark', 'generated', 'protocol', 'ftime', 'ltime', 'time', 'duration', 'tick', 'pcap', 'import', 'cdata', 'sdata', 'data', 'cport', 'sport', 'port', 'chost', 'shost', 'host', 'cbytes', 'sbytes', 'bytes', 'cpackets', 'spackets', 'packets', 'cchunks', 'schunks', 'chunks', 'chash', 'shash', 'cprefixhash', 'sprefixhash', 'csimilar', 'ssimilar', 'similar', 'cteam', 'steam', 'team', 'centropy', 'sentropy', 'cprintable', 'sprintable', 'sort', 'limit', 'group', 'sample'],
         'kw_or': 'or',
         'kw_and': 'and',
         'kw_then': 'then',
@@ -49,6 +51,7 @@ export interface LogicQueryElement extends QueryElement {
   type: "logic";
   op: "or" | "and" | "sequence";
   expressions: QueryElement[];
+  timing?: moo.Token;
 }
 
 export interface SubexpressionQueryElement extends QueryElement {
@@ -118,7 +121,9 @@ const grammar: Grammar = {
     {"name": "queryAndCondition$ebnf$1", "symbols": [], "postprocess": () => null},
     {"name": "queryAndCondition", "symbols": ["queryAndCondition", (lexer.has("ws") ? {type: "ws"} : ws), "queryAndCondition$ebnf$1", "queryThenCondition"], "postprocess": (d) => d.length > 1 ? {'type': 'logic', 'op': 'and', 'expressions': [d[0], d[3]]} : d[0]},
     {"name": "queryAndCondition", "symbols": ["queryThenCondition"], "postprocess": id},
-    {"name": "queryThenCondition", "symbols": ["queryThenCondition", (lexer.has("ws") ? {type: "ws"} : ws), (lexer.has("kw_then") ? {type: "kw_then"} : kw_then), (lexer.has("ws") ? {type: "ws"} : ws), "queryCondition"], "postprocess": (d) => d.length > 1 ? {'type': 'logic', 'op': 'sequence', 'expressions': [d[0], d[4]]} : d[0]},
+    {"name": "queryThenCondition$ebnf$1", "symbols": [(lexer.has("timing") ? {type: "timing"} : timing)], "postprocess": id},
+    {"name": "queryThenCondition$ebnf$1", "symbols": [], "postprocess": () => null},
+    {"name": "queryThenCondition", "symbols": ["queryThenCondition", (lexer.has("ws") ? {type: "ws"} : ws), (lexer.has("kw_then") ? {type: "kw_then"} : kw_then), "queryThenCondition$ebnf$1", (lexer.has("ws") ? {type: "ws"} : ws), "queryCondition"], "postprocess": (d) => d.length > 1 ? {'type': 'logic', 'op': 'sequence', 'expressions': [d[0], d[5]], 'timing': d[3]} : d[0]},
     {"name": "queryThenCondition", "symbols": ["queryCondition"], "postprocess": id},
     {"name": "queryCondition", "symbols": [(lexer.has("negation") ? {type: "negation"} : negation), "queryCondition"], "postprocess": function(d) {return {'type': 'not', 'expression': d[1]};}},
     {"name": "queryCondition$ebnf$1", "symbols": [(lexer.has("ws") ? {type: "ws"} : ws)], "postprocess": id},
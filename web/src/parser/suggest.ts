@@ -17,6 +17,11 @@ type SuggestionResults = {
 
 const queryGrammar = nearley.Grammar.fromCompiled(grammar);
 
+// modifiers of data filters, like data.hex:"de ad"
+const dataModifiers = ["hex", "i", "lit"];
+// anchors of data filters, like data.chunk0.at4:foo
+const dataAnchorModifier = /^(?:chunk|at)[0-9]+$/;
+
 export default function suggest(
   query: string,
   cursorOffset: number,
@@ -67,9 +72,21 @@ export default function suggest(
     const text = targetElem.converter.text;
     const start = targetElem.converter.col;
     const end = start + (text.length ?? 0) - 1;
-    const suggestions = converters
-      .filter((c) => c.Name.startsWith(value) && c.Name !== value)
-      .map((c) => c.Name);
+    // the converter name may be prefixed by modifiers of the data filter
+    let modifier = "";
+    for (;;) {
+      const dot = value.indexOf(".", modifier.length);
+      if (dot === -1) break;
+      const m = value.slice(modifier.length, dot).toLowerCase();
+      if (!dataModifiers.includes(m) && !dataAnchorModifier.test(m)) break;
+      modifier = value.slice(0, dot + 1);
+    }
+    const names = converters.map((c) => c.Name);
+    const name = value.slice(modifier.length);
+    if (!name.includes(".")) names.push(...dataModifiers);
+    const suggestions = names
+      .filter((n) => n.startsWith(name) && n !== name)
+      .map((n) => modifier + n);
     return {
       suggestions,
       start,
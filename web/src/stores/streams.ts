@@ -10,6 +10,7 @@ interface State {
   error: string | null;
   result: SearchResult | null;
   outdated: boolean;
+  cursor: string | null;
 }
 
 export const useStreamsStore = defineStore("streams", {
@@ -20,17 +21,35 @@ export const useStreamsStore = defineStore("streams", {
     error: null,
     result: null,
     outdated: false,
+    cursor: null,
   }),
   actions: {
-    async searchStreams(query: string, page: number) {
+    async searchStreams(query: string, page: number): Promise<void> {
       if (!page) page = 0;
+      // keep paging through the view pinned by the cursor of the first page
+      const cursor =
+        page !== 0 && query === this.query && this.cursor !== null
+          ? this.cursor
+          : undefined;
+      // a new search does not need the view of the previous one anymore
+      const releaseCursor =
+        cursor === undefined && this.cursor !== null ? this.cursor : undefined;
+      if (releaseCursor !== undefined) this.cursor = null;
       this.query = query;
       this.page = page;
       this.running = true;
       this.error = null;
       this.result = null;
       this.outdated = false;
-      return APIClient.searchStreams(query, page)
+      return APIClient.searchStreams(
+        query,
+        page,
+        false,
+        cursor,
+        false,
+        false,
+        releaseCursor,
+      )
         .then((data) => {
           if ("Error" in data) {
             this.error = data.Error;
@@ -39,6 +58,8 @@ export const useStreamsStore = defineStore("streams", {
           } else {
             this.error = null;
             this.result = data;
+            // there is no cursor if the result has a single page
+            this.cursor = data.Cursor !== "" ? data.Cursor : null;
           }
           this.query = query;
           this.page = page;
@@ -47,6 +68,11 @@ export const useStreamsStore = defineStore("streams", {
         .catch((err: unknown) => {
           if (axios.isCancel(err)) return;
           if (axios.isAxiosError<string, unknown>(err)) {
+            if (cursor !== undefined && err.response?.status === 410) {
+              // the cursor expired, search the current view instead
+              this.cursor = null;
+              return this.searchStreams(query, page);
+            }
             this.query = query;
             this.page = page;
             this.running = false;